@@ -1,31 +1,40 @@
 package main
 
-import "log"
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+)
 
 // Attr contains values for all VCS attributes.
 type Attr struct {
-	VCS                 VCSType
-	RevisionShort       string
-	Branch              string // Hg: bookmark?
-	Tag                 string // latest of reachable from current commit
-	State               VCSState
-	HasRemote           bool
-	CommitsAheadRemote  int
-	CommitsBehindRemote int
-	HasStashedCommits   bool
-	StashedCommits      int
-	IsDirty             bool
-	HasAddedFiles       bool
-	AddedFiles          int
-	HasModifiedFiles    bool // Git: in index and/or workdir
-	ModifiedFiles       int
-	HasDeletedFiles     bool
-	DeletedFiles        int
-	HasRenamedFiles     bool
-	RenamedFiles        int
-	HasUnmergedFiles    bool
-	UnmergedFiles       int
-	HasUntrackedFiles   bool // not include ignored files
+	VCS                 VCSType  `json:"vcs"`
+	RevisionShort       string   `json:"revision_short"`
+	Branch              string   `json:"branch"` // Hg: bookmark?
+	Tag                 string   `json:"tag"`    // latest of reachable from current commit
+	State               VCSState `json:"state"`
+	HasRemote           bool     `json:"has_remote"`
+	CommitsAheadRemote  int      `json:"commits_ahead_remote"`
+	CommitsBehindRemote int      `json:"commits_behind_remote"`
+	HasStashedCommits   bool     `json:"has_stashed_commits"`
+	StashedCommits      int      `json:"stashed_commits"`
+	IsDirty             bool     `json:"is_dirty"`
+	HasAddedFiles       bool     `json:"has_added_files"`
+	AddedFiles          int      `json:"added_files"`
+	HasModifiedFiles    bool     `json:"has_modified_files"` // Git: in index and/or workdir
+	ModifiedFiles       int      `json:"modified_files"`
+	HasDeletedFiles     bool     `json:"has_deleted_files"`
+	DeletedFiles        int      `json:"deleted_files"`
+	HasRenamedFiles     bool     `json:"has_renamed_files"`
+	RenamedFiles        int      `json:"renamed_files"`
+	HasUnmergedFiles    bool     `json:"has_unmerged_files"`
+	UnmergedFiles       int      `json:"unmerged_files"`
+	HasUntrackedFiles   bool     `json:"has_untracked_files"`  // not include ignored files
+	ChangeID            string   `json:"change_id"`            // Jj: of working copy
+	IsDescriptionEmpty  bool     `json:"is_description_empty"` // Jj: of working copy
+	TimedOut            bool     `json:"timed_out"`            // some facts wasn't detected in time
 	// TODO Patch info
 }
 
@@ -34,46 +43,166 @@ type Attr struct {
 // Some implementations may ignore some attributes because of performance
 // issues or because they isn't implemented yet.
 type AttrList struct {
-	VCS                 bool
-	RevisionShort       bool
-	Branch              bool
-	Tag                 bool
-	State               bool
-	HasRemote           bool
-	CommitsAheadRemote  bool
-	CommitsBehindRemote bool
-	HasStashedCommits   bool
-	StashedCommits      bool
-	IsDirty             bool
-	HasAddedFiles       bool
-	AddedFiles          bool
-	HasModifiedFiles    bool
-	ModifiedFiles       bool
-	HasDeletedFiles     bool
-	DeletedFiles        bool
-	HasRenamedFiles     bool
-	RenamedFiles        bool
-	HasUnmergedFiles    bool
-	UnmergedFiles       bool
-	HasUntrackedFiles   bool
+	VCS                 bool `json:"vcs"`
+	RevisionShort       bool `json:"revision_short"`
+	Branch              bool `json:"branch"`
+	Tag                 bool `json:"tag"`
+	State               bool `json:"state"`
+	HasRemote           bool `json:"has_remote"`
+	CommitsAheadRemote  bool `json:"commits_ahead_remote"`
+	CommitsBehindRemote bool `json:"commits_behind_remote"`
+	HasStashedCommits   bool `json:"has_stashed_commits"`
+	StashedCommits      bool `json:"stashed_commits"`
+	IsDirty             bool `json:"is_dirty"`
+	HasAddedFiles       bool `json:"has_added_files"`
+	AddedFiles          bool `json:"added_files"`
+	HasModifiedFiles    bool `json:"has_modified_files"`
+	ModifiedFiles       bool `json:"modified_files"`
+	HasDeletedFiles     bool `json:"has_deleted_files"`
+	DeletedFiles        bool `json:"deleted_files"`
+	HasRenamedFiles     bool `json:"has_renamed_files"`
+	RenamedFiles        bool `json:"renamed_files"`
+	HasUnmergedFiles    bool `json:"has_unmerged_files"`
+	UnmergedFiles       bool `json:"unmerged_files"`
+	HasUntrackedFiles   bool `json:"has_untracked_files"`
+	ChangeID            bool `json:"change_id"`
+	IsDescriptionEmpty  bool `json:"is_description_empty"`
+	TimedOut            bool `json:"timed_out"`
+}
+
+// String returns comma-separated names of enabled attributes.
+func (l *AttrList) String() string {
+	var names []string
+	v := reflect.ValueOf(l).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Bool() {
+			names = append(names, v.Type().Field(i).Name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// allAttrs returns list with all attributes enabled.
+func allAttrs() AttrList {
+	var l AttrList
+	v := reflect.ValueOf(&l).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		v.Field(i).SetBool(true)
+	}
+	return l
+}
+
+// Set enables only attributes listed in comma-separated s (using same
+// names as Attr fields). It implements flag.Value.
+func (l *AttrList) Set(s string) error {
+	*l = AttrList{}
+	v := reflect.ValueOf(l).Elem()
+	for _, name := range strings.Split(s, ",") {
+		f := v.FieldByName(strings.TrimSpace(name))
+		if !f.IsValid() {
+			return fmt.Errorf("unknown attribute: %q", name)
+		}
+		f.SetBool(true)
+	}
+	return nil
+}
+
+// Without returns attributes enabled in l but not in other.
+func (l AttrList) Without(other AttrList) AttrList {
+	v := reflect.ValueOf(&l).Elem()
+	o := reflect.ValueOf(other)
+	for i := 0; i < v.NumField(); i++ {
+		if o.Field(i).Bool() {
+			v.Field(i).SetBool(false)
+		}
+	}
+	return l
+}
+
+// merge copies attributes enabled in mask from src.
+func (l *AttrList) merge(src, mask AttrList) {
+	v := reflect.ValueOf(l).Elem()
+	srcv := reflect.ValueOf(src)
+	maskv := reflect.ValueOf(mask)
+	for i := 0; i < v.NumField(); i++ {
+		if maskv.Field(i).Bool() {
+			v.Field(i).Set(srcv.Field(i))
+		}
+	}
+}
+
+// merge copies attributes enabled in mask from src.
+func (a *Attr) merge(src Attr, mask AttrList) {
+	v := reflect.ValueOf(a).Elem()
+	srcv := reflect.ValueOf(src)
+	maskv := reflect.ValueOf(mask)
+	for i := 0; i < v.NumField(); i++ {
+		if maskv.Field(i).Bool() {
+			v.Field(i).Set(srcv.Field(i))
+		}
+	}
 }
 
 // Request describe requested attributes and options which control
 // detection of these attributes.
 // Some implementations may ignore some options.
 type Request struct {
-	Attr                AttrList
-	DirtyIfUntracked    bool
-	RenamesFromRewrites bool
-	IncludeSubmodules   bool
+	Attr                AttrList  `json:"attr"`
+	DirtyIfUntracked    bool      `json:"dirty_if_untracked"`
+	RenamesFromRewrites bool      `json:"renames_from_rewrites"`
+	IncludeSubmodules   bool      `json:"include_submodules"`
+	GitEngine           string    `json:"git_engine"` // see gitEngines, empty means default
+	GitRoutes           GitRoutes `json:"git_routes"` // see gitRoutes, overrides defaultGitRoutes
+	Approx              AttrList  `json:"approx"`     // may be detected approximately (with dependencies), if it's faster
 }
 
 // Facts contains raw result of repo analysis: which attributes was
 // checked, how they was checked (options), detected values.
 type Facts struct {
-	Req    Request
-	Lookup AttrList
-	Found  Attr
+	Repo     Repo
+	Req      Request
+	Lookup   AttrList
+	Found    Attr
+	TimedOut AttrList // looked up but not (fully) detected before deadline
+	Approx   AttrList // detected approximately, see Req.Approx
+}
+
+// MarshalJSON implements json.Marshaler.
+// Values of found attributes which wasn't looked up and values of
+// resulting attributes which wasn't requested are output as null.
+func (f Facts) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Repo     Repo                   `json:"repo"`
+		Req      Request                `json:"req"`
+		Lookup   AttrList               `json:"lookup"`
+		TimedOut AttrList               `json:"timed_out"`
+		Approx   AttrList               `json:"approx"`
+		Found    map[string]interface{} `json:"found"`
+		Result   map[string]interface{} `json:"result"`
+	}{
+		Repo:     f.Repo,
+		Req:      f.Req,
+		Lookup:   f.Lookup,
+		TimedOut: f.TimedOut,
+		Approx:   f.Approx,
+		Found:    attrJSON(f.Lookup, f.Found),
+		Result:   attrJSON(f.Req.Attr, f.Result()),
+	})
+}
+
+func attrJSON(l AttrList, res Attr) map[string]interface{} {
+	m := make(map[string]interface{})
+	list := reflect.ValueOf(l)
+	attr := reflect.ValueOf(res)
+	for i := 0; i < attr.NumField(); i++ {
+		name := attr.Type().Field(i).Tag.Get("json")
+		if list.Field(i).Bool() {
+			m[name] = attr.Field(i).Interface()
+		} else {
+			m[name] = nil
+		}
+	}
+	return m
 }
 
 // Result returns requested repo attributes based on detected facts.
@@ -92,6 +221,7 @@ func (f Facts) Result() Attr {
 		res.HasAddedFiles || res.HasModifiedFiles || res.HasDeletedFiles ||
 		res.HasRenamedFiles || res.HasUnmergedFiles ||
 		(res.HasUntrackedFiles && f.Req.DirtyIfUntracked)
+	res.TimedOut = res.TimedOut || f.TimedOut != AttrList{}
 
 	return f.Req.Filter(res)
 }
@@ -170,12 +300,25 @@ func (req Request) Filter(res Attr) Attr {
 	if !req.Attr.HasUntrackedFiles {
 		res.HasUntrackedFiles = z.HasUntrackedFiles
 	}
+	if !req.Attr.ChangeID {
+		res.ChangeID = z.ChangeID
+	}
+	if !req.Attr.IsDescriptionEmpty {
+		res.IsDescriptionEmpty = z.IsDescriptionEmpty
+	}
+	if !req.Attr.TimedOut {
+		res.TimedOut = z.TimedOut
+	}
 	return res
 }
 
 // QA will log all found attributes which shouldn't have been checked -
 // this probably means extra work was done while analysing repo.
 func (f Facts) QA() {
+	approx := f.Approx.Without(f.Req.Approx)
+	if approx = approx.Without(approx.Without(f.Req.Attr)); approx != (AttrList{}) {
+		log.Print("QA notice: not accepted approximate ", &approx)
+	}
 	var z Attr
 	if !f.Lookup.VCS && f.Found.VCS != z.VCS {
 		log.Print("QA notice: redundant VCS")
@@ -243,4 +386,13 @@ func (f Facts) QA() {
 	if !f.Lookup.HasUntrackedFiles && f.Found.HasUntrackedFiles != z.HasUntrackedFiles {
 		log.Print("QA notice: redundant HasUntrackedFiles")
 	}
+	if !f.Lookup.ChangeID && f.Found.ChangeID != z.ChangeID {
+		log.Print("QA notice: redundant ChangeID")
+	}
+	if !f.Lookup.IsDescriptionEmpty && f.Found.IsDescriptionEmpty != z.IsDescriptionEmpty {
+		log.Print("QA notice: redundant IsDescriptionEmpty")
+	}
+	if !f.Lookup.TimedOut && f.Found.TimedOut != z.TimedOut {
+		log.Print("QA notice: redundant TimedOut")
+	}
 }
@@ -1,4 +1,102 @@
 package main
 
+import (
+	"encoding/json"
+	"reflect"
+
+	. "gopkg.in/check.v1"
+)
+
 // TODO Ensure Attr and AttrList have same fields and all field types in
 // AttrList is bool.
+
+type AttrSuite struct{}
+
+var _ = Suite(&AttrSuite{})
+
+func (s *AttrSuite) TestAttrListFlag(c *C) {
+	var l AttrList
+	c.Check(l.String(), Equals, "")
+	c.Check(l.Set("Branch, IsDirty"), IsNil)
+	c.Check(l, DeepEquals, AttrList{Branch: true, IsDirty: true})
+	c.Check(l.String(), Equals, "Branch,IsDirty")
+	c.Check(l.Set("VCS"), IsNil)
+	c.Check(l, DeepEquals, AttrList{VCS: true}) // replace, not append
+	c.Check(l.Set("Branch,Nope"), ErrorMatches, `unknown attribute: "Nope"`)
+	c.Check(l.Set(""), NotNil)
+}
+
+func (s *AttrSuite) TestAttrListWithout(c *C) {
+	l := AttrList{VCS: true, Branch: true, Tag: true}
+	c.Check(l.Without(AttrList{Branch: true, IsDirty: true}), DeepEquals, AttrList{VCS: true, Tag: true})
+	c.Check(l, DeepEquals, AttrList{VCS: true, Branch: true, Tag: true})
+	c.Check(l.Without(l), DeepEquals, AttrList{})
+}
+
+func (s *AttrSuite) TestMerge(c *C) {
+	mask := AttrList{Branch: true, IsDirty: true}
+	a := Attr{VCS: VCSGit, Branch: "master", IsDirty: true}
+	a.merge(Attr{Tag: "v1.0.0", Branch: "fix"}, mask)
+	c.Check(a, DeepEquals, Attr{VCS: VCSGit, Branch: "fix"})
+	l := AttrList{VCS: true, Branch: true}
+	l.merge(AttrList{Tag: true, IsDirty: true}, mask)
+	c.Check(l, DeepEquals, AttrList{VCS: true, IsDirty: true})
+}
+
+func (s *AttrSuite) TestFactsTimedOut(c *C) {
+	facts := Facts{
+		Req:   Request{Attr: AttrList{VCS: true, Tag: true, TimedOut: true}},
+		Found: Attr{VCS: VCSGit},
+	}
+	c.Check(facts.Result(), DeepEquals, Attr{VCS: VCSGit})
+	facts.TimedOut.Tag = true
+	c.Check(facts.Result(), DeepEquals, Attr{VCS: VCSGit, TimedOut: true})
+	facts.Req.Attr.TimedOut = false
+	c.Check(facts.Result(), DeepEquals, Attr{VCS: VCSGit})
+}
+
+func (s *AttrSuite) TestFactsJSON(c *C) {
+	facts := Facts{
+		Req: Request{
+			Attr:             AttrList{VCS: true, Branch: true, State: true, IsDirty: true},
+			DirtyIfUntracked: true,
+		},
+		Lookup: AttrList{VCS: true, Branch: true, State: true, HasModifiedFiles: true, ModifiedFiles: true},
+		Found: Attr{
+			VCS:           VCSGit,
+			Branch:        "",
+			State:         StateRebaseMerge,
+			ModifiedFiles: 2,
+		},
+		TimedOut: AttrList{HasModifiedFiles: true},
+	}
+	buf, err := json.Marshal(facts)
+	c.Assert(err, IsNil)
+	var got struct {
+		Req      map[string]interface{}
+		Lookup   map[string]bool
+		TimedOut map[string]bool `json:"timed_out"`
+		Found    map[string]interface{}
+		Result   map[string]interface{}
+	}
+	c.Assert(json.Unmarshal(buf, &got), IsNil)
+
+	c.Check(got.Req["dirty_if_untracked"], Equals, true)
+	c.Check(got.Req["attr"].(map[string]interface{})["is_dirty"], Equals, true)
+	c.Check(got.Lookup["modified_files"], Equals, true)
+	c.Check(got.Lookup["tag"], Equals, false)
+	c.Check(got.TimedOut["has_modified_files"], Equals, true)
+	c.Check(got.TimedOut["branch"], Equals, false)
+	c.Check(got.Found, HasLen, reflect.TypeOf(Attr{}).NumField())
+	c.Check(got.Found["vcs"], Equals, "git")
+	c.Check(got.Found["branch"], Equals, "")
+	c.Check(got.Found["state"], Equals, "rebase-m")
+	c.Check(got.Found["modified_files"], Equals, 2.0)
+	c.Check(got.Found["is_dirty"], IsNil)
+	c.Check(got.Found["tag"], IsNil)
+	c.Check(got.Result, HasLen, reflect.TypeOf(Attr{}).NumField())
+	c.Check(got.Result["is_dirty"], Equals, true)
+	c.Check(got.Result["modified_files"], IsNil)
+	c.Check(got.Result["tag"], IsNil)
+	c.Check(got.Result["timed_out"], IsNil)
+}
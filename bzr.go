@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bazaar/Breezy support: cheap facts (revno, branch nick, tag, state,
+// shelves) are read from .bzr directly, IsDirty is detected using
+// dirstate, everything else is detected by executing `brz status`
+// (or `bzr status` if brz isn't installed).
+//
+// RevisionShort contains revno. Tag contains tag only if it points to
+// the last revision: finding latest reachable tag needs revision graph,
+// which is stored in repository packs and isn't read natively, while
+// executing bzr just for a tag is too slow for a prompt.
+// Shelves are reported as stashed commits.
+// Lightweight checkouts are supported only for local branches.
+// Remote-related facts require network access and aren't supported.
+
+// bzrAttrs are attributes supported by VCSInfoBzr.
+var bzrAttrs = AttrList{
+	VCS:               true,
+	RevisionShort:     true,
+	Branch:            true,
+	Tag:               true,
+	State:             true,
+	HasStashedCommits: true,
+	StashedCommits:    true,
+	IsDirty:           true,
+	HasAddedFiles:     true,
+	AddedFiles:        true,
+	HasModifiedFiles:  true,
+	ModifiedFiles:     true,
+	HasDeletedFiles:   true,
+	DeletedFiles:      true,
+	HasRenamedFiles:   true,
+	RenamedFiles:      true,
+	HasUnmergedFiles:  true,
+	UnmergedFiles:     true,
+	HasUntrackedFiles: true,
+}
+
+const bzrDirstateHeader = "#bazaar dirstate flat format 3\n"
+
+var (
+	errBzrDirstate = errors.New("bzr: bad dirstate")
+	errBzrTags     = errors.New("bzr: bad tags")
+)
+
+var reBzrNick = regexp.MustCompile(`(?m)^\s*nickname\s*=\s*(.*?)\s*$`)
+
+// VCSInfoBzr returns bazaar facts for current repo.
+func VCSInfoBzr(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSBazaar {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(bzrAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasUnmergedFiles = l.HasUnmergedFiles || l.UnmergedFiles
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.HasStashedCommits = l.HasStashedCommits || l.StashedCommits
+	l.VCS = true
+
+	bzrDir := filepath.Join(facts.Repo.Root, ".bzr")
+	facts.Found.VCS = VCSBazaar
+
+	if l.RevisionShort || l.Branch || l.Tag {
+		branchDir, err := bzrBranchDir(bzrDir)
+		if err != nil {
+			log.Println("bzrBranchDir:", err)
+		} else {
+			bzrBranch(branchDir, facts)
+		}
+	}
+	if l.State {
+		facts.Found.State = bzrState(bzrDir)
+	}
+	if l.HasStashedCommits {
+		facts.Found.StashedCommits = bzrShelves(bzrDir)
+		facts.Found.HasStashedCommits = facts.Found.StashedCommits > 0
+		l.StashedCommits = true
+	}
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  statusAttrs,
+			detect: bzrStatus,
+		},
+	})
+}
+
+// bzrBranchDir returns branch control dir (it differs from bzrDir/branch
+// for lightweight checkouts).
+func bzrBranchDir(bzrDir string) (string, error) {
+	branchDir := filepath.Join(bzrDir, "branch")
+	buf, err := ioutil.ReadFile(filepath.Join(branchDir, "location"))
+	if os.IsNotExist(err) {
+		return branchDir, nil
+	} else if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(string(bytes.TrimSpace(buf)))
+	if err != nil {
+		return "", err
+	} else if u.Scheme != "file" {
+		return "", errors.New("bzr: remote branch isn't supported: " + u.String())
+	}
+	return filepath.Join(filepath.FromSlash(u.Path), ".bzr", "branch"), nil
+}
+
+// bzrBranch detects revno, branch nick and tag.
+func bzrBranch(branchDir string, facts *Facts) {
+	l := &facts.Lookup
+	revno, revid := "0", "null:"
+	buf, err := ioutil.ReadFile(filepath.Join(branchDir, "last-revision"))
+	if err != nil {
+		log.Println("bzr last-revision:", err)
+		return
+	} else if fields := strings.Fields(string(buf)); len(fields) == 2 {
+		revno, revid = fields[0], fields[1]
+	}
+	if l.RevisionShort && revno != "0" {
+		facts.Found.RevisionShort = revno
+	}
+	if l.Tag && revid != "null:" {
+		facts.Found.Tag = bzrTag(branchDir, revid)
+	}
+	if l.Branch {
+		facts.Found.Branch = bzrNick(branchDir)
+	}
+}
+
+// bzrNick returns configured branch nick or branch dir name.
+func bzrNick(branchDir string) string {
+	buf, _ := ioutil.ReadFile(filepath.Join(branchDir, "branch.conf"))
+	if m := reBzrNick.FindSubmatch(buf); m != nil && len(m[1]) > 0 {
+		return string(m[1])
+	}
+	return filepath.Base(filepath.Dir(filepath.Dir(branchDir)))
+}
+
+// bzrTag returns name of tag pointing to revid (first one in sort order
+// if there are more than one).
+func bzrTag(branchDir, revid string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(branchDir, "tags"))
+	if err != nil || len(buf) == 0 {
+		return ""
+	}
+	tags, err := parseBzrTags(buf)
+	if err != nil {
+		log.Println("bzr tags:", err)
+		return ""
+	}
+	var names []string
+	for name, id := range tags {
+		if id == revid {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// parseBzrTags parses bencoded dict of tag names and revids.
+func parseBzrTags(buf []byte) (map[string]string, error) {
+	if len(buf) < 2 || buf[0] != 'd' || buf[len(buf)-1] != 'e' {
+		return nil, errBzrTags
+	}
+	buf = buf[1 : len(buf)-1]
+	next := func() (string, bool) {
+		i := bytes.IndexByte(buf, ':')
+		if i < 0 {
+			return "", false
+		}
+		size, err := strconv.Atoi(string(buf[:i]))
+		if err != nil || size < 0 || i+1+size > len(buf) {
+			return "", false
+		}
+		s := string(buf[i+1 : i+1+size])
+		buf = buf[i+1+size:]
+		return s, true
+	}
+	tags := make(map[string]string)
+	for len(buf) > 0 {
+		name, ok1 := next()
+		revid, ok2 := next()
+		if !ok1 || !ok2 {
+			return nil, errBzrTags
+		}
+		tags[name] = revid
+	}
+	return tags, nil
+}
+
+// bzrState detects merge using amount of dirstate parents.
+func bzrState(bzrDir string) VCSState {
+	ds, err := readBzrDirstate(bzrDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("bzr dirstate:", err)
+		}
+		return StateNone
+	}
+	if len(ds.parents) > 1 {
+		return StateMerge
+	}
+	return StateNone
+}
+
+// bzrShelves returns amount of shelved changes.
+func bzrShelves(bzrDir string) (n int) {
+	files, err := ioutil.ReadDir(filepath.Join(bzrDir, "checkout", "shelf"))
+	if err != nil {
+		return 0
+	}
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), "shelf-") {
+			n++
+		}
+	}
+	return n
+}
+
+func bzrStatus(ctx context.Context, facts *Facts) {
+	l := &facts.Lookup
+	needCounters := l.HasAddedFiles || l.HasModifiedFiles || l.HasDeletedFiles ||
+		l.HasRenamedFiles || l.HasUnmergedFiles
+	needUntracked := l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked)
+	if !needCounters && !needUntracked {
+		ds, err := readBzrDirstate(filepath.Join(facts.Repo.Root, ".bzr"))
+		if err == nil {
+			facts.Found.IsDirty = ds.isDirty(facts.Repo.Root)
+			return
+		}
+		log.Println("bzr dirstate:", err)
+	}
+
+	out, err := bzr(ctx, facts, "status", "--short", "--no-pending")
+	if err != nil {
+		return
+	}
+	parseBzrStatus(out, &facts.Found)
+	// These counters are detected anyway.
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.HasRenamedFiles, l.RenamedFiles = true, true
+	l.HasUnmergedFiles, l.UnmergedFiles = true, true
+	l.HasUntrackedFiles = true
+}
+
+// bzr executes brz (or bzr) command in facts.Repo and returns it's
+// stdout.
+func bzr(ctx context.Context, facts *Facts, args ...string) ([]byte, error) {
+	name := "brz"
+	if _, err := exec.LookPath(name); err != nil {
+		name = "bzr"
+	}
+	args = append([]string{"--no-aliases"}, args...)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = facts.Repo.Root
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.Printf("%s %s: %s", name, strings.Join(args, " "), err)
+	}
+	return out, err
+}
+
+// parseBzrStatus parses output of brz status --short.
+// Each line has 3 status columns: versioning (+-R?CP), content (NDKM)
+// and execute bit (*), then a space and a path.
+func parseBzrStatus(out []byte, found *Attr) {
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 5 {
+			continue
+		}
+		switch versioning, content, execute := line[0], line[1], line[2]; {
+		case versioning == '?':
+			found.HasUntrackedFiles = true
+		case versioning == 'C':
+			found.UnmergedFiles++
+		case versioning == 'R':
+			found.RenamedFiles++
+		case versioning == '+' || content == 'N':
+			found.AddedFiles++
+		case versioning == '-' || content == 'D':
+			found.DeletedFiles++
+		case content == 'M' || content == 'K' || execute == '*':
+			found.ModifiedFiles++
+		case versioning == 'P':
+		default:
+			log.Printf("bzr status: unexpected line: %q", line)
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+	found.HasUnmergedFiles = found.UnmergedFiles > 0
+}
+
+// bzrTree is a state of dirstate entry in one of trees.
+type bzrTree struct {
+	kind        byte // 'f'ile, 'd'ir, 'l'ink, 't'ree ref, 'a'bsent, 'r'elocated
+	fingerprint string
+	size        int64
+	executable  bool
+	packedStat  string // for working tree only
+}
+
+func (t bzrTree) isPresent() bool {
+	return t.kind != 'a' && t.kind != 'r'
+}
+
+type bzrEntry struct {
+	path    string   // relative to root, slash-separated
+	working bzrTree  // current working tree
+	basis   *bzrTree // first parent tree or nil
+}
+
+type bzrDirstate struct {
+	parents []string
+	entries []bzrEntry
+}
+
+// readBzrDirstate reads .bzr/checkout/dirstate.
+func readBzrDirstate(bzrDir string) (*bzrDirstate, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(bzrDir, "checkout", "dirstate"))
+	if err != nil {
+		return nil, err
+	}
+	return parseBzrDirstate(buf)
+}
+
+// parseBzrDirstate parses dirstate in "flat format 3".
+func parseBzrDirstate(buf []byte) (*bzrDirstate, error) {
+	if !bytes.HasPrefix(buf, []byte(bzrDirstateHeader)) {
+		return nil, errBzrDirstate
+	}
+	buf = buf[len(bzrDirstateHeader):]
+	for _, prefix := range []string{"crc32: ", "num_entries: "} {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 || !bytes.HasPrefix(buf, []byte(prefix)) {
+			return nil, errBzrDirstate
+		}
+		buf = buf[i+1:]
+	}
+	lines := strings.Split(string(buf), "\x00\n\x00")
+	if len(lines) < 3 || lines[len(lines)-1] != "" {
+		return nil, errBzrDirstate
+	}
+	parents := strings.Split(lines[0], "\x00")
+	if n, err := strconv.Atoi(parents[0]); err != nil || n != len(parents)-1 {
+		return nil, errBzrDirstate
+	}
+	ds := &bzrDirstate{parents: parents[1:]}
+
+	const keyFields, treeFields = 3, 5
+	numFields := keyFields + treeFields*(1+len(ds.parents))
+	for _, line := range lines[2 : len(lines)-1] {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != numFields {
+			return nil, errBzrDirstate
+		}
+		var entry bzrEntry
+		entry.path = fields[1]
+		if fields[0] != "" {
+			entry.path = fields[0] + "/" + fields[1]
+		}
+		var trees []bzrTree
+		for i := keyFields; i < numFields; i += treeFields {
+			t := fields[i : i+treeFields]
+			size, err := strconv.ParseInt(t[2], 10, 64)
+			if len(t[0]) != 1 || err != nil {
+				return nil, errBzrDirstate
+			}
+			trees = append(trees, bzrTree{
+				kind:        t[0][0],
+				fingerprint: t[1],
+				size:        size,
+				executable:  t[3] == "y",
+				packedStat:  t[4],
+			})
+		}
+		entry.working = trees[0]
+		if len(trees) > 1 {
+			entry.basis = &trees[1]
+		}
+		ds.entries = append(ds.entries, entry)
+	}
+	return ds, nil
+}
+
+// bzrPackStat returns stat in dirstate format, except ctime, dev and ino
+// (which are always zero).
+func bzrPackStat(fi os.FileInfo) string {
+	var buf [24]byte
+	binary.BigEndian.PutUint32(buf[0:], uint32(fi.Size()))
+	binary.BigEndian.PutUint32(buf[4:], uint32(fi.ModTime().Unix()))
+	binary.BigEndian.PutUint32(buf[20:], uint32(fi.Mode().Perm()))
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
+
+// isBzrStatMatch compares size, mtime and permissions of file with
+// packed stat.
+func isBzrStatMatch(packedStat string, fi os.FileInfo) bool {
+	buf, err := base64.StdEncoding.DecodeString(packedStat)
+	if err != nil || len(buf) != 24 {
+		return false
+	}
+	want, _ := base64.StdEncoding.DecodeString(bzrPackStat(fi))
+	return bytes.Equal(buf[0:8], want[0:8]) &&
+		binary.BigEndian.Uint32(buf[20:])&0777 == binary.BigEndian.Uint32(want[20:])
+}
+
+// isDirty compares working tree with basis tree.
+func (ds *bzrDirstate) isDirty(root string) bool {
+	for _, e := range ds.entries {
+		if e.path == "" {
+			continue
+		}
+		basis := bzrTree{kind: 'a'}
+		if e.basis != nil {
+			basis = *e.basis
+		}
+		if !e.working.isPresent() && !basis.isPresent() {
+			continue
+		}
+		if e.working.kind != basis.kind { // added, removed, renamed
+			return true
+		}
+		name := filepath.Join(root, filepath.FromSlash(e.path))
+		fi, err := os.Lstat(name)
+		if err != nil {
+			return true
+		}
+		switch basis.kind {
+		case 'd', 't':
+			if !fi.IsDir() {
+				return true
+			}
+		case 'l':
+			target, err := os.Readlink(name)
+			if err != nil || target != basis.fingerprint {
+				return true
+			}
+		case 'f':
+			if !fi.Mode().IsRegular() || fi.Size() != basis.size ||
+				(fi.Mode()&0111 != 0) != basis.executable {
+				return true
+			}
+			if e.working.fingerprint == basis.fingerprint && isBzrStatMatch(e.working.packedStat, fi) {
+				continue
+			}
+			if bzrFileSHA1(name) != basis.fingerprint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func bzrFileSHA1(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
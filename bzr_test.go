@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type BzrSuite struct {
+	root   string
+	bzrDir string
+	req    Request
+}
+
+var _ = Suite(&BzrSuite{})
+
+func (s *BzrSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:            true,
+			RevisionShort:  true,
+			Branch:         true,
+			Tag:            true,
+			State:          true,
+			StashedCommits: true,
+			IsDirty:        true,
+		},
+	}
+	s.root = filepath.Join(c.MkDir(), "project")
+	s.bzrDir = filepath.Join(s.root, ".bzr")
+	for _, dir := range []string{"branch", "checkout"} {
+		c.Assert(os.MkdirAll(filepath.Join(s.bzrDir, dir), 0755), IsNil)
+	}
+}
+
+func (s *BzrSuite) VCSInfoBzr(c *C) Attr {
+	facts := Facts{Repo: Repo{VCS: VCSBazaar, Root: s.root}, Req: s.req}
+	VCSInfoBzr(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *BzrSuite) write(c *C, name, data string) {
+	c.Assert(ioutil.WriteFile(filepath.Join(s.bzrDir, name), []byte(data), 0644), IsNil)
+}
+
+// testBzrEntry returns dirstate entry for path with given working and
+// basis trees (each is "kind\0fingerprint\0size\0executable\0stat").
+func testBzrEntry(path string, trees ...string) string {
+	dir, base := filepath.Split(path)
+	return strings.Join(append([]string{strings.TrimSuffix(dir, "/"), base, "id-" + path}, trees...), "\x00")
+}
+
+func testBzrDirstate(parents []string, entries ...string) string {
+	var rootTrees []string
+	for range append(parents, "") {
+		rootTrees = append(rootTrees, testBzrTree("d", "", 0, "x"))
+	}
+	lines := append([]string{
+		strings.Join(append([]string{strconv.Itoa(len(parents))}, parents...), "\x00"),
+		"0",
+		testBzrEntry("", rootTrees...),
+	}, entries...)
+	lines = append(lines, "")
+	return bzrDirstateHeader + "crc32: 0\n" + "num_entries: " + strconv.Itoa(len(lines)-3) + "\n" +
+		strings.Join(lines, "\x00\n\x00")
+}
+
+func testBzrTree(kind, fingerprint string, size int, stat string) string {
+	return strings.Join([]string{kind, fingerprint, strconv.Itoa(size), "n", stat}, "\x00")
+}
+
+func (s *BzrSuite) TestParseStatus(c *C) {
+	out := "+N  added.txt\n M  mod.txt\n  * exec.sh\n K  kind\n-D  removed.txt\n D  missing.txt\nR   old.txt => new.txt\n?   junk.txt\nC   conflict.txt\n"
+	var found Attr
+	parseBzrStatus([]byte(out), &found)
+	c.Check(found, DeepEquals, Attr{
+		HasAddedFiles:     true,
+		AddedFiles:        1,
+		HasModifiedFiles:  true,
+		ModifiedFiles:     3,
+		HasDeletedFiles:   true,
+		DeletedFiles:      2,
+		HasRenamedFiles:   true,
+		RenamedFiles:      1,
+		HasUnmergedFiles:  true,
+		UnmergedFiles:     1,
+		HasUntrackedFiles: true,
+	})
+
+	found = Attr{}
+	parseBzrStatus(nil, &found)
+	c.Check(found, DeepEquals, Attr{})
+}
+
+func (s *BzrSuite) TestParseTags(c *C) {
+	cases := []struct {
+		buf  string
+		want map[string]string
+	}{
+		{"de", map[string]string{}},
+		{"d4:v1.05:rev-14:v1.15:rev-2e", map[string]string{"v1.0": "rev-1", "v1.1": "rev-2"}},
+		{"d4:v1.0e", nil},
+		{"d4:v1.05:reve", nil},
+		{"l4:v1.0e", nil},
+		{"", nil},
+	}
+	for _, v := range cases {
+		tags, err := parseBzrTags([]byte(v.buf))
+		c.Check(err != nil, Equals, v.want == nil, Commentf("%q", v.buf))
+		c.Check(tags, DeepEquals, v.want, Commentf("%q", v.buf))
+	}
+}
+
+func (s *BzrSuite) TestParseDirstate(c *C) {
+	buf := testBzrDirstate([]string{"rev-1", "rev-2"},
+		testBzrEntry("dir/a.txt",
+			testBzrTree("f", "sha", 3, "stat"),
+			testBzrTree("f", "sha", 3, "rev-1"),
+			testBzrTree("a", "", 0, "")),
+	)
+	ds, err := parseBzrDirstate([]byte(buf))
+	c.Assert(err, IsNil)
+	c.Check(ds.parents, DeepEquals, []string{"rev-1", "rev-2"})
+	c.Assert(ds.entries, HasLen, 2)
+	c.Check(ds.entries[0].path, Equals, "")
+	c.Check(ds.entries[1], DeepEquals, bzrEntry{
+		path:    "dir/a.txt",
+		working: bzrTree{kind: 'f', fingerprint: "sha", size: 3, packedStat: "stat"},
+		basis:   &bzrTree{kind: 'f', fingerprint: "sha", size: 3, packedStat: "rev-1"},
+	})
+
+	ds, err = parseBzrDirstate([]byte(testBzrDirstate(nil)))
+	c.Assert(err, IsNil)
+	c.Check(ds.parents, HasLen, 0)
+	c.Check(ds.entries[0].basis, IsNil)
+
+	for _, bad := range []string{
+		"",
+		"#bazaar dirstate flat format 2\n",
+		bzrDirstateHeader + "num_entries: 0\n",
+		strings.Replace(buf, "\n2\x00rev-1", "\n3\x00rev-1", 1),
+		strings.Replace(buf, "\x00sha\x003\x00", "\x00sha\x00x\x00", 1),
+		buf[:len(buf)-1],
+	} {
+		_, err = parseBzrDirstate([]byte(bad))
+		c.Check(err, Equals, errBzrDirstate, Commentf("%q", bad))
+	}
+}
+
+func (s *BzrSuite) TestBzrNoRepo(c *C) {
+	s.root = c.MkDir()
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{VCS: VCSBazaar})
+}
+
+func (s *BzrSuite) TestBzrBranch(c *C) {
+	s.req.Attr.IsDirty = false
+	s.write(c, "branch/last-revision", "0 null:\n")
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{VCS: VCSBazaar, Branch: "project"})
+
+	s.write(c, "branch/last-revision", "42 user@host-20180101-abc\n")
+	s.write(c, "branch/branch.conf", "parent_location = x\nnickname = feature\n")
+	s.write(c, "branch/tags", "d4:v1.022:user@host-20180101-abc4:v0.95:rev-1e")
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{VCS: VCSBazaar, RevisionShort: "42", Branch: "feature", Tag: "v1.0"})
+
+	s.write(c, "branch/last-revision", "43 user@host-20180102-def\n")
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{VCS: VCSBazaar, RevisionShort: "43", Branch: "feature"}) // commit after tag
+
+	s.write(c, "branch/last-revision", "42 user@host-20180101-abc\n")
+	checkout := filepath.Join(c.MkDir(), "checkout")
+	c.Assert(os.MkdirAll(filepath.Join(checkout, ".bzr", "branch"), 0755), IsNil)
+	location := "file://" + filepath.ToSlash(s.root) + "/\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(checkout, ".bzr", "branch", "location"), []byte(location), 0644), IsNil)
+	s.root = checkout
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{VCS: VCSBazaar, RevisionShort: "42", Branch: "feature", Tag: "v1.0"})
+}
+
+func (s *BzrSuite) TestBzrStateShelves(c *C) {
+	s.req.Attr = AttrList{State: true, StashedCommits: true}
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{})
+
+	s.write(c, "checkout/dirstate", testBzrDirstate([]string{"rev-1", "rev-2"}))
+	c.Assert(os.Mkdir(filepath.Join(s.bzrDir, "checkout", "shelf"), 0755), IsNil)
+	s.write(c, "checkout/shelf/shelf-1", "")
+	s.write(c, "checkout/shelf/shelf-2", "")
+	s.write(c, "checkout/shelf/last-message", "")
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{State: StateMerge, StashedCommits: 2})
+}
+
+func (s *BzrSuite) TestBzrDirty(c *C) {
+	s.req.Attr = AttrList{IsDirty: true}
+	content := "content"
+	sum := sha1.Sum([]byte(content))
+	sha := hex.EncodeToString(sum[:])
+	name := filepath.Join(s.root, "a.txt")
+	c.Assert(ioutil.WriteFile(name, []byte(content), 0644), IsNil)
+	fi, err := os.Stat(name)
+	c.Assert(err, IsNil)
+
+	dirstate := func(working, basis string) {
+		s.write(c, "checkout/dirstate", testBzrDirstate([]string{"rev-1"},
+			testBzrEntry("a.txt", working, basis)))
+	}
+	clean := testBzrTree("f", sha, len(content), bzrPackStat(fi))
+	dirstate(clean, testBzrTree("f", sha, len(content), "rev-1"))
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{})
+
+	// Stat mismatch, but same content.
+	dirstate(testBzrTree("f", "", 0, "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), testBzrTree("f", sha, len(content), "rev-1"))
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{})
+
+	for _, basis := range []string{
+		testBzrTree("f", strings.Repeat("0", 40), len(content), "rev-1"), // modified
+		testBzrTree("f", sha, 1, "rev-1"),                                // resized
+		testBzrTree("a", "", 0, ""),                                      // added
+		testBzrTree("l", "target", 0, "rev-1"),                           // kind changed
+	} {
+		dirstate(clean, basis)
+		c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{IsDirty: true}, Commentf("%q", basis))
+	}
+
+	dirstate(testBzrTree("a", "", 0, ""), testBzrTree("f", sha, len(content), "rev-1")) // removed
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{IsDirty: true})
+
+	dirstate(clean, testBzrTree("f", sha, len(content), "rev-1"))
+	c.Assert(os.Remove(name), IsNil)
+	c.Check(s.VCSInfoBzr(c), DeepEquals, Attr{IsDirty: true})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config file example:
+//
+//	{
+//	    "formats": {
+//	        "default": "%{vcs}:%{branch}%(dirty.*.)",
+//	        "hg":      "☿ %{branch}%(dirty.*.)",
+//	        "short":   "%{branch}"
+//	    },
+//	    "options": {
+//	        "default": {"dirty_if_untracked": true},
+//	        "mono":    {"dirty_if_untracked": false, "include_submodules": false, "git_engine": "exec"}
+//	    },
+//	    "rules": [
+//	        {"path": "~/work/mono/**", "format": "short", "options": "mono"},
+//	        {"path": "~/vendor/*", "vcs": "git", "format": "compact"}
+//	    ]
+//	}
+//
+// Format for a repo is chosen in this order:
+//	- flag -format-name
+//	- first matching rule with format
+//	- format named by VCS type
+//	- format named "default"
+// Format names are looked up in "formats" first and then in predefined
+// formats. If no format was chosen then facts are output as list.
+//
+// Options for a repo are taken from (later overrides former):
+//	- option profile named "default"
+//	- option profile named by VCS type
+//	- first matching rule with options
+//	- command-line flags
+
+// Config contains user configuration.
+type Config struct {
+	Formats map[string]string        `json:"formats"`
+	Options map[string]ConfigOptions `json:"options"`
+	Rules   []ConfigRule             `json:"rules"`
+}
+
+// ConfigOptions is a named option profile.
+// Options which are nil won't be changed.
+type ConfigOptions struct {
+	DirtyIfUntracked    *bool     `json:"dirty_if_untracked"`
+	RenamesFromRewrites *bool     `json:"renames_from_rewrites"`
+	IncludeSubmodules   *bool     `json:"include_submodules"`
+	GitEngine           *string   `json:"git_engine"`
+	GitRoutes           GitRoutes `json:"git_routes"`
+	Approx              *AttrList `json:"approx"`
+}
+
+// ConfigRule chooses format and/or option profile for matching repo.
+type ConfigRule struct {
+	Path    string `json:"path"`    // glob for repo root, "dir/**" match any repo inside dir
+	VCS     string `json:"vcs"`     // VCS type, like "git" or "hg"
+	Format  string `json:"format"`  // format name
+	Options string `json:"options"` // option profile name
+}
+
+var predefinedFormats = map[string]string{
+	"vcprompt": "[%{vcs}:%{branch}] ",
+	"compact":  "%(branch.%{branch}.%{rev})%(state.|%{state}.)%(dirty.*.)%(timeout.….)",
+	"full": "%{vcs}:%(branch.%{branch}.%{rev})%(tag. %{tag}.)%(state. %{state}.)" +
+		"%(ahead. ↑%{ahead}.)%(behind. ↓%{behind}.)%(stash. ≡%{stash}.)" +
+		"%(unmerged. ✖%{unmerged}.)%(added. +%{added}.)%(modified. ~%{modified}.)" +
+		"%(deleted. -%{deleted}.)%(renamed. →%{renamed}.)%(untracked. ?.)%(timeout. ….)",
+}
+
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "vcprompt-fast", "config.json")
+}
+
+// LoadConfig returns config loaded from path.
+// Missing config file is not an error.
+// On error empty config is returned.
+func LoadConfig(path string) (*Config, error) {
+	conf := &Config{}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return conf, nil
+	} else if err != nil {
+		return conf, fmt.Errorf("config: %s", err)
+	}
+	err = json.Unmarshal(buf, conf)
+	if err == nil {
+		err = conf.validate()
+	}
+	if err != nil {
+		return &Config{}, fmt.Errorf("config %s: %s", path, err)
+	}
+	return conf, nil
+}
+
+func (conf *Config) validate() error {
+	for name, opts := range conf.Options {
+		if opts.GitEngine != nil && gitEngines[*opts.GitEngine] == nil {
+			return fmt.Errorf("options[%q]: unknown git_engine %q", name, *opts.GitEngine)
+		}
+		if err := opts.GitRoutes.validate(); err != nil {
+			return fmt.Errorf("options[%q]: %s", name, err)
+		}
+	}
+	for i, rule := range conf.Rules {
+		if _, ok := conf.LookupFormat(rule.Format); rule.Format != "" && !ok {
+			return fmt.Errorf("rules[%d]: unknown format %q", i, rule.Format)
+		}
+		if _, ok := conf.Options[rule.Options]; rule.Options != "" && !ok {
+			return fmt.Errorf("rules[%d]: unknown options %q", i, rule.Options)
+		}
+		if rule.VCS != "" && !isVCSName(rule.VCS) {
+			return fmt.Errorf("rules[%d]: unknown vcs %q, valid values: %s", i, rule.VCS, strings.Join(vcsNames(), " "))
+		}
+		if _, err := filepath.Match(rule.Path, ""); err != nil {
+			return fmt.Errorf("rules[%d]: bad path %q: %s", i, rule.Path, err)
+		}
+	}
+	return nil
+}
+
+func isVCSName(name string) bool {
+	for _, vcsName := range vcsNames() {
+		if vcsName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupFormat returns format with given name.
+func (conf *Config) LookupFormat(name string) (format string, ok bool) {
+	if format, ok = conf.Formats[name]; !ok {
+		format, ok = predefinedFormats[name]
+	}
+	return format, ok
+}
+
+// FormatName returns name of format for the repo or empty string if
+// there are no suitable format.
+func (conf *Config) FormatName(vcs VCSType, root string) string {
+	for _, rule := range conf.Rules {
+		if rule.Format != "" && rule.match(vcs, root) {
+			return rule.Format
+		}
+	}
+	for _, name := range []string{vcs.String(), "default"} {
+		if _, ok := conf.LookupFormat(name); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// Apply changes req options according to option profiles for the repo.
+func (conf *Config) Apply(req *Request, vcs VCSType, root string) {
+	conf.Options["default"].apply(req)
+	conf.Options[vcs.String()].apply(req)
+	for _, rule := range conf.Rules {
+		if rule.Options != "" && rule.match(vcs, root) {
+			conf.Options[rule.Options].apply(req)
+			break
+		}
+	}
+}
+
+func (opts ConfigOptions) apply(req *Request) {
+	if opts.DirtyIfUntracked != nil {
+		req.DirtyIfUntracked = *opts.DirtyIfUntracked
+	}
+	if opts.RenamesFromRewrites != nil {
+		req.RenamesFromRewrites = *opts.RenamesFromRewrites
+	}
+	if opts.IncludeSubmodules != nil {
+		req.IncludeSubmodules = *opts.IncludeSubmodules
+	}
+	if opts.GitEngine != nil {
+		req.GitEngine = *opts.GitEngine
+	}
+	if opts.GitRoutes != nil {
+		req.GitRoutes = opts.GitRoutes
+	}
+	if opts.Approx != nil {
+		req.Approx = *opts.Approx
+	}
+}
+
+func (rule ConfigRule) match(vcs VCSType, root string) bool {
+	if rule.VCS != "" && rule.VCS != vcs.String() {
+		return false
+	}
+	if rule.Path == "" {
+		return true
+	}
+	pattern := rule.Path
+	if pattern == "~" || strings.HasPrefix(pattern, "~/") {
+		pattern = os.Getenv("HOME") + pattern[1:]
+	}
+	if !strings.HasSuffix(pattern, "/**") {
+		ok, _ := filepath.Match(pattern, root)
+		return ok
+	}
+	pattern = strings.TrimSuffix(pattern, "/**")
+	for dir := root; ; dir = filepath.Dir(dir) {
+		if ok, _ := filepath.Match(pattern, dir); ok {
+			return true
+		}
+		if dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
+
+// applyConfig set format (unless output mode was set by flags) and
+// options (unless they was set by flags) for the repo.
+func applyConfig(conf *Config, vcs VCSType, root string) error {
+	isListMode := cfg.isSet["attrs"] && cfg.formatName == ""
+	if cfg.format == "" && cfg.template == "" && !cfg.json && cfg.eval == "" && !isListMode {
+		name := cfg.formatName
+		if name == "" {
+			name = conf.FormatName(vcs, root)
+		}
+		if name != "" {
+			format, ok := conf.LookupFormat(name)
+			if !ok {
+				return fmt.Errorf("unknown format name: %q", name)
+			}
+			cfg.format = format
+		}
+	}
+
+	req := cfg.req
+	conf.Apply(&req, vcs, root)
+	if !cfg.isSet["dirty-if-untracked"] {
+		cfg.req.DirtyIfUntracked = req.DirtyIfUntracked
+	}
+	if !cfg.isSet["renames-from-rewrites"] {
+		cfg.req.RenamesFromRewrites = req.RenamesFromRewrites
+	}
+	if !cfg.isSet["submodules"] {
+		cfg.req.IncludeSubmodules = req.IncludeSubmodules
+	}
+	if !cfg.isSet["git-engine"] {
+		cfg.req.GitEngine = req.GitEngine
+	}
+	if !cfg.isSet["git-routes"] {
+		cfg.req.GitRoutes = req.GitRoutes
+	}
+	if !cfg.isSet["approx"] {
+		cfg.req.Approx = req.Approx
+	}
+	return nil
+}
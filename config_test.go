@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type ConfigSuite struct{}
+
+var _ = Suite(&ConfigSuite{})
+
+const testConfig = `{
+    "formats": {
+        "default": "%{vcs}:%{branch}",
+        "hg":      "hg %{branch}",
+        "short":   "%{branch}"
+    },
+    "options": {
+        "default": {"dirty_if_untracked": true},
+        "git":     {"renames_from_rewrites": true, "git_routes": {"stash": "exec"}},
+        "mono":    {"dirty_if_untracked": false, "include_submodules": false, "git_engine": "exec", "approx": {"is_dirty": true}}
+    },
+    "rules": [
+        {"path": "/work/mono/**", "options": "mono"},
+        {"path": "/work/mono/*", "vcs": "git", "format": "short"},
+        {"path": "/vendor/*", "format": "full"}
+    ]
+}`
+
+func (s *ConfigSuite) TestLoad(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "config.json")
+
+	conf, err := LoadConfig(path)
+	c.Check(err, IsNil)
+	c.Check(conf, DeepEquals, &Config{}) // missing config
+
+	c.Assert(ioutil.WriteFile(path, []byte(`{"formats":`), 0600), IsNil)
+	conf, err = LoadConfig(path)
+	c.Check(err, ErrorMatches, "config .*: unexpected end of JSON input")
+	c.Check(conf, DeepEquals, &Config{})
+
+	c.Assert(ioutil.WriteFile(path, []byte(`{"rules":[{"format":"nope"}]}`), 0600), IsNil)
+	_, err = LoadConfig(path)
+	c.Check(err, ErrorMatches, `config .*: rules\[0\]: unknown format "nope"`)
+
+	c.Assert(ioutil.WriteFile(path, []byte(`{"rules":[{"format":"full"},{"options":"nope"}]}`), 0600), IsNil)
+	_, err = LoadConfig(path)
+	c.Check(err, ErrorMatches, `config .*: rules\[1\]: unknown options "nope"`)
+
+	c.Assert(ioutil.WriteFile(path, []byte(`{"options":{"git":{"git_engine":"nope"}}}`), 0600), IsNil)
+	_, err = LoadConfig(path)
+	c.Check(err, ErrorMatches, `config .*: options\["git"\]: unknown git_engine "nope"`)
+
+	c.Assert(ioutil.WriteFile(path, []byte(`{"options":{"git":{"git_routes":{"nope":"exec"}}}}`), 0600), IsNil)
+	_, err = LoadConfig(path)
+	c.Check(err, ErrorMatches, `config .*: options\["git"\]: unknown git route "nope"`)
+
+	c.Assert(ioutil.WriteFile(path, []byte(`{"rules":[{"vcs":"nope"}]}`), 0600), IsNil)
+	_, err = LoadConfig(path)
+	c.Check(err, ErrorMatches, `config .*: rules\[0\]: unknown vcs "nope", valid values: git hg .*`)
+
+	c.Assert(ioutil.WriteFile(path, []byte(`{"rules":[{"path":"[x"}]}`), 0600), IsNil)
+	_, err = LoadConfig(path)
+	c.Check(err, ErrorMatches, `config .*: rules\[0\]: bad path .*`)
+
+	c.Assert(ioutil.WriteFile(path, []byte(testConfig), 0600), IsNil)
+	conf, err = LoadConfig(path)
+	c.Check(err, IsNil)
+	c.Check(conf.Rules, HasLen, 3)
+}
+
+func (s *ConfigSuite) TestFormatName(c *C) {
+	var conf Config
+	c.Check(conf.FormatName(VCSGit, "/work/a"), Equals, "")
+
+	c.Assert(json.Unmarshal([]byte(testConfig), &conf), IsNil)
+	cases := []struct {
+		vcs  VCSType
+		root string
+		want string
+	}{
+		{VCSGit, "/work/a", "default"},
+		{VCSMercurial, "/work/a", "hg"},
+		{VCSGit, "/work/mono/a", "short"},
+		{VCSMercurial, "/work/mono/a", "hg"},
+		{VCSGit, "/work/mono/a/b", "default"},
+		{VCSMercurial, "/vendor/a", "full"},
+	}
+	for _, v := range cases {
+		c.Check(conf.FormatName(v.vcs, v.root), Equals, v.want, Commentf("%s %s", v.vcs, v.root))
+	}
+
+	format, ok := conf.LookupFormat("short")
+	c.Check(ok, Equals, true)
+	c.Check(format, Equals, "%{branch}")
+	format, ok = conf.LookupFormat("full")
+	c.Check(ok, Equals, true)
+	c.Check(format, Equals, predefinedFormats["full"])
+	_, ok = conf.LookupFormat("nope")
+	c.Check(ok, Equals, false)
+	for name, format := range predefinedFormats {
+		_, err := ParseFormat(format)
+		c.Check(err, IsNil, Commentf("%s", name))
+	}
+}
+
+func (s *ConfigSuite) TestApply(c *C) {
+	var conf Config
+	req := Request{IncludeSubmodules: true}
+	conf.Apply(&req, VCSGit, "/work/a")
+	c.Check(req, DeepEquals, Request{IncludeSubmodules: true})
+
+	c.Assert(json.Unmarshal([]byte(testConfig), &conf), IsNil)
+	cases := []struct {
+		vcs  VCSType
+		root string
+		want Request
+	}{
+		{VCSGit, "/work/a", Request{DirtyIfUntracked: true, RenamesFromRewrites: true, IncludeSubmodules: true, GitRoutes: GitRoutes{"stash": "exec"}}},
+		{VCSMercurial, "/work/a", Request{DirtyIfUntracked: true, IncludeSubmodules: true}},
+		{VCSGit, "/work/mono", Request{RenamesFromRewrites: true, GitEngine: "exec", GitRoutes: GitRoutes{"stash": "exec"}, Approx: AttrList{IsDirty: true}}},
+		{VCSMercurial, "/work/mono/a/b", Request{GitEngine: "exec", Approx: AttrList{IsDirty: true}}},
+	}
+	for _, v := range cases {
+		req := Request{IncludeSubmodules: true}
+		conf.Apply(&req, v.vcs, v.root)
+		c.Check(req, DeepEquals, v.want, Commentf("%s %s", v.vcs, v.root))
+	}
+}
+
+func (s *ConfigSuite) TestRuleMatchHome(c *C) {
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", "/home/user")
+	rule := ConfigRule{Path: "~/src/**"}
+	c.Check(rule.match(VCSGit, "/home/user/src"), Equals, true)
+	c.Check(rule.match(VCSGit, "/home/user/src/a/b"), Equals, true)
+	c.Check(rule.match(VCSGit, "/home/user/srcx"), Equals, false)
+	c.Check(rule.match(VCSGit, "/home/user"), Equals, false)
+	rule = ConfigRule{Path: "~"}
+	c.Check(rule.match(VCSGit, "/home/user"), Equals, true)
+	rule = ConfigRule{VCS: "hg"}
+	c.Check(rule.match(VCSGit, "/"), Equals, false)
+	c.Check(rule.match(VCSMercurial, "/"), Equals, true)
+}
@@ -10,6 +10,14 @@ const (
 	VCSNone VCSType = iota
 	VCSGit
 	VCSMercurial
+	VCSSubversion
+	VCSBazaar
+	VCSJujutsu
+	VCSFossil
+	VCSSapling
+	VCSDarcs
+	VCSPijul
+	VCSCVS
 )
 
 // String returns text representation for VCS type.
@@ -21,11 +29,32 @@ func (name VCSType) String() string {
 		return "git"
 	case VCSMercurial:
 		return "hg"
+	case VCSSubversion:
+		return "svn"
+	case VCSBazaar:
+		return "bzr"
+	case VCSJujutsu:
+		return "jj"
+	case VCSFossil:
+		return "fossil"
+	case VCSSapling:
+		return "sl"
+	case VCSDarcs:
+		return "darcs"
+	case VCSPijul:
+		return "pijul"
+	case VCSCVS:
+		return "cvs"
 	default:
 		panic(fmt.Sprintf("unknown VCSType: %d", name))
 	}
 }
 
+// MarshalText implements encoding.TextMarshaler.
+func (name VCSType) MarshalText() ([]byte, error) {
+	return []byte(name.String()), nil
+}
+
 // VCSState is VCS state (merge conflict, interactive rebase, …) enumeration.
 type VCSState int
 
@@ -70,3 +99,8 @@ func (state VCSState) String() string {
 		panic(fmt.Sprintf("unknown VCSState: %d", state))
 	}
 }
+
+// MarshalText implements encoding.TextMarshaler.
+func (state VCSState) MarshalText() ([]byte, error) {
+	return []byte(state.String()), nil
+}
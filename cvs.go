@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CVS support is based on reading CVS/ directories, without executing
+// cvs (which needs network access for most commands).
+//
+// Every dir in checkout has own CVS/, so repo root is the topmost one.
+// Branch or Tag contains sticky branch or tag of root dir (trunk and
+// sticky date have neither).
+//
+// Status is detected in a way similar to `cvs -n update`: file is
+// modified if it's mtime differs from timestamp in CVS/Entries (CVS
+// itself compares content in this case, but this needs network access).
+// Missing files are reported as deleted. Subdirs are found using
+// directory entries in CVS/Entries.
+// Revision (CVS has per-file revisions), state, renamed and untracked
+// files and remote-related facts aren't supported.
+
+// cvsAttrs are attributes supported by VCSInfoCVS.
+var cvsAttrs = AttrList{
+	VCS:              true,
+	Branch:           true,
+	Tag:              true,
+	IsDirty:          true,
+	HasAddedFiles:    true,
+	AddedFiles:       true,
+	HasModifiedFiles: true,
+	ModifiedFiles:    true,
+	HasDeletedFiles:  true,
+	DeletedFiles:     true,
+}
+
+// cvsTimestampMerge is a CVS/Entries timestamp prefix for file modified
+// by merge.
+const cvsTimestampMerge = "Result of merge"
+
+// VCSInfoCVS returns CVS facts for current repo.
+func VCSInfoCVS(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSCVS {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(cvsAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.VCS = true
+
+	facts.Found.VCS = VCSCVS
+
+	if l.Branch || l.Tag {
+		branch, tag := cvsStickyTag(facts.Repo.Root)
+		if l.Branch {
+			facts.Found.Branch = branch
+		}
+		if l.Tag {
+			facts.Found.Tag = tag
+		}
+	}
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  statusAttrs,
+			detect: cvsStatus,
+		},
+	})
+}
+
+// cvsTopDir returns topmost dir of CVS checkout which contains dir.
+func cvsTopDir(dir string) string {
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		if fi, err := os.Stat(filepath.Join(parent, "CVS", "Entries")); err != nil || !fi.Mode().IsRegular() {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// cvsStickyTag returns sticky branch or tag of dir.
+func cvsStickyTag(dir string) (branch, tag string) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "CVS", "Tag"))
+	if err != nil || len(buf) < 2 {
+		return "", ""
+	}
+	name := string(bytes.TrimSpace(buf[1:]))
+	switch buf[0] {
+	case 'T':
+		return name, ""
+	case 'N':
+		return "", name
+	}
+	return "", "" // D: sticky date
+}
+
+// cvsEntry is a file entry from CVS/Entries.
+type cvsEntry struct {
+	name      string
+	revision  string
+	timestamp string
+}
+
+// readCVSEntries returns file entries and subdirs of dir, with changes
+// from CVS/Entries.Log applied.
+func readCVSEntries(dir string) (files map[string]cvsEntry, subdirs map[string]bool, err error) {
+	files = make(map[string]cvsEntry)
+	subdirs = make(map[string]bool)
+	apply := func(line string, remove bool) {
+		fields := strings.Split(line, "/")
+		switch {
+		case len(fields) < 6:
+		case fields[0] == "D" && remove:
+			delete(subdirs, fields[1])
+		case fields[0] == "D":
+			subdirs[fields[1]] = true
+		case fields[0] != "":
+		case remove:
+			delete(files, fields[1])
+		default:
+			files[fields[1]] = cvsEntry{name: fields[1], revision: fields[2], timestamp: fields[3]}
+		}
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "CVS", "Entries"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		apply(line, false)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "CVS", "Entries.Log"))
+	if os.IsNotExist(err) {
+		return files, subdirs, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "A "):
+			apply(line[2:], false)
+		case strings.HasPrefix(line, "R "):
+			apply(line[2:], true)
+		}
+	}
+	return files, subdirs, scanner.Err()
+}
+
+func cvsStatus(ctx context.Context, facts *Facts) {
+	found := &facts.Found
+	var walk func(dir string)
+	walk = func(dir string) {
+		if ctx.Err() != nil {
+			return
+		}
+		files, subdirs, err := readCVSEntries(dir)
+		if err != nil {
+			log.Println("cvs entries:", err)
+			return
+		}
+		for _, e := range files {
+			switch {
+			case e.revision == "0":
+				found.AddedFiles++
+			case strings.HasPrefix(e.revision, "-"):
+				found.DeletedFiles++
+			default:
+				switch modified, err := isCVSModified(filepath.Join(dir, e.name), e.timestamp); {
+				case os.IsNotExist(err):
+					found.DeletedFiles++
+				case err != nil:
+					log.Println("cvs:", err)
+				case modified:
+					found.ModifiedFiles++
+				}
+			}
+		}
+		for name := range subdirs {
+			walk(filepath.Join(dir, name))
+		}
+	}
+	walk(facts.Repo.Root)
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return
+	}
+
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	// These counters are detected anyway.
+	l := &facts.Lookup
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+}
+
+// isCVSModified compares file mtime with timestamp from CVS/Entries.
+func isCVSModified(name, timestamp string) (bool, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	if strings.HasPrefix(timestamp, cvsTimestampMerge) {
+		return true, nil
+	}
+	// Timestamp may be followed by "+" and conflict info.
+	if i := strings.IndexByte(timestamp, '+'); i != -1 {
+		timestamp = timestamp[:i]
+	}
+	t, err := time.Parse(time.ANSIC, timestamp)
+	if err != nil {
+		return true, nil // like "dummy timestamp"
+	}
+	return fi.ModTime().Unix() != t.Unix(), nil
+}
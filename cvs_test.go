@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type CVSSuite struct {
+	root  string
+	req   Request
+	mtime time.Time
+}
+
+var _ = Suite(&CVSSuite{})
+
+func (s *CVSSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:           true,
+			Branch:        true,
+			Tag:           true,
+			IsDirty:       true,
+			AddedFiles:    true,
+			ModifiedFiles: true,
+			DeletedFiles:  true,
+		},
+	}
+	s.mtime = time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.root = c.MkDir()
+	s.entries(c, "")
+}
+
+func (s *CVSSuite) VCSInfoCVS(c *C) Attr {
+	facts := Facts{Repo: Repo{VCS: VCSCVS, Root: s.root}, Req: s.req}
+	VCSInfoCVS(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+// entries writes CVS/Entries in dir.
+func (s *CVSSuite) entries(c *C, dir string, lines ...string) {
+	cvsDir := filepath.Join(s.root, dir, "CVS")
+	c.Assert(os.MkdirAll(cvsDir, 0755), IsNil)
+	data := strings.Join(append(lines, "D"), "\n") + "\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(cvsDir, "Entries"), []byte(data), 0644), IsNil)
+}
+
+// file creates unmodified file and returns it's entry.
+func (s *CVSSuite) file(c *C, path string) string {
+	name := filepath.Join(s.root, path)
+	c.Assert(ioutil.WriteFile(name, []byte(path), 0644), IsNil)
+	c.Assert(os.Chtimes(name, s.mtime, s.mtime), IsNil)
+	return "/" + filepath.Base(path) + "/1.1/" + s.mtime.Format(time.ANSIC) + "//"
+}
+
+func (s *CVSSuite) TestStickyTag(c *C) {
+	cases := []struct {
+		tag, branch, want string
+	}{
+		{"", "", ""},
+		{"Tfeature\n", "feature", ""},
+		{"Nv1_0\n", "", "v1_0"},
+		{"D2018.01.02.03.04.05\n", "", ""},
+	}
+	for _, v := range cases {
+		c.Assert(ioutil.WriteFile(filepath.Join(s.root, "CVS", "Tag"), []byte(v.tag), 0644), IsNil)
+		branch, tag := cvsStickyTag(s.root)
+		c.Check(branch, Equals, v.branch, Commentf("%q", v.tag))
+		c.Check(tag, Equals, v.want, Commentf("%q", v.tag))
+	}
+}
+
+func (s *CVSSuite) TestReadEntries(c *C) {
+	s.entries(c, "", "/a.txt/1.1/Tue Jan  2 03:04:05 2018//", "/b.txt/1.2/dummy timestamp//Tv1", "D/sub////", "D/gone////")
+	log := "A /new.txt/0/dummy timestamp//\nR /b.txt/1.2///\nR D/gone////\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "CVS", "Entries.Log"), []byte(log), 0644), IsNil)
+	files, subdirs, err := readCVSEntries(s.root)
+	c.Assert(err, IsNil)
+	c.Check(files, DeepEquals, map[string]cvsEntry{
+		"a.txt":   {name: "a.txt", revision: "1.1", timestamp: "Tue Jan  2 03:04:05 2018"},
+		"new.txt": {name: "new.txt", revision: "0", timestamp: "dummy timestamp"},
+	})
+	c.Check(subdirs, DeepEquals, map[string]bool{"sub": true})
+}
+
+func (s *CVSSuite) TestCVSInfo(c *C) {
+	c.Check(s.VCSInfoCVS(c), DeepEquals, Attr{VCS: VCSCVS})
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "CVS", "Tag"), []byte("Tfeature\n"), 0644), IsNil)
+	c.Check(s.VCSInfoCVS(c), DeepEquals, Attr{VCS: VCSCVS, Branch: "feature"})
+}
+
+func (s *CVSSuite) TestCVSStatus(c *C) {
+	s.req.Attr = AttrList{IsDirty: true, AddedFiles: true, ModifiedFiles: true, DeletedFiles: true}
+	c.Assert(os.Mkdir(filepath.Join(s.root, "sub"), 0755), IsNil)
+	s.entries(c, "sub", s.file(c, "sub/a.txt"))
+	s.entries(c, "", s.file(c, "a.txt"), "D/sub////")
+	c.Check(s.VCSInfoCVS(c), DeepEquals, Attr{})
+
+	s.entries(c, "sub",
+		s.file(c, "sub/a.txt"),
+		s.file(c, "sub/mod.txt"),
+		s.file(c, "sub/merged.txt"),
+		s.file(c, "sub/missing.txt"),
+		"/new.txt/0/dummy timestamp//",
+		"/removed.txt/-1.1/dummy timestamp//",
+	)
+	now := time.Now()
+	c.Assert(os.Chtimes(filepath.Join(s.root, "sub", "mod.txt"), now, now), IsNil)
+	c.Assert(os.Remove(filepath.Join(s.root, "sub", "missing.txt")), IsNil)
+	entries, err := ioutil.ReadFile(filepath.Join(s.root, "sub", "CVS", "Entries"))
+	c.Assert(err, IsNil)
+	entries = []byte(strings.Replace(string(entries), "/merged.txt/1.1/", "/merged.txt/1.1/Result of merge+", 1))
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "sub", "CVS", "Entries"), entries, 0644), IsNil)
+	c.Check(s.VCSInfoCVS(c), DeepEquals, Attr{
+		IsDirty:       true,
+		AddedFiles:    1,
+		ModifiedFiles: 2,
+		DeletedFiles:  2,
+	})
+}
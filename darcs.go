@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Darcs support is based on executing darcs. Darcs has no branches
+// (every repo is a branch), so Branch contains repo dir name.
+// Unrecorded changes are detected using `darcs whatsnew --summary`,
+// Tag contains latest tag.
+// Revision (darcs has no single hash for repo state), state and
+// remote-related facts aren't supported.
+
+// darcsAttrs are attributes supported by VCSInfoDarcs.
+var darcsAttrs = AttrList{
+	VCS:               true,
+	Branch:            true,
+	Tag:               true,
+	IsDirty:           true,
+	HasAddedFiles:     true,
+	AddedFiles:        true,
+	HasModifiedFiles:  true,
+	ModifiedFiles:     true,
+	HasDeletedFiles:   true,
+	DeletedFiles:      true,
+	HasRenamedFiles:   true,
+	RenamedFiles:      true,
+	HasUntrackedFiles: true,
+}
+
+// VCSInfoDarcs returns darcs facts for current repo.
+func VCSInfoDarcs(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSDarcs {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(darcsAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.VCS = true
+
+	facts.Found.VCS = VCSDarcs
+
+	if l.Branch {
+		facts.Found.Branch = filepath.Base(facts.Repo.Root)
+	}
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  AttrList{Tag: true},
+			detect: darcsTag,
+		},
+		{
+			attrs:  statusAttrs,
+			detect: darcsStatus,
+		},
+	})
+}
+
+// darcs executes darcs command for facts.Repo and returns it's stdout.
+func darcs(ctx context.Context, facts *Facts, args ...string) ([]byte, error) {
+	args = append(args, "--repodir", facts.Repo.Root)
+	cmd := exec.CommandContext(ctx, "darcs", args...)
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return nil, ctx.Err()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 && args[0] == "whatsnew" {
+		return out, nil // no changes
+	}
+	if err != nil {
+		log.Printf("darcs %s: %s", strings.Join(args, " "), err)
+	}
+	return out, err
+}
+
+func darcsTag(ctx context.Context, facts *Facts) {
+	out, err := darcs(ctx, facts, "show", "tags")
+	if err == nil {
+		// Tags are listed from latest to oldest.
+		facts.Found.Tag = strings.SplitN(string(out), "\n", 2)[0]
+	}
+}
+
+func darcsStatus(ctx context.Context, facts *Facts) {
+	l := &facts.Lookup
+	args := []string{"whatsnew", "--summary"}
+	if l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked) {
+		args = append(args, "--look-for-adds")
+		l.HasUntrackedFiles = true
+	}
+	out, err := darcs(ctx, facts, args...)
+	if err != nil {
+		return
+	}
+	parseDarcsWhatsnew(out, &facts.Found)
+	// These counters are detected anyway.
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.HasRenamedFiles, l.RenamedFiles = true, true
+}
+
+// parseDarcsWhatsnew parses output of darcs whatsnew --summary
+// (optionally with --look-for-adds).
+func parseDarcsWhatsnew(out []byte, found *Attr) {
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0, line == "No changes!":
+		case strings.Contains(line, " -> "):
+			found.RenamedFiles++
+		case strings.HasSuffix(line, "/"):
+			// Added/removed directories are reported in addition to files.
+		default:
+			switch strings.TrimSuffix(fields[0], "!") { // ! means conflict
+			case "A":
+				found.AddedFiles++
+			case "M":
+				found.ModifiedFiles++
+			case "R":
+				found.DeletedFiles++
+			case "a":
+				found.HasUntrackedFiles = true
+			default:
+				log.Printf("darcs whatsnew: unexpected line: %q", line)
+			}
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type DarcsSuite struct {
+	root string
+	req  Request
+}
+
+var _ = Suite(&DarcsSuite{})
+
+func (s *DarcsSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:               true,
+			Branch:            true,
+			Tag:               true,
+			IsDirty:           true,
+			AddedFiles:        true,
+			ModifiedFiles:     true,
+			HasUntrackedFiles: true,
+		},
+	}
+	s.root = filepath.Join(c.MkDir(), "project")
+}
+
+func (s *DarcsSuite) VCSInfoDarcs(c *C) Attr {
+	facts := Facts{Repo: Repo{VCS: VCSDarcs, Root: s.root}, Req: s.req}
+	VCSInfoDarcs(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *DarcsSuite) darcs(c *C, args ...string) {
+	cmd := exec.Command("darcs", args...)
+	cmd.Dir = s.root
+	cmd.Env = append(os.Environ(), "DARCS_EMAIL=Nobody <root@localhost>")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+}
+
+func (s *DarcsSuite) TestParseWhatsnew(c *C) {
+	out := "M ./mod.txt -1 +2\nM! ./conflict.txt -1 +1\nA ./dir/\nA ./dir/new.txt\nR ./old.txt\nR ./olddir/\n ./a.txt -> ./b.txt\na ./junk.txt\n"
+	var found Attr
+	parseDarcsWhatsnew([]byte(out), &found)
+	c.Check(found, DeepEquals, Attr{
+		HasAddedFiles:     true,
+		AddedFiles:        1,
+		HasModifiedFiles:  true,
+		ModifiedFiles:     2,
+		HasDeletedFiles:   true,
+		DeletedFiles:      1,
+		HasRenamedFiles:   true,
+		RenamedFiles:      1,
+		HasUntrackedFiles: true,
+	})
+
+	found = Attr{}
+	parseDarcsWhatsnew([]byte("No changes!\n"), &found)
+	c.Check(found, DeepEquals, Attr{})
+}
+
+func (s *DarcsSuite) TestDarcsBranch(c *C) {
+	s.req.Attr = AttrList{VCS: true, Branch: true}
+	c.Check(s.VCSInfoDarcs(c), DeepEquals, Attr{VCS: VCSDarcs, Branch: "project"})
+}
+
+func (s *DarcsSuite) TestDarcs(c *C) {
+	if _, err := exec.LookPath("darcs"); err != nil {
+		c.Skip("darcs not installed")
+	}
+	c.Assert(os.Mkdir(s.root, 0755), IsNil)
+	s.darcs(c, "init")
+	c.Check(s.VCSInfoDarcs(c), DeepEquals, Attr{VCS: VCSDarcs, Branch: "project"})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "a.txt"), []byte("a"), 0644), IsNil)
+	c.Check(s.VCSInfoDarcs(c), DeepEquals, Attr{VCS: VCSDarcs, Branch: "project", HasUntrackedFiles: true})
+	s.darcs(c, "add", "a.txt")
+	c.Check(s.VCSInfoDarcs(c), DeepEquals, Attr{VCS: VCSDarcs, Branch: "project", IsDirty: true, AddedFiles: 1})
+
+	s.darcs(c, "record", "-a", "-m", "first")
+	s.darcs(c, "tag", "v1.0")
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "a.txt"), []byte("b"), 0644), IsNil)
+	c.Check(s.VCSInfoDarcs(c), DeepEquals, Attr{VCS: VCSDarcs, Branch: "project", Tag: "v1.0", IsDirty: true, ModifiedFiles: 1})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Repo describe detected repo.
+type Repo struct {
+	VCS    VCSType `json:"vcs"`
+	Root   string  `json:"root"`    // working copy top-level dir
+	GitDir string  `json:"git_dir"` // git only: may be outside of Root
+}
+
+// vcsMarkers are names of dirs or files which mark repo root, in order of
+// priority (for case when there are more than one in same dir).
+var vcsMarkers = []struct {
+	name   string
+	vcs    VCSType
+	isFile bool
+}{
+	{".jj", VCSJujutsu, false}, // colocated jj repo also has .git
+	{".git", VCSGit, false},
+	{".hg", VCSMercurial, false},
+	{".sl", VCSSapling, false},
+	{".svn", VCSSubversion, false},
+	{".bzr", VCSBazaar, false},
+	{"_darcs", VCSDarcs, false},
+	{".pijul", VCSPijul, false},
+	{".fslckout", VCSFossil, true},
+	{"_FOSSIL_", VCSFossil, true},
+	{"CVS/Entries", VCSCVS, true}, // every dir in checkout has CVS/
+}
+
+// DetectRepo returns innermost repo which contains dir or Repo with
+// VCSNone if dir is not inside a repo. Dir must be absolute.
+//
+// It is done once for all VCS to avoid trying different VCS engines
+// one-by-one and have each one read same dirs again and again.
+func DetectRepo(dir string) Repo {
+	for {
+		for _, marker := range vcsMarkers {
+			path := filepath.Join(dir, marker.name)
+			fi, err := os.Stat(path)
+			switch {
+			case err != nil:
+				continue
+			case marker.isFile:
+				if !fi.Mode().IsRegular() {
+					continue
+				}
+				if marker.vcs == VCSCVS {
+					dir = cvsTopDir(dir)
+				}
+				return Repo{VCS: marker.vcs, Root: dir}
+			case fi.IsDir():
+				repo := Repo{VCS: marker.vcs, Root: dir}
+				if marker.vcs == VCSGit {
+					repo.GitDir = path
+				}
+				return repo
+			case marker.vcs == VCSGit:
+				gitDir, err := readGitFile(path)
+				if err != nil {
+					log.Println("readGitFile:", err)
+					continue
+				}
+				return Repo{VCS: VCSGit, Root: dir, GitDir: gitDir}
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return Repo{}
+		}
+		dir = parent
+	}
+}
+
+// readGitFile returns git dir from gitfile (used by worktrees,
+// submodules and --separate-git-dir).
+func readGitFile(path string) (string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	const prefix = "gitdir: "
+	if !bytes.HasPrefix(buf, []byte(prefix)) {
+		return "", &os.PathError{Op: "parse", Path: path, Err: os.ErrInvalid}
+	}
+	gitDir := string(bytes.TrimSpace(buf[len(prefix):]))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	if fi, err := os.Stat(gitDir); err != nil {
+		return "", err
+	} else if !fi.IsDir() {
+		return "", &os.PathError{Op: "stat", Path: gitDir, Err: os.ErrInvalid}
+	}
+	return gitDir, nil
+}
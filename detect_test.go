@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type DetectSuite struct {
+	dir string
+}
+
+var _ = Suite(&DetectSuite{})
+
+func (s *DetectSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+}
+
+func (s *DetectSuite) mkdir(c *C, path string) string {
+	path = filepath.Join(s.dir, path)
+	c.Assert(os.MkdirAll(path, 0755), IsNil)
+	return path
+}
+
+func (s *DetectSuite) TestNoRepo(c *C) {
+	c.Check(DetectRepo(s.mkdir(c, "a/b")), DeepEquals, Repo{})
+}
+
+func (s *DetectSuite) TestMarkers(c *C) {
+	cases := []struct {
+		marker string
+		vcs    VCSType
+	}{
+		{".git", VCSGit},
+		{".hg", VCSMercurial},
+		{".svn", VCSSubversion},
+		{".bzr", VCSBazaar},
+		{".jj", VCSJujutsu},
+		{".sl", VCSSapling},
+		{"_darcs", VCSDarcs},
+		{".pijul", VCSPijul},
+	}
+	for _, v := range cases {
+		root := s.mkdir(c, v.vcs.String())
+		s.mkdir(c, v.vcs.String()+"/"+v.marker)
+		sub := s.mkdir(c, v.vcs.String()+"/a/b")
+		want := Repo{VCS: v.vcs, Root: root}
+		if v.vcs == VCSGit {
+			want.GitDir = filepath.Join(root, ".git")
+		}
+		c.Check(DetectRepo(root), DeepEquals, want)
+		c.Check(DetectRepo(sub), DeepEquals, want)
+	}
+}
+
+func (s *DetectSuite) TestFossil(c *C) {
+	for _, marker := range []string{".fslckout", "_FOSSIL_"} {
+		root := s.mkdir(c, marker+"-repo")
+		sub := s.mkdir(c, marker+"-repo/a/b")
+		s.mkdir(c, marker+"-repo/a/"+marker) // only file is a marker
+		c.Assert(ioutil.WriteFile(filepath.Join(root, marker), nil, 0644), IsNil)
+		want := Repo{VCS: VCSFossil, Root: root}
+		c.Check(DetectRepo(root), DeepEquals, want)
+		c.Check(DetectRepo(sub), DeepEquals, want)
+	}
+}
+
+func (s *DetectSuite) TestCVS(c *C) {
+	for _, dir := range []string{"co", "co/a", "co/a/b"} {
+		s.mkdir(c, dir+"/CVS")
+		c.Assert(ioutil.WriteFile(filepath.Join(s.dir, dir, "CVS", "Entries"), nil, 0644), IsNil)
+	}
+	s.mkdir(c, "CVS") // not a checkout without CVS/Entries
+	want := Repo{VCS: VCSCVS, Root: filepath.Join(s.dir, "co")}
+	c.Check(DetectRepo(filepath.Join(s.dir, "co")), DeepEquals, want)
+	c.Check(DetectRepo(s.mkdir(c, "co/a/b/c")), DeepEquals, want)
+	c.Check(DetectRepo(s.dir), DeepEquals, Repo{})
+}
+
+func (s *DetectSuite) TestInnermost(c *C) {
+	s.mkdir(c, ".hg")
+	inner := s.mkdir(c, "vendor/lib")
+	s.mkdir(c, "vendor/lib/.git")
+	s.mkdir(c, "vendor/lib/.bzr") // same dir: lower priority
+	c.Check(DetectRepo(s.mkdir(c, "vendor/lib/src")), DeepEquals,
+		Repo{VCS: VCSGit, Root: inner, GitDir: filepath.Join(inner, ".git")})
+	c.Check(DetectRepo(s.mkdir(c, "vendor")), DeepEquals,
+		Repo{VCS: VCSMercurial, Root: s.dir})
+}
+
+func (s *DetectSuite) TestColocated(c *C) {
+	s.mkdir(c, ".git")
+	s.mkdir(c, ".jj")
+	c.Check(DetectRepo(s.mkdir(c, "src")), DeepEquals, Repo{VCS: VCSJujutsu, Root: s.dir})
+}
+
+func (s *DetectSuite) TestGitFile(c *C) {
+	gitDir := s.mkdir(c, "repo.git")
+	wt := s.mkdir(c, "wt")
+	c.Assert(ioutil.WriteFile(filepath.Join(wt, ".git"), []byte("gitdir: "+gitDir+"\n"), 0644), IsNil)
+	c.Check(DetectRepo(wt), DeepEquals, Repo{VCS: VCSGit, Root: wt, GitDir: gitDir})
+
+	sub := s.mkdir(c, "wt/sub")
+	s.mkdir(c, "wt/.git-modules/sub")
+	c.Assert(ioutil.WriteFile(filepath.Join(sub, ".git"), []byte("gitdir: ../.git-modules/sub\n"), 0644), IsNil)
+	c.Check(DetectRepo(sub), DeepEquals, Repo{VCS: VCSGit, Root: sub, GitDir: filepath.Join(wt, ".git-modules/sub")})
+}
+
+func (s *DetectSuite) TestGitFileBad(c *C) {
+	s.mkdir(c, ".hg")
+	for _, content := range []string{"garbage", "gitdir: nope"} {
+		wt := s.mkdir(c, "wt")
+		c.Assert(ioutil.WriteFile(filepath.Join(wt, ".git"), []byte(content), 0644), IsNil)
+		c.Check(DetectRepo(wt), DeepEquals, Repo{VCS: VCSMercurial, Root: s.dir}, Commentf("%q", content))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Shell-eval output sets shell variable vcs_NAME for each attribute NAME
+// (same names as in JSON output, e.g. vcs_is_dirty, not vcs_dirty). Not requested and false attributes
+// are set to empty string, true to "1". Variable vcs_approx is set to
+// space-separated names of attributes detected approximately.
+//
+// Usage:
+//	eval "$(vcprompt-fast -eval bash)"    # also for zsh and sh
+//	vcprompt-fast -eval fish | source
+
+const evalVarPrefix = "vcs_"
+
+var evalShells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"zsh":  true,
+	"fish": true,
+}
+
+// printEval outputs res as shell code which set variables.
+func printEval(w io.Writer, shell string, res Attr, approx AttrList) error {
+	attr := reflect.ValueOf(res)
+	list := reflect.ValueOf(approx)
+	var approxNames []string
+	for i := 0; i < attr.NumField(); i++ {
+		name := attr.Type().Field(i).Tag.Get("json")
+		if list.Field(i).Bool() {
+			approxNames = append(approxNames, name)
+		}
+		if err := printEvalVar(w, shell, evalVarPrefix+name, evalValue(attr.Field(i).Interface())); err != nil {
+			return err
+		}
+	}
+	return printEvalVar(w, shell, evalVarPrefix+"approx", strings.Join(approxNames, " "))
+}
+
+func printEvalVar(w io.Writer, shell, name, value string) (err error) {
+	if shell == "fish" {
+		_, err = fmt.Fprintf(w, "set %s %s\n", name, quoteFish(value))
+	} else {
+		_, err = fmt.Fprintf(w, "%s=%s\n", name, quoteSh(value))
+	}
+	return err
+}
+
+func evalValue(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "1"
+		}
+		return ""
+	case int:
+		return strconv.Itoa(v)
+	case fmt.Stringer:
+		return v.String()
+	case string:
+		return v
+	default:
+		panic(fmt.Sprintf("unsupported attribute type %T", v))
+	}
+}
+
+// quoteSh returns s quoted for POSIX-compatible shells (sh, bash, zsh).
+func quoteSh(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// quoteFish returns s quoted for fish shell.
+func quoteFish(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "'", `\'`, -1)
+	return "'" + s + "'"
+}
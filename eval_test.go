@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type EvalSuite struct{}
+
+var _ = Suite(&EvalSuite{})
+
+var evalNastyBranch = "a'b\"c$(echo injected)`echo injected`$vcs_tag\\n\n;d"
+
+func (s *EvalSuite) TestPrintEval(c *C) {
+	res := Attr{
+		VCS:                VCSGit,
+		Branch:             "master",
+		State:              StateMerge,
+		CommitsAheadRemote: 3,
+		IsDirty:            true,
+	}
+	var buf strings.Builder
+	c.Assert(printEval(&buf, "bash", res, AttrList{IsDirty: true, ModifiedFiles: true}), IsNil)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	c.Check(lines, HasLen, reflect.TypeOf(Attr{}).NumField()+1)
+	c.Check(lines[0], Equals, "vcs_vcs='git'")
+	c.Check(lines[1], Equals, "vcs_revision_short=''")
+	c.Check(lines[2], Equals, "vcs_branch='master'")
+	c.Check(lines[4], Equals, "vcs_state='merge'")
+	c.Check(lines[5], Equals, "vcs_has_remote=''")
+	c.Check(lines[6], Equals, "vcs_commits_ahead_remote='3'")
+	c.Check(lines[10], Equals, "vcs_is_dirty='1'")
+	c.Check(lines[len(lines)-1], Equals, "vcs_approx='is_dirty modified_files'")
+
+	buf.Reset()
+	c.Assert(printEval(&buf, "fish", res, AttrList{}), IsNil)
+	lines = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	c.Check(lines, HasLen, reflect.TypeOf(Attr{}).NumField()+1)
+	c.Check(lines[2], Equals, "set vcs_branch 'master'")
+	c.Check(lines[10], Equals, "set vcs_is_dirty '1'")
+	c.Check(lines[len(lines)-1], Equals, "set vcs_approx ''")
+}
+
+func (s *EvalSuite) TestQuote(c *C) {
+	c.Check(quoteSh(""), Equals, `''`)
+	c.Check(quoteSh("it's"), Equals, `'it'\''s'`)
+	c.Check(quoteFish(""), Equals, `''`)
+	c.Check(quoteFish(`it's \o/`), Equals, `'it\'s \\o/'`)
+}
+
+func (s *EvalSuite) TestShell(c *C) {
+	for _, shell := range []string{"sh", "bash", "zsh", "fish"} {
+		path, err := exec.LookPath(shell)
+		if err != nil {
+			c.Logf("skip %s: %s", shell, err)
+			continue
+		}
+		var buf strings.Builder
+		c.Assert(printEval(&buf, shell, Attr{Branch: evalNastyBranch, IsDirty: true}, AttrList{}), IsNil)
+		script := `eval "$(cat)"; printf '%s|%s|%s' "$vcs_branch" "$vcs_is_dirty" "$vcs_tag"`
+		if shell == "fish" {
+			script = `source; printf '%s|%s|%s' "$vcs_branch" "$vcs_is_dirty" "$vcs_tag"`
+		}
+		cmd := exec.Command(path, "-c", script)
+		cmd.Stdin = strings.NewReader(buf.String())
+		out, err := cmd.Output()
+		c.Check(err, IsNil, Commentf("%s", shell))
+		c.Check(string(out), Equals, evalNastyBranch+"|1|", Commentf("%s", shell))
+	}
+}
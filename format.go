@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Format language is similar to zsh prompt (and vcs_info) one, but
+// facts are referenced by name to not clash with zsh prompt escapes.
+//
+// Facts:
+//	%{vcs}          VCS name
+//	%{rev}          revision
+//	%{change}       change id (jj)
+//	%{branch}       branch
+//	%{tag}          tag
+//	%{state}        state ("action": merge, rebase-i, …)
+//	%(remote…)      has remote (only in conditional)
+//	%{ahead}        commits ahead remote
+//	%{behind}       commits behind remote
+//	%{stash}        stashed commits
+//	%(dirty…)       is dirty (only in conditional)
+//	%(empty_desc…)  description is empty (jj, only in conditional)
+//	%{added}        added files
+//	%{modified}     modified files
+//	%{deleted}      deleted files
+//	%{renamed}      renamed files
+//	%{unmerged}     unmerged (conflicted) files
+//	%(untracked…)   has untracked files (only in conditional)
+//	%(timeout…)     some facts wasn't detected because of timeout (only in conditional)
+//
+// Values detected approximately (see Request.Approx) are prefixed with "~".
+// Conditional with approximate fact prefix non-empty output of chosen
+// branch with "~" (unless it's already prefixed).
+//
+// Conditionals (may be nested):
+//	%(name.true-text.false-text)
+//	%(name=value.true-text.false-text)
+// Condition is true if fact is true/non-empty/non-zero. Second form
+// compare fact with given value and is supported only for vcs and state.
+// Any character except a-z and "_" may be used instead of "." as a
+// separator.
+//
+// Escapes:
+//	%%  "%"
+//	%)  ")"
+//	%.  "." inside true-text of conditional with separator "."
+// All other %-sequences are output as is, so zsh's prompt escapes
+// (%F{…}, %K{…}, %B, %b, %f, %k, %{…%}, %(x.…) with one-char condition,
+// etc.) may be used without escaping. Zsh's %-sequence which is same as
+// separator can't be used inside true-text, choose another separator.
+
+// Format is a parsed format string.
+type Format []formatNode
+
+type formatNode struct {
+	text  string // literal text if name == ""
+	name  string
+	cond  bool
+	value string
+	then  Format
+	els   Format
+}
+
+type formatFact struct {
+	value      func(Attr) string // nil for boolean facts
+	isSet      func(Attr) bool
+	needValue  func(*AttrList)
+	needIsSet  func(*AttrList)
+	isSetValue func(Attr, string) bool // nil if not supported
+	values     []string
+}
+
+var formatFacts = map[string]formatFact{
+	"vcs": {
+		value:      func(a Attr) string { return a.VCS.String() },
+		isSet:      func(a Attr) bool { return a.VCS != VCSNone },
+		needValue:  func(l *AttrList) { l.VCS = true },
+		needIsSet:  func(l *AttrList) { l.VCS = true },
+		isSetValue: func(a Attr, v string) bool { return a.VCS.String() == v },
+		values:     vcsNames(),
+	},
+	"rev": {
+		value:     func(a Attr) string { return a.RevisionShort },
+		isSet:     func(a Attr) bool { return a.RevisionShort != "" },
+		needValue: func(l *AttrList) { l.RevisionShort = true },
+		needIsSet: func(l *AttrList) { l.RevisionShort = true },
+	},
+	"change": {
+		value:     func(a Attr) string { return a.ChangeID },
+		isSet:     func(a Attr) bool { return a.ChangeID != "" },
+		needValue: func(l *AttrList) { l.ChangeID = true },
+		needIsSet: func(l *AttrList) { l.ChangeID = true },
+	},
+	"branch": {
+		value:     func(a Attr) string { return a.Branch },
+		isSet:     func(a Attr) bool { return a.Branch != "" },
+		needValue: func(l *AttrList) { l.Branch = true },
+		needIsSet: func(l *AttrList) { l.Branch = true },
+	},
+	"tag": {
+		value:     func(a Attr) string { return a.Tag },
+		isSet:     func(a Attr) bool { return a.Tag != "" },
+		needValue: func(l *AttrList) { l.Tag = true },
+		needIsSet: func(l *AttrList) { l.Tag = true },
+	},
+	"state": {
+		value:      func(a Attr) string { return a.State.String() },
+		isSet:      func(a Attr) bool { return a.State != StateNone },
+		needValue:  func(l *AttrList) { l.State = true },
+		needIsSet:  func(l *AttrList) { l.State = true },
+		isSetValue: func(a Attr, v string) bool { return a.State.String() == v },
+		values:     stateNames(),
+	},
+	"remote": {
+		isSet:     func(a Attr) bool { return a.HasRemote },
+		needIsSet: func(l *AttrList) { l.HasRemote = true },
+	},
+	"ahead": {
+		value:     func(a Attr) string { return strconv.Itoa(a.CommitsAheadRemote) },
+		isSet:     func(a Attr) bool { return a.CommitsAheadRemote != 0 },
+		needValue: func(l *AttrList) { l.CommitsAheadRemote = true },
+		needIsSet: func(l *AttrList) { l.CommitsAheadRemote = true },
+	},
+	"behind": {
+		value:     func(a Attr) string { return strconv.Itoa(a.CommitsBehindRemote) },
+		isSet:     func(a Attr) bool { return a.CommitsBehindRemote != 0 },
+		needValue: func(l *AttrList) { l.CommitsBehindRemote = true },
+		needIsSet: func(l *AttrList) { l.CommitsBehindRemote = true },
+	},
+	"stash": {
+		value:     func(a Attr) string { return strconv.Itoa(a.StashedCommits) },
+		isSet:     func(a Attr) bool { return a.HasStashedCommits },
+		needValue: func(l *AttrList) { l.StashedCommits = true },
+		needIsSet: func(l *AttrList) { l.HasStashedCommits = true },
+	},
+	"dirty": {
+		isSet:     func(a Attr) bool { return a.IsDirty },
+		needIsSet: func(l *AttrList) { l.IsDirty = true },
+	},
+	"empty_desc": {
+		isSet:     func(a Attr) bool { return a.IsDescriptionEmpty },
+		needIsSet: func(l *AttrList) { l.IsDescriptionEmpty = true },
+	},
+	"added": {
+		value:     func(a Attr) string { return strconv.Itoa(a.AddedFiles) },
+		isSet:     func(a Attr) bool { return a.HasAddedFiles },
+		needValue: func(l *AttrList) { l.AddedFiles = true },
+		needIsSet: func(l *AttrList) { l.HasAddedFiles = true },
+	},
+	"modified": {
+		value:     func(a Attr) string { return strconv.Itoa(a.ModifiedFiles) },
+		isSet:     func(a Attr) bool { return a.HasModifiedFiles },
+		needValue: func(l *AttrList) { l.ModifiedFiles = true },
+		needIsSet: func(l *AttrList) { l.HasModifiedFiles = true },
+	},
+	"deleted": {
+		value:     func(a Attr) string { return strconv.Itoa(a.DeletedFiles) },
+		isSet:     func(a Attr) bool { return a.HasDeletedFiles },
+		needValue: func(l *AttrList) { l.DeletedFiles = true },
+		needIsSet: func(l *AttrList) { l.HasDeletedFiles = true },
+	},
+	"renamed": {
+		value:     func(a Attr) string { return strconv.Itoa(a.RenamedFiles) },
+		isSet:     func(a Attr) bool { return a.HasRenamedFiles },
+		needValue: func(l *AttrList) { l.RenamedFiles = true },
+		needIsSet: func(l *AttrList) { l.HasRenamedFiles = true },
+	},
+	"unmerged": {
+		value:     func(a Attr) string { return strconv.Itoa(a.UnmergedFiles) },
+		isSet:     func(a Attr) bool { return a.HasUnmergedFiles },
+		needValue: func(l *AttrList) { l.UnmergedFiles = true },
+		needIsSet: func(l *AttrList) { l.HasUnmergedFiles = true },
+	},
+	"untracked": {
+		isSet:     func(a Attr) bool { return a.HasUntrackedFiles },
+		needIsSet: func(l *AttrList) { l.HasUntrackedFiles = true },
+	},
+	"timeout": {
+		isSet:     func(a Attr) bool { return a.TimedOut },
+		needIsSet: func(l *AttrList) { l.TimedOut = true },
+	},
+}
+
+func vcsNames() (names []string) {
+	for vcs := VCSNone + 1; vcs <= VCSCVS; vcs++ {
+		names = append(names, vcs.String())
+	}
+	return names
+}
+
+func stateNames() (names []string) {
+	for state := StateNone + 1; state <= StateApplyMailboxOrRebase; state++ {
+		names = append(names, state.String())
+	}
+	return names
+}
+
+// ParseFormat parses format string.
+func ParseFormat(format string) (Format, error) {
+	p := formatParser{s: format}
+	return p.parse("")
+}
+
+// Attrs returns attributes required to execute format.
+func (f Format) Attrs() AttrList {
+	l := AttrList{VCS: true}
+	f.attrs(&l)
+	return l
+}
+
+func (f Format) attrs(l *AttrList) {
+	for _, node := range f {
+		switch {
+		case node.name == "":
+		case node.cond:
+			formatFacts[node.name].needIsSet(l)
+			node.then.attrs(l)
+			node.els.attrs(l)
+		default:
+			formatFacts[node.name].needValue(l)
+		}
+	}
+}
+
+// Execute returns res formatted according to format. Values of approx
+// attributes are marked as approximate.
+func (f Format) Execute(res Attr, approx AttrList) string {
+	var buf strings.Builder
+	f.execute(&buf, res, approx)
+	return buf.String()
+}
+
+func (f Format) execute(buf *strings.Builder, res Attr, approx AttrList) {
+	for _, node := range f {
+		fact := formatFacts[node.name]
+		switch {
+		case node.name == "":
+			buf.WriteString(node.text)
+		case !node.cond:
+			if isFormatApprox(fact.needValue, approx) {
+				buf.WriteByte('~')
+			}
+			buf.WriteString(fact.value(res))
+		default:
+			branch := node.els
+			if node.value != "" && fact.isSetValue(res, node.value) ||
+				node.value == "" && fact.isSet(res) {
+				branch = node.then
+			}
+			if !isFormatApprox(fact.needIsSet, approx) {
+				branch.execute(buf, res, approx)
+				break
+			}
+			var out strings.Builder
+			branch.execute(&out, res, approx)
+			if out.Len() > 0 && !strings.HasPrefix(out.String(), "~") {
+				buf.WriteByte('~')
+			}
+			buf.WriteString(out.String())
+		}
+	}
+}
+
+// isFormatApprox returns true if any of attributes needed by fact is
+// approximate.
+func isFormatApprox(need func(*AttrList), approx AttrList) bool {
+	var l AttrList
+	need(&l)
+	return l != l.Without(approx)
+}
+
+type formatParser struct {
+	s   string
+	pos int
+}
+
+// parse parses format until end of string or until any of stop
+// characters (which won't be consumed).
+func (p *formatParser) parse(stop string) (Format, error) {
+	var f Format
+	var text []byte
+	flush := func() {
+		if len(text) > 0 {
+			f = append(f, formatNode{text: string(text)})
+			text = nil
+		}
+	}
+	for p.pos < len(p.s) {
+		ch := p.s[p.pos]
+		if strings.IndexByte(stop, ch) != -1 {
+			break
+		}
+		p.pos++
+		if ch != '%' || p.pos == len(p.s) {
+			text = append(text, ch)
+			continue
+		}
+		code := p.s[p.pos]
+		p.pos++
+		name := p.name()
+		switch {
+		case code == '%' || code == ')' || strings.IndexByte(stop, code) != -1:
+			text = append(text, code)
+		case code == '(' && len(name) > 1: // zsh uses one-char conditions
+			flush()
+			node, err := p.parseCond(name)
+			if err != nil {
+				return nil, err
+			}
+			f = append(f, node)
+		case code == '{' && name != "" && p.pos+len(name) < len(p.s) && p.s[p.pos+len(name)] == '}':
+			fact, ok := formatFacts[name]
+			if !ok {
+				return nil, fmt.Errorf("format: unknown fact %%{%s}", name)
+			} else if fact.value == nil {
+				return nil, fmt.Errorf("format: %%{%s} can be used only in conditional", name)
+			}
+			p.pos += len(name) + 1
+			flush()
+			f = append(f, formatNode{name: name})
+		default:
+			text = append(text, '%', code)
+		}
+	}
+	flush()
+	return f, nil
+}
+
+// name returns fact name at current position (without consuming it).
+func (p *formatParser) name() string {
+	end := p.pos
+	for end < len(p.s) && isFormatNameChar(p.s[end]) {
+		end++
+	}
+	return p.s[p.pos:end]
+}
+
+// parseCond parses conditional after "%(" for fact name.
+func (p *formatParser) parseCond(name string) (node formatNode, err error) {
+	errUnterminated := fmt.Errorf("format: unterminated conditional at %d", p.pos-2)
+	node.name = name
+	node.cond = true
+	p.pos += len(name)
+	fact, ok := formatFacts[node.name]
+	if !ok {
+		return node, fmt.Errorf("format: unknown conditional %%(%s", node.name)
+	}
+
+	if p.pos < len(p.s) && p.s[p.pos] == '=' {
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.s) && isFormatValueChar(p.s[p.pos]) {
+			p.pos++
+		}
+		node.value = p.s[start:p.pos]
+		if fact.isSetValue == nil {
+			return node, fmt.Errorf("format: conditional %%(%s do not support value", node.name)
+		}
+		valid := false
+		for _, value := range fact.values {
+			valid = valid || value == node.value
+		}
+		if !valid {
+			return node, fmt.Errorf("format: conditional %%(%s has unknown value %q, valid values: %s",
+				node.name, node.value, strings.Join(fact.values, " "))
+		}
+	}
+
+	if p.pos == len(p.s) {
+		return node, errUnterminated
+	}
+	sep := p.s[p.pos]
+	p.pos++
+	if node.then, err = p.parse(string(sep)); err != nil {
+		return node, err
+	}
+	if p.pos == len(p.s) {
+		return node, errUnterminated
+	}
+	p.pos++
+	if node.els, err = p.parse(")"); err != nil {
+		return node, err
+	}
+	if p.pos == len(p.s) {
+		return node, errUnterminated
+	}
+	p.pos++
+	return node, nil
+}
+
+func isFormatNameChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || ch == '_'
+}
+
+func isFormatValueChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' ||
+		ch == '-' || ch == '/'
+}
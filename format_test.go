@@ -0,0 +1,137 @@
+package main
+
+import . "gopkg.in/check.v1"
+
+type FormatSuite struct{}
+
+var _ = Suite(&FormatSuite{})
+
+func (s *FormatSuite) TestParseErrors(c *C) {
+	cases := []struct {
+		format string
+		err    string
+	}{
+		{"%{dirty}", `format: %{dirty} can be used only in conditional`},
+		{"%{timeout}", `format: %{timeout} can be used only in conditional`},
+		{"%(dirty.x.y)%{untracked}", `format: %{untracked} can be used only in conditional`},
+		{"%{nope}", `format: unknown fact %{nope}`},
+		{"%(dirty", `format: unterminated conditional at 0`},
+		{"ab%(dirty.", `format: unterminated conditional at 2`},
+		{"%(dirty.x.y", `format: unterminated conditional at 0`},
+		{"%(dirty.x.%(remote.a.b)", `format: unterminated conditional at 0`},
+		{"%(dirty.x%.y", `format: unterminated conditional at 0`},
+		{"%(nope.x.y)", `format: unknown conditional %\(nope`},
+		{"%(branch=master.x.y)", `format: conditional %\(branch do not support value`},
+		{"%(state=merging.x.y)", `format: conditional %\(state has unknown value "merging", valid values: merge .*`},
+		{"%(vcs=nope.x.y)", `format: conditional %\(vcs has unknown value "nope", valid values: git hg svn bzr jj fossil sl darcs pijul cvs`},
+	}
+	for _, v := range cases {
+		_, err := ParseFormat(v.format)
+		c.Check(err, ErrorMatches, v.err, Commentf("%q", v.format))
+	}
+}
+
+func (s *FormatSuite) TestAttrs(c *C) {
+	cases := []struct {
+		format string
+		want   AttrList
+	}{
+		{"", AttrList{}},
+		{"%%b%F{red}%)%b%n%d%(d.x.y)%{%}", AttrList{}},
+		{"%{vcs}%{rev}%{branch}%{tag}%{state}", AttrList{RevisionShort: true, Branch: true, Tag: true, State: true}},
+		{"%{change}", AttrList{ChangeID: true}},
+		{"%{ahead}%{behind}%{stash}%{added}%{modified}%{deleted}%{renamed}%{unmerged}", AttrList{
+			CommitsAheadRemote:  true,
+			CommitsBehindRemote: true,
+			StashedCommits:      true,
+			AddedFiles:          true,
+			ModifiedFiles:       true,
+			DeletedFiles:        true,
+			RenamedFiles:        true,
+			UnmergedFiles:       true,
+		}},
+		{"%(remote..)%(stash..)%(dirty..)%(added..)%(modified..)%(deleted..)%(renamed..)%(unmerged..)%(untracked..)", AttrList{
+			HasRemote:         true,
+			HasStashedCommits: true,
+			IsDirty:           true,
+			HasAddedFiles:     true,
+			HasModifiedFiles:  true,
+			HasDeletedFiles:   true,
+			HasRenamedFiles:   true,
+			HasUnmergedFiles:  true,
+			HasUntrackedFiles: true,
+		}},
+		{"%(timeout..)%(empty_desc..)", AttrList{TimedOut: true, IsDescriptionEmpty: true}},
+		{"%(state=merge.%{branch}.%(tag.%{tag}.%{rev}))", AttrList{RevisionShort: true, Branch: true, Tag: true, State: true}},
+	}
+	for _, v := range cases {
+		f, err := ParseFormat(v.format)
+		c.Assert(err, IsNil, Commentf("%q", v.format))
+		v.want.VCS = true
+		c.Check(f.Attrs(), DeepEquals, v.want, Commentf("%q", v.format))
+	}
+}
+
+func (s *FormatSuite) TestExecute(c *C) {
+	res := Attr{
+		VCS:                VCSGit,
+		RevisionShort:      "0123abc",
+		Branch:             "master",
+		State:              StateMerge,
+		CommitsAheadRemote: 2,
+		HasStashedCommits:  true,
+		StashedCommits:     1,
+		IsDirty:            true,
+		HasUnmergedFiles:   true,
+		UnmergedFiles:      3,
+	}
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"100%%, %)(%", "100%, )(%"},
+		{"%F{red}%{branch}%f %b %B%k%K{blue}%x", "%F{red}master%f %b %B%k%K{blue}%x"},
+		{"%n@%m %D %M %N %C %c %d %! %{\x1b[1m%}%{branch}%{\x1b[0m%}", "%n@%m %D %M %N %C %c %d %! %{\x1b[1m%}master%{\x1b[0m%}"},
+		{"%(?.ok.fail)%(3c.a.b)%(d.x.y)%{ %{x %{branch", "%(?.ok.fail)%(3c.a.b)%(d.x.y)%{ %{x %{branch"},
+		{"%{vcs}:%{rev}:%{branch}:%{tag}:%{state}", "git:0123abc:master::merge"},
+		{"%{ahead} %{behind} %{stash} %{added} %{modified} %{deleted} %{renamed} %{unmerged}", "2 0 1 0 0 0 0 3"},
+		{"%(tag.%{tag}.no tag)", "no tag"},
+		{"%(branch.[%{branch}].)", "[master]"},
+		{"%(ahead.↑%{ahead}.)%(behind.↓%{behind}.)", "↑2"},
+		{"%(dirty.*.)%(untracked.?.)%(remote.^.)", "*"},
+		{"%(state=merge.MERGE.%{state})|%(state=rebase.REBASE.%{state})", "MERGE|merge"},
+		{"%(vcs=hg.☿.±)", "±"},
+		{"%(dirty:a.b:c%))", "a.b"},
+		{"%(untracked:a.b:c%))", "c)"},
+		{"%(dirty.a%.b%%.c)", "a.b%"},
+		{"%(dirty:%F{red}%:%f:%b)", "%F{red}:%f"},
+		{"%(untracked.a%.b.c.d)", "c.d"},
+		{"%(dirty.%(unmerged.conflict %{unmerged}.dirty).clean)", "conflict 3"},
+		{"%(dirty.x)y.z)", "x)y"},
+		{"%{branch}%(timeout.….)", "master"},
+	}
+	for _, v := range cases {
+		f, err := ParseFormat(v.format)
+		c.Assert(err, IsNil, Commentf("%q", v.format))
+		c.Check(f.Execute(res, AttrList{}), Equals, v.want, Commentf("%q", v.format))
+	}
+
+	res.TimedOut = true
+	f, err := ParseFormat("%{branch}%(timeout.….)")
+	c.Assert(err, IsNil)
+	c.Check(f.Execute(res, AttrList{}), Equals, "master…")
+
+	f, err = ParseFormat("%{branch} %(unmerged.%{unmerged}.)%(dirty.*.)%{stash}")
+	c.Assert(err, IsNil)
+	c.Check(f.Execute(res, AttrList{UnmergedFiles: true, IsDirty: true}), Equals, "master ~3~*1")
+	c.Check(f.Execute(res, AttrList{HasUnmergedFiles: true}), Equals, "master ~3*1")
+
+	f, err = ParseFormat("%(dirty.%(unmerged.%{unmerged}.dirty).clean)%(untracked.?.)")
+	c.Assert(err, IsNil)
+	c.Check(f.Execute(res, AttrList{IsDirty: true, UnmergedFiles: true}), Equals, "~3")
+	c.Check(f.Execute(res, AttrList{IsDirty: true, HasUntrackedFiles: true}), Equals, "~3") // empty isn't marked
+	res.IsDirty = false
+	c.Check(f.Execute(res, AttrList{IsDirty: true}), Equals, "~clean")
+}
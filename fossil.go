@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha3"
+	"database/sql"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Fossil support is based on reading checkout database (.fslckout or
+// _FOSSIL_) and repository database it refers to, without executing
+// fossil.
+//
+// RevisionShort contains first 10 chars of check-in hash. Branch and Tag
+// are detected using check-in tags. Stashes are reported as stashed
+// commits.
+//
+// Status is detected in a way similar to `fossil changes`: file content
+// is compared with check-in only if it's mtime was changed. Missing files
+// are reported as deleted. Fossil doesn't track conflicts, so unmerged
+// files aren't supported.
+// Only ignore-glob setting is used to detect extra (untracked) files, and
+// it's applied to both path and base name of a file.
+
+// fossilAttrs are attributes supported by VCSInfoFossil.
+var fossilAttrs = AttrList{
+	VCS:               true,
+	RevisionShort:     true,
+	Branch:            true,
+	Tag:               true,
+	State:             true,
+	HasStashedCommits: true,
+	StashedCommits:    true,
+	IsDirty:           true,
+	HasAddedFiles:     true,
+	AddedFiles:        true,
+	HasModifiedFiles:  true,
+	ModifiedFiles:     true,
+	HasDeletedFiles:   true,
+	DeletedFiles:      true,
+	HasRenamedFiles:   true,
+	RenamedFiles:      true,
+	HasUntrackedFiles: true,
+}
+
+// fossilCheckoutNames are possible names of checkout database.
+var fossilCheckoutNames = []string{".fslckout", "_FOSSIL_"}
+
+// Values of vfile.chnged.
+const (
+	fossilMergeAdd     = 3
+	fossilIntegrateAdd = 5
+)
+
+// Values of vmerge.id.
+const (
+	fossilCherrypick = -1
+	fossilBackout    = -2
+)
+
+// VCSInfoFossil returns fossil facts for current repo.
+func VCSInfoFossil(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSFossil {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(fossilAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.HasStashedCommits = l.HasStashedCommits || l.StashedCommits
+	l.VCS = true
+
+	facts.Found.VCS = VCSFossil
+
+	co, err := openFossil(facts.Repo.Root)
+	if err != nil {
+		log.Println("openFossil:", err)
+		return
+	}
+	defer co.close()
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  AttrList{RevisionShort: true, Branch: true, Tag: true},
+			detect: co.info,
+		},
+		{
+			attrs:  AttrList{State: true, HasStashedCommits: true, StashedCommits: true},
+			detect: co.state,
+		},
+		{
+			attrs:  statusAttrs,
+			detect: co.status,
+		},
+	})
+}
+
+// fossilCheckout is an opened checkout (both databases are safe for
+// concurrent use).
+type fossilCheckout struct {
+	root string
+	db   *sql.DB // checkout database
+	repo *sql.DB // repository database
+	rid  int64   // current check-in
+}
+
+func openFossil(root string) (*fossilCheckout, error) {
+	co := &fossilCheckout{root: root}
+	var err error
+	for _, name := range fossilCheckoutNames {
+		co.db, err = openSQLite(filepath.Join(root, name))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var repoPath string
+	err = co.db.QueryRow(`SELECT value FROM vvar WHERE name = 'checkout'`).Scan(&co.rid)
+	if err == nil {
+		err = co.db.QueryRow(`SELECT value FROM vvar WHERE name = 'repository'`).Scan(&repoPath)
+	}
+	if err != nil {
+		co.db.Close()
+		return nil, err
+	}
+	if !filepath.IsAbs(repoPath) {
+		repoPath = filepath.Join(root, repoPath)
+	}
+	co.repo, err = openSQLite(repoPath)
+	if err != nil {
+		co.db.Close()
+		return nil, err
+	}
+	return co, nil
+}
+
+func (co *fossilCheckout) close() {
+	co.db.Close()
+	co.repo.Close()
+}
+
+// hash returns hash of artifact.
+func (co *fossilCheckout) hash(ctx context.Context, rid int64) (uuid string, err error) {
+	err = co.repo.QueryRowContext(ctx, `SELECT uuid FROM blob WHERE rid = ?`, rid).Scan(&uuid)
+	return uuid, err
+}
+
+func (co *fossilCheckout) info(ctx context.Context, facts *Facts) {
+	l := &facts.Lookup
+	if l.RevisionShort {
+		uuid, err := co.hash(ctx, co.rid)
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+			return
+		} else if err != nil {
+			log.Println("fossil check-in:", err)
+		} else if len(uuid) > 10 {
+			facts.Found.RevisionShort = uuid[:10]
+		}
+	}
+	if !l.Branch && !l.Tag {
+		return
+	}
+
+	rows, err := co.repo.QueryContext(ctx, `
+		SELECT tag.tagname, COALESCE(tagxref.value, '')
+		FROM tagxref JOIN tag USING (tagid)
+		WHERE tagxref.rid = ? AND tagxref.tagtype > 0
+			AND (tag.tagname = 'branch' OR tag.tagname LIKE 'sym-%')`, co.rid)
+	if err != nil {
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+		} else {
+			log.Println("fossil tags:", err)
+		}
+		return
+	}
+	defer rows.Close()
+	var branch string
+	var tags []string
+	for rows.Next() {
+		var name, value string
+		if err = rows.Scan(&name, &value); err != nil {
+			log.Println("fossil tags:", err)
+			return
+		}
+		if name == "branch" {
+			branch = value
+		} else {
+			tags = append(tags, strings.TrimPrefix(name, "sym-"))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+		} else {
+			log.Println("fossil tags:", err)
+		}
+		return
+	}
+	if l.Branch {
+		facts.Found.Branch = branch
+	}
+	if l.Tag {
+		sort.Strings(tags)
+		for _, tag := range tags {
+			if tag != branch { // branch is also propagated as sym- tag
+				facts.Found.Tag = tag
+				break
+			}
+		}
+	}
+}
+
+func (co *fossilCheckout) state(ctx context.Context, facts *Facts) {
+	l := &facts.Lookup
+	if l.State {
+		facts.Found.State = StateNone
+		rows, err := co.db.QueryContext(ctx, `SELECT id FROM vmerge WHERE id <= 0`)
+		if err != nil {
+			if ctx.Err() != nil {
+				facts.TimedOut = facts.Lookup
+				return
+			}
+			log.Println("fossil vmerge:", err)
+		} else {
+			defer rows.Close()
+			for rows.Next() {
+				var id int
+				if rows.Scan(&id) != nil {
+					break
+				}
+				switch id {
+				case fossilCherrypick, fossilBackout:
+					facts.Found.State = StateCherrypick
+				default:
+					facts.Found.State = StateMerge
+				}
+			}
+		}
+	}
+	if l.HasStashedCommits {
+		var n int
+		// Stash table is created on first use.
+		err := co.db.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'stash'`).Scan(&n)
+		if err == nil && n > 0 {
+			err = co.db.QueryRowContext(ctx, `SELECT count(*) FROM stash`).Scan(&facts.Found.StashedCommits)
+		}
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+			return
+		} else if err != nil {
+			log.Println("fossil stash:", err)
+		}
+		facts.Found.HasStashedCommits = facts.Found.StashedCommits > 0
+		l.StashedCommits = true
+	}
+}
+
+// fossilFile is a file in checkout.
+type fossilFile struct {
+	pathname string
+	origname sql.NullString
+	chnged   int
+	deleted  bool
+	isexe    bool
+	islink   bool
+	rid      int64
+	mtime    sql.NullInt64
+}
+
+func (co *fossilCheckout) status(ctx context.Context, facts *Facts) {
+	files, err := co.files(ctx)
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return
+	} else if err != nil {
+		log.Println("fossil vfile:", err)
+		return
+	}
+
+	found := &facts.Found
+	known := make(map[string]bool, len(files))
+	for _, f := range files {
+		known[f.pathname] = true
+		switch {
+		case ctx.Err() != nil:
+			facts.TimedOut = facts.Lookup
+			facts.Found = Attr{}
+			return
+		case f.deleted:
+			found.DeletedFiles++
+		case f.rid == 0 || f.chnged == fossilMergeAdd || f.chnged == fossilIntegrateAdd:
+			found.AddedFiles++
+		case f.origname.Valid && f.origname.String != f.pathname:
+			found.RenamedFiles++
+		default:
+			switch modified, err := co.isModified(ctx, f); {
+			case os.IsNotExist(err):
+				found.DeletedFiles++
+			case err != nil:
+				log.Printf("fossil %s: %s", f.pathname, err)
+			case modified:
+				found.ModifiedFiles++
+			}
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+
+	l := &facts.Lookup
+	if l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked) {
+		found.HasUntrackedFiles = co.hasExtras(ctx, known)
+		l.HasUntrackedFiles = true
+	}
+	// These counters are detected anyway.
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.HasRenamedFiles, l.RenamedFiles = true, true
+}
+
+func (co *fossilCheckout) files(ctx context.Context) ([]fossilFile, error) {
+	rows, err := co.db.QueryContext(ctx, `
+		SELECT pathname, origname, chnged, deleted, isexe, islink, rid, mtime
+		FROM vfile WHERE vid = ?`, co.rid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []fossilFile
+	for rows.Next() {
+		var f fossilFile
+		err = rows.Scan(&f.pathname, &f.origname, &f.chnged, &f.deleted, &f.isexe, &f.islink, &f.rid, &f.mtime)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// isModified compares file in checkout with it's version in check-in.
+func (co *fossilCheckout) isModified(ctx context.Context, f fossilFile) (bool, error) {
+	name := filepath.Join(co.root, filepath.FromSlash(f.pathname))
+	fi, err := os.Lstat(name)
+	switch {
+	case err != nil:
+		return false, err
+	case f.chnged != 0:
+		return true, nil
+	case f.islink:
+		return fi.Mode()&os.ModeSymlink == 0, nil
+	case (fi.Mode()&0111 != 0) != f.isexe:
+		return true, nil
+	case f.mtime.Valid && fi.ModTime().Unix() == f.mtime.Int64:
+		return false, nil
+	}
+
+	uuid, err := co.hash(ctx, f.rid)
+	if err != nil {
+		return false, err
+	}
+	var h hash.Hash
+	switch len(uuid) {
+	case sha1.Size * 2:
+		h = sha1.New()
+	case 32 * 2:
+		h = sha3.New256()
+	default:
+		return true, nil
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err = io.Copy(h, file); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) != uuid, nil
+}
+
+// hasExtras returns true if checkout contains not ignored files which
+// are not known to fossil.
+func (co *fossilCheckout) hasExtras(ctx context.Context, known map[string]bool) bool {
+	globs := co.ignoreGlobs(ctx)
+	ignored := func(relpath string) bool {
+		for _, glob := range globs {
+			if ok, _ := path.Match(glob, relpath); ok {
+				return true
+			}
+			if ok, _ := path.Match(glob, path.Base(relpath)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	var walk func(dir string) bool
+	walk = func(dir string) bool {
+		files, err := ioutil.ReadDir(filepath.Join(co.root, filepath.FromSlash(dir)))
+		if err != nil {
+			return false
+		}
+		for _, fi := range files {
+			relpath := path.Join(dir, fi.Name())
+			switch {
+			case dir == "" && isFossilCheckoutFile(fi.Name()):
+			case ignored(relpath):
+			case fi.IsDir():
+				if walk(relpath) {
+					return true
+				}
+			case !known[relpath]:
+				return true
+			}
+		}
+		return false
+	}
+	return walk("")
+}
+
+// isFossilCheckoutFile returns true for checkout database and it's
+// temporary files.
+func isFossilCheckoutFile(name string) bool {
+	for _, db := range fossilCheckoutNames {
+		if strings.HasPrefix(name, db) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreGlobs returns ignore-glob setting: versioned one (from
+// .fossil-settings) overrides one in repository database.
+func (co *fossilCheckout) ignoreGlobs(ctx context.Context) []string {
+	buf, err := ioutil.ReadFile(filepath.Join(co.root, ".fossil-settings", "ignore-glob"))
+	if err != nil {
+		var value string
+		err = co.repo.QueryRowContext(ctx, `SELECT value FROM config WHERE name = 'ignore-glob'`).Scan(&value)
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("fossil ignore-glob:", err)
+		}
+		buf = []byte(value)
+	}
+	return strings.FieldsFunc(string(buf), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha3"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// testFossilCheckoutSchema is a subset of checkout database schema used
+// by VCSInfoFossil.
+const testFossilCheckoutSchema = `
+CREATE TABLE vvar (name TEXT PRIMARY KEY NOT NULL, value CLOB);
+CREATE TABLE vfile (
+	id INTEGER PRIMARY KEY, vid INTEGER, chnged INT DEFAULT 0,
+	deleted BOOLEAN DEFAULT 0, isexe BOOLEAN, islink BOOLEAN,
+	rid INTEGER, mrid INTEGER, mtime INTEGER, pathname TEXT,
+	origname TEXT, mhash);
+CREATE TABLE vmerge (id INTEGER, merge INTEGER, mhash TEXT);
+`
+
+// testFossilRepoSchema is a subset of repository database schema used by
+// VCSInfoFossil.
+const testFossilRepoSchema = `
+CREATE TABLE blob (rid INTEGER PRIMARY KEY, uuid TEXT UNIQUE NOT NULL, size INTEGER);
+CREATE TABLE tag (tagid INTEGER PRIMARY KEY, tagname TEXT UNIQUE);
+CREATE TABLE tagxref (
+	tagid INTEGER, tagtype INTEGER, srcid INTEGER, origid INTEGER,
+	value TEXT, mtime TIMESTAMP, rid INTEGER,
+	UNIQUE(rid, tagid));
+CREATE TABLE config (name TEXT PRIMARY KEY NOT NULL, value CLOB, mtime INTEGER);
+`
+
+type FossilSuite struct {
+	root  string
+	db    *sql.DB
+	repo  *sql.DB
+	req   Request
+	mtime time.Time
+}
+
+var _ = Suite(&FossilSuite{})
+
+func (s *FossilSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:               true,
+			RevisionShort:     true,
+			Branch:            true,
+			Tag:               true,
+			State:             true,
+			StashedCommits:    true,
+			IsDirty:           true,
+			AddedFiles:        true,
+			ModifiedFiles:     true,
+			DeletedFiles:      true,
+			RenamedFiles:      true,
+			HasUntrackedFiles: true,
+		},
+	}
+	s.mtime = time.Unix(1500000000, 0)
+	s.root = c.MkDir()
+	repoPath := filepath.Join(c.MkDir(), "project.fossil")
+	var err error
+	s.repo, err = sql.Open(sqliteDriver, repoPath)
+	c.Assert(err, IsNil)
+	_, err = s.repo.Exec(testFossilRepoSchema)
+	c.Assert(err, IsNil)
+	s.db, err = sql.Open(sqliteDriver, filepath.Join(s.root, ".fslckout"))
+	c.Assert(err, IsNil)
+	_, err = s.db.Exec(testFossilCheckoutSchema)
+	c.Assert(err, IsNil)
+	s.exec(c, s.db, `INSERT INTO vvar VALUES ('checkout', 1), ('repository', ?)`, repoPath)
+	s.exec(c, s.repo, `INSERT INTO blob VALUES (1, '1234567890abcdef1234567890abcdef12345678', 0)`)
+	s.tag(c, "branch", 2, "trunk")
+	s.tag(c, "sym-trunk", 2, "")
+}
+
+func (s *FossilSuite) TearDownTest(c *C) {
+	c.Assert(s.db.Close(), IsNil)
+	c.Assert(s.repo.Close(), IsNil)
+}
+
+func (s *FossilSuite) VCSInfoFossil(c *C) Attr {
+	facts := Facts{Repo: Repo{VCS: VCSFossil, Root: s.root}, Req: s.req}
+	VCSInfoFossil(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *FossilSuite) exec(c *C, db *sql.DB, query string, args ...interface{}) {
+	_, err := db.Exec(query, args...)
+	c.Assert(err, IsNil)
+}
+
+func (s *FossilSuite) tag(c *C, name string, tagtype int, value string) {
+	s.exec(c, s.repo, `INSERT OR IGNORE INTO tag (tagname) VALUES (?)`, name)
+	s.exec(c, s.repo, `INSERT INTO tagxref (tagid, tagtype, value, rid) SELECT tagid, ?, ?, 1 FROM tag WHERE tagname = ?`,
+		tagtype, value, name)
+}
+
+// file creates versioned unmodified file.
+func (s *FossilSuite) file(c *C, pathname, content string) {
+	name := filepath.Join(s.root, filepath.FromSlash(pathname))
+	c.Assert(ioutil.WriteFile(name, []byte(content), 0644), IsNil)
+	c.Assert(os.Chtimes(name, s.mtime, s.mtime), IsNil)
+	sum := sha1.Sum([]byte(content))
+	res, err := s.repo.Exec(`INSERT INTO blob (uuid, size) VALUES (?, ?)`, hex.EncodeToString(sum[:]), len(content))
+	c.Assert(err, IsNil)
+	rid, err := res.LastInsertId()
+	c.Assert(err, IsNil)
+	s.exec(c, s.db, `INSERT INTO vfile (vid, isexe, islink, rid, mtime, pathname) VALUES (1, 0, 0, ?, ?, ?)`,
+		rid, s.mtime.Unix(), pathname)
+}
+
+func (s *FossilSuite) TestFossilNoRepo(c *C) {
+	c.Assert(os.Remove(filepath.Join(s.root, ".fslckout")), IsNil)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{VCS: VCSFossil})
+	_, err := os.Stat(filepath.Join(s.root, ".fslckout"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *FossilSuite) TestFossilInfo(c *C) {
+	s.file(c, "a.txt", "a")
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{
+		VCS:           VCSFossil,
+		RevisionShort: "1234567890",
+		Branch:        "trunk",
+	})
+
+	s.tag(c, "sym-v1.0", 1, "")
+	s.tag(c, "sym-old", 0, "") // cancelled
+	s.req.Attr = AttrList{Branch: true, Tag: true}
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{Branch: "trunk", Tag: "v1.0"})
+}
+
+func (s *FossilSuite) TestFossilState(c *C) {
+	s.req.Attr = AttrList{State: true, StashedCommits: true}
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{})
+
+	s.exec(c, s.db, `CREATE TABLE stash (stashid INTEGER PRIMARY KEY, vid INTEGER, hash TEXT, comment TEXT, ctime TIMESTAMP)`)
+	s.exec(c, s.db, `INSERT INTO stash (vid) VALUES (1), (1)`)
+	s.exec(c, s.db, `INSERT INTO vmerge VALUES (0, 2, 'x'), (3, 2, 'x')`)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{State: StateMerge, StashedCommits: 2})
+
+	s.exec(c, s.db, `UPDATE vmerge SET id = -1 WHERE id = 0`)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{State: StateCherrypick, StashedCommits: 2})
+}
+
+func (s *FossilSuite) TestFossilStatus(c *C) {
+	s.req.Attr = AttrList{IsDirty: true, AddedFiles: true, ModifiedFiles: true, DeletedFiles: true, RenamedFiles: true}
+	c.Assert(os.Mkdir(filepath.Join(s.root, "dir"), 0755), IsNil)
+	for _, name := range []string{"a.txt", "dir/b.txt", "mod.txt", "touch.txt", "edited.txt", "exec.sh", "missing.txt", "del.txt", "new.txt"} {
+		s.file(c, name, name)
+	}
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "mod.txt"), []byte("MOD.TXT"), 0644), IsNil)
+	now := time.Now()
+	c.Assert(os.Chtimes(filepath.Join(s.root, "touch.txt"), now, now), IsNil)
+	s.exec(c, s.db, `UPDATE vfile SET chnged = 1 WHERE pathname = 'edited.txt'`)
+	c.Assert(os.Chmod(filepath.Join(s.root, "exec.sh"), 0755), IsNil)
+	c.Assert(os.Remove(filepath.Join(s.root, "missing.txt")), IsNil)
+	s.exec(c, s.db, `UPDATE vfile SET deleted = 1 WHERE pathname = 'del.txt'`)
+	s.exec(c, s.db, `UPDATE vfile SET origname = 'old.txt' WHERE pathname = 'new.txt'`)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "add.txt"), nil, 0644), IsNil)
+	s.exec(c, s.db, `INSERT INTO vfile (vid, isexe, islink, rid, pathname) VALUES (1, 0, 0, 0, 'add.txt')`)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{
+		IsDirty:       true,
+		AddedFiles:    1,
+		ModifiedFiles: 3,
+		DeletedFiles:  2,
+		RenamedFiles:  1,
+	})
+}
+
+func (s *FossilSuite) TestFossilSHA3(c *C) {
+	s.req.Attr = AttrList{ModifiedFiles: true}
+	s.file(c, "a.txt", "a")
+	sum := sha3.Sum256([]byte("a"))
+	s.exec(c, s.repo, `UPDATE blob SET uuid = ? WHERE rid = 2`, hex.EncodeToString(sum[:]))
+	now := time.Now()
+	c.Assert(os.Chtimes(filepath.Join(s.root, "a.txt"), now, now), IsNil)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{})
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "a.txt"), []byte("b"), 0644), IsNil)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{ModifiedFiles: 1})
+}
+
+func (s *FossilSuite) TestFossilExtras(c *C) {
+	s.req.Attr = AttrList{HasUntrackedFiles: true}
+	c.Assert(os.Mkdir(filepath.Join(s.root, "dir"), 0755), IsNil)
+	s.file(c, "dir/a.txt", "a")
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, ".fslckout-journal"), nil, 0644), IsNil)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{})
+
+	s.exec(c, s.repo, `INSERT INTO config (name, value) VALUES ('ignore-glob', '*.o, build/*')`)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "dir", "x.o"), nil, 0644), IsNil)
+	c.Assert(os.Mkdir(filepath.Join(s.root, "build"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "build", "x"), nil, 0644), IsNil)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "dir", "x.txt"), nil, 0644), IsNil)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{HasUntrackedFiles: true})
+
+	c.Assert(os.Mkdir(filepath.Join(s.root, ".fossil-settings"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, ".fossil-settings", "ignore-glob"), []byte("*.o\n*.txt\n"), 0644), IsNil)
+	s.file(c, ".fossil-settings/ignore-glob", "*.o\n*.txt\n")
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{HasUntrackedFiles: true}) // build/x
+	c.Assert(os.RemoveAll(filepath.Join(s.root, "build")), IsNil)
+	c.Check(s.VCSInfoFossil(c), DeepEquals, Attr{})
+}
@@ -1,3 +1,5 @@
+//go:build !purego
+
 package main
 
 import (
@@ -35,8 +37,24 @@ import (
 // - `git status` works 0.29/0.13 sec without/with core.untrackedCache.
 // - `git describe` works 0.5 sec
 
+// gitEngines contains alternative git engines, chosen by
+// Request.GitEngine.
+var gitEngines = map[string]func(context.Context, *Facts){
+	"libgit2": VCSInfoGit,
+	"go":      VCSInfoGitGo,
+	"exec":    VCSInfoGitExec,
+}
+
+const defaultGitEngine = "libgit2"
+
 // VCSInfoGit returns git facts for current dir or nil on error.
 //
+// Independent groups of facts are detected in parallel goroutines.
+// When ctx is done facts gathered so far are returned and attributes
+// which wasn't detected yet are marked in facts.TimedOut. Goroutines
+// which are still running are abandoned: libgit2 calls (like status
+// scan) can't be interrupted.
+//
 // More facts than requested may be returned: some non-requested facts may
 // be a required dependency for requested facts (this may also depends on
 // requested options), or implementation is just not optimized enough and
@@ -45,10 +63,6 @@ func VCSInfoGit(ctx context.Context, facts *Facts) {
 	// XXX Do not call Free() on any object - this should be faster
 	// and safe for short-lived command. DO NOT COPY&PASTE THIS AS IS!
 
-	// TODO Run everything in goroutines, and receive detected facts
-	// from then using channels. Return facts collected so far if context
-	// will be canceled because of deadline before all goroutines finish.
-
 	// Honor requested attributes.
 	facts.Lookup = facts.Req.Attr
 	l := &facts.Lookup
@@ -63,125 +77,188 @@ func VCSInfoGit(ctx context.Context, facts *Facts) {
 	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
 	l.HasStashedCommits = l.HasStashedCommits || l.StashedCommits
 	l.HasRemote = l.HasRemote || l.CommitsAheadRemote || l.CommitsBehindRemote
-	l.Branch = l.Branch || l.HasRemote
 	l.VCS = true
 
-	repo, err := git2go.OpenRepository(".")
+	if facts.Repo.VCS != VCSGit {
+		return
+	}
+	logNoApprox("libgit2", facts)
+	repo, err := gitOpen(facts.Repo)
 	if err != nil {
+		log.Println("gitOpen:", err)
 		return // not a (valid) repo
 	}
-	head, _ := repo.Head() // err if empty repo without commits
 	facts.Found.VCS = VCSGit
 
-	if l.RevisionShort && head != nil {
-		facts.Found.RevisionShort = head.Target().String()[:7]
+	// Fast facts are detected here, before starting goroutines.
+	gitHead(newGitRepo(facts.Repo), facts) // faster than repo.Head()
+	if l.State {
+		facts.Found.State = gitState(repo)
 	}
 
-	var branch *git2go.Branch
-	if l.Branch && head != nil {
-		if branch = head.Branch(); branch != nil {
-			facts.Found.Branch, _ = branch.Name() // err if detached HEAD
+	detectGroups(ctx, facts, gitGroups)
+}
+
+// gitGroups are groups of facts which can be detected independently.
+var gitGroups = []factGroup{
+	{
+		attrs:  AttrList{Tag: true},
+		detect: withGitRepo(gitTag),
+	},
+	{
+		attrs:  AttrList{HasRemote: true, CommitsAheadRemote: true, CommitsBehindRemote: true},
+		detect: withGitRepo(gitRemote),
+	},
+	{
+		attrs:  AttrList{HasStashedCommits: true, StashedCommits: true},
+		detect: withGitRepo(gitStash),
+	},
+	{
+		attrs:  statusAttrs,
+		detect: withGitRepo(gitStatus),
+	},
+}
+
+// gitOpen opens already detected repo without searching for it again.
+func gitOpen(repo Repo) (*git2go.Repository, error) {
+	return git2go.OpenRepositoryExtended(repo.Root, git2go.RepositoryOpenNoSearch, "")
+}
+
+// withGitRepo opens own repo object for detect because libgit2 objects
+// are not safe to use in multiple threads.
+func withGitRepo(detect func(context.Context, *git2go.Repository, *Facts)) func(context.Context, *Facts) {
+	return func(ctx context.Context, facts *Facts) {
+		repo, err := gitOpen(facts.Repo)
+		if err != nil {
+			log.Println("gitOpen:", err)
+			return
 		}
+		detect(ctx, repo, facts)
 	}
+}
 
-	if l.Tag {
-		// TODO run as goroutine - may walk all commits
-		tags := make(map[git2go.Oid]*git2go.Tag)
-		repo.Tags.Foreach(func(name string, id *git2go.Oid) error {
-			tag, err := repo.LookupTag(id)
-			if err != nil {
-				return nil // non-annotated tag
-			}
-			obj, err := tag.Peel(git2go.ObjectCommit)
-			if err != nil {
-				log.Println("tag.Peel:", err)
-				return nil
-			}
-			id = obj.Id()
-			if tags[*id] == nil || tags[*id].Tagger().When.Before(tag.Tagger().When) {
-				tags[*id] = tag
-			}
-			return nil
-		})
+func gitState(repo *git2go.Repository) VCSState {
+	switch state := repo.State(); state {
+	case git2go.RepositoryStateNone:
+		return StateNone
+	case git2go.RepositoryStateMerge:
+		return StateMerge
+	case git2go.RepositoryStateRevert:
+		return StateRevert
+	case git2go.RepositoryStateCherrypick:
+		return StateCherrypick
+	case git2go.RepositoryStateBisect:
+		return StateBisect
+	case git2go.RepositoryStateRebase:
+		return StateRebase
+	case git2go.RepositoryStateRebaseInteractive:
+		return StateRebaseInteractive
+	case git2go.RepositoryStateRebaseMerge:
+		return StateRebaseMerge
+	case git2go.RepositoryStateApplyMailbox:
+		return StateApplyMailbox
+	case git2go.RepositoryStateApplyMailboxOrRebase:
+		return StateApplyMailboxOrRebase
+	default:
+		log.Println("repo.State unknown:", state)
+		return StateNone
+	}
+}
 
-		// TODO OPTIMIZATION Skip walk if len(tags)==0.
-		revwalk, err := repo.Walk()
+// gitTag may walk all commits.
+func gitTag(ctx context.Context, repo *git2go.Repository, facts *Facts) {
+	tags := make(map[git2go.Oid]*git2go.Tag)
+	repo.Tags.Foreach(func(name string, id *git2go.Oid) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		tag, err := repo.LookupTag(id)
 		if err != nil {
-			log.Println("repo.Walk:", err)
-			return // FIXME just skip l.Tag?
+			return nil // non-annotated tag
 		}
-		err = revwalk.PushHead()
+		obj, err := tag.Peel(git2go.ObjectCommit)
 		if err != nil {
-			log.Println("revwalk.PushHead:", err)
-			return // FIXME just skip l.Tag?
+			log.Println("tag.Peel:", err)
+			return nil
 		}
-		var id git2go.Oid
-		for facts.Found.Tag == "" {
-			err = revwalk.Next(&id)
-			if git2go.IsErrorCode(err, git2go.ErrIterOver) {
-				break
-			}
-			if err != nil {
-				log.Println("revwalk.Next:", err)
-				return // FIXME just skip l.Tag?
-			}
-			if tags[id] != nil {
-				facts.Found.Tag = tags[id].Name()
-			}
+		id = obj.Id()
+		if tags[*id] == nil || tags[*id].Tagger().When.Before(tag.Tagger().When) {
+			tags[*id] = tag
 		}
-	}
+		return nil
+	})
 
-	if l.State {
-		switch state := repo.State(); state {
-		case git2go.RepositoryStateNone:
-			facts.Found.State = StateNone
-		case git2go.RepositoryStateMerge:
-			facts.Found.State = StateMerge
-		case git2go.RepositoryStateRevert:
-			facts.Found.State = StateRevert
-		case git2go.RepositoryStateCherrypick:
-			facts.Found.State = StateCherrypick
-		case git2go.RepositoryStateBisect:
-			facts.Found.State = StateBisect
-		case git2go.RepositoryStateRebase:
-			facts.Found.State = StateRebase
-		case git2go.RepositoryStateRebaseInteractive:
-			facts.Found.State = StateRebaseInteractive
-		case git2go.RepositoryStateRebaseMerge:
-			facts.Found.State = StateRebaseMerge
-		case git2go.RepositoryStateApplyMailbox:
-			facts.Found.State = StateApplyMailbox
-		case git2go.RepositoryStateApplyMailboxOrRebase:
-			facts.Found.State = StateApplyMailboxOrRebase
-		default:
-			log.Println("repo.State unknown:", state)
+	// TODO OPTIMIZATION Skip walk if len(tags)==0.
+	revwalk, err := repo.Walk()
+	if err != nil {
+		log.Println("repo.Walk:", err)
+		return
+	}
+	err = revwalk.PushHead()
+	if err != nil {
+		log.Println("revwalk.PushHead:", err)
+		return
+	}
+	var id git2go.Oid
+	for facts.Found.Tag == "" {
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+			return
+		}
+		err = revwalk.Next(&id)
+		if git2go.IsErrorCode(err, git2go.ErrIterOver) {
+			break
+		}
+		if err != nil {
+			log.Println("revwalk.Next:", err)
+			return
+		}
+		if tags[id] != nil {
+			facts.Found.Tag = tags[id].Name()
 		}
 	}
+}
 
-	if l.HasRemote && branch != nil {
-		upstream, err := branch.Upstream()
-		facts.Found.HasRemote = err == nil
-		if (l.CommitsAheadRemote || l.CommitsBehindRemote) && facts.Found.HasRemote {
-			// TODO run as goroutine - walk commits and calculate distance
-			facts.Found.CommitsAheadRemote, facts.Found.CommitsBehindRemote, err =
-				repo.AheadBehind(branch.Target(), upstream.Target())
-			if err != nil {
-				log.Println("repo.AheadBehind:", err)
-				return // FIXME just skip l.HasRemote
-			}
+// gitRemote may walk commits to calculate distance.
+func gitRemote(ctx context.Context, repo *git2go.Repository, facts *Facts) {
+	l := &facts.Lookup
+	head, err := repo.Head()
+	if err != nil {
+		return // empty repo without commits
+	}
+	branch := head.Branch()
+	upstream, err := branch.Upstream()
+	facts.Found.HasRemote = err == nil
+	if (l.CommitsAheadRemote || l.CommitsBehindRemote) && facts.Found.HasRemote {
+		facts.Found.CommitsAheadRemote, facts.Found.CommitsBehindRemote, err =
+			repo.AheadBehind(branch.Target(), upstream.Target())
+		if err != nil {
+			log.Println("repo.AheadBehind:", err)
 		}
 	}
+}
 
-	if l.HasStashedCommits {
-		repo.Stashes.Foreach(func(index int, message string, id *git2go.Oid) error {
-			facts.Found.HasStashedCommits = true
-			if !l.StashedCommits {
-				return errors.New("done")
-			}
-			facts.Found.StashedCommits++
-			return nil
-		})
+func gitStash(ctx context.Context, repo *git2go.Repository, facts *Facts) {
+	l := &facts.Lookup
+	repo.Stashes.Foreach(func(index int, message string, id *git2go.Oid) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		facts.Found.HasStashedCommits = true
+		if !l.StashedCommits {
+			return errors.New("done")
+		}
+		facts.Found.StashedCommits++
+		return nil
+	})
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		facts.Found.StashedCommits = 0 // incomplete
 	}
+}
+
+func gitStatus(ctx context.Context, repo *git2go.Repository, facts *Facts) {
+	l := &facts.Lookup
 
 	// Questionable optimizations (TBD):
 	// - Parallelize processing of status entries for large EntryCount() -
@@ -219,6 +296,10 @@ func VCSInfoGit(ctx context.Context, facts *Facts) {
 	}
 StatusList:
 	for _, statusShow := range tryStatusShow {
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+			break
+		}
 		var statusOpt git2go.StatusOpt
 		if statusShow != git2go.StatusShowIndexOnly {
 			statusOpt |= git2go.StatusOptUpdateIndex
@@ -255,6 +336,10 @@ StatusList:
 		}
 
 		for i := 0; i < n; i++ {
+			if ctx.Err() != nil {
+				facts.TimedOut = facts.Lookup
+				break StatusList
+			}
 			entry, err := statuses.ByIndex(i)
 			if err != nil {
 				log.Println("statuses.ByIndex:", err)
@@ -349,6 +434,14 @@ StatusList:
 			}
 		}
 	}
+	if facts.TimedOut != (AttrList{}) {
+		// Reset incomplete counters.
+		facts.Found.AddedFiles = 0
+		facts.Found.ModifiedFiles = 0
+		facts.Found.DeletedFiles = 0
+		facts.Found.RenamedFiles = 0
+		facts.Found.UnmergedFiles = 0
+	}
 	// Update facts.Lookup to actual dependencies to avoid
 	// false-positive QA notices.
 	for _, statusShow := range tryStatusShow {
@@ -0,0 +1,18 @@
+//go:build purego
+
+package main
+
+import "context"
+
+// Build without libgit2 and cgo (pure Go sqlite driver is used too):
+//
+//	CGO_ENABLED=0 go build -tags purego
+
+// gitEngines contains alternative git engines, chosen by
+// Request.GitEngine.
+var gitEngines = map[string]func(context.Context, *Facts){
+	"go":   VCSInfoGitGo,
+	"exec": VCSInfoGitExec,
+}
+
+const defaultGitEngine = "go"
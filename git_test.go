@@ -39,6 +39,14 @@ func gitstatus() {
 	cmd.Run()
 }
 
+func gitDetect() Repo {
+	cwd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	return DetectRepo(cwd)
+}
+
 func gitconfig() {
 	git("config user.email root@localhost")
 	git("config user.name Nobody")
@@ -47,6 +55,7 @@ func gitconfig() {
 }
 
 type GitSuite struct {
+	engine  func(context.Context, *Facts) // default engine if nil
 	origDir string
 	ctx     context.Context
 	req     Request
@@ -57,8 +66,8 @@ type GitSuite struct {
 var _ = Suite(&GitSuite{})
 
 func (s *GitSuite) VCSInfoGit() Attr {
-	facts := Facts{Req: s.req}
-	VCSInfoGit(s.ctx, &facts)
+	facts := Facts{Repo: gitDetect(), Req: s.req}
+	s.engine(s.ctx, &facts)
 	facts.QA()
 	s.res = facts.Result()
 	return s.res
@@ -82,6 +91,9 @@ func (s *GitSuite) enablePossibleOptimizations() {
 }
 
 func (s *GitSuite) SetUpSuite(c *C) {
+	if s.engine == nil {
+		s.engine = gitEngines[defaultGitEngine]
+	}
 	s.ctx = context.Background()
 	var err error
 	s.origDir, err = os.Getwd()
@@ -150,15 +162,16 @@ func (s *GitSuite) TestGitSeparate(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(fi.IsDir(), Equals, false) // repo is in separate dir
 
-	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // no branch
+	s.want.Branch = "master"
+	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // unborn branch
 
 	git("commit --allow-empty -m ROOT")
-	s.want.Branch = "master"
 	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // master branch
 }
 
 func (s *GitSuite) TestGitRevision(c *C) {
 	s.req.Attr.RevisionShort = true
+	s.want.Branch = "master"
 	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // no HEAD: no revision
 
 	git("commit --allow-empty -m ROOT")
@@ -167,10 +180,10 @@ func (s *GitSuite) TestGitRevision(c *C) {
 }
 
 func (s *GitSuite) TestGitBranch(c *C) {
-	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // default
+	s.want.Branch = "master"
+	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // unborn
 
 	git("commit --allow-empty -m ROOT")
-	s.want.Branch = "master"
 	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // default
 
 	git("checkout -b fix-a")
@@ -399,6 +412,7 @@ func (s *GitSuite) TestGitStash(c *C) {
 
 func (s *GitSuite) TestGitHasUntrackedFiles(c *C) {
 	s.enablePossibleOptimizations()
+	s.want.Branch = "master" // unborn or not
 	s.req.Attr.IsDirty = false
 	s.req.Attr.HasUntrackedFiles = true
 
@@ -423,6 +437,7 @@ func (s *GitSuite) TestGitHasUntrackedFiles_HEAD(c *C) {
 }
 
 func (s *GitSuite) TestGitIsDirty_IfUntracked(c *C) {
+	s.want.Branch = "master" // unborn or not
 	s.req.Attr.HasAddedFiles = false
 	s.req.Attr.AddedFiles = false
 
@@ -474,6 +489,7 @@ func (s *GitSuite) TestGitIsDirty_IfUntracked_HEAD2(c *C) {
 
 func (s *GitSuite) TestGitAddedFiles(c *C) {
 	s.enablePossibleOptimizations()
+	s.want.Branch = "master" // unborn or not
 	s.req.Attr.HasAddedFiles = true
 	s.req.Attr.AddedFiles = true
 	s.want.IsDirty = s.req.Attr.IsDirty
@@ -878,3 +894,26 @@ func (s *GitSuite) TestGitStateRevert(c *C) {
 	s.want.HasModifiedFiles = false
 	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // revert completed
 }
+
+func (s *GitSuite) TestGitTimeout(c *C) {
+	s.req.Attr.TimedOut = true
+	git("commit --allow-empty -m ROOT")
+	c.Assert(ioutil.WriteFile("a.txt", nil, 0666), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	facts := Facts{Repo: gitDetect(), Req: s.req}
+	s.engine(ctx, &facts)
+	facts.QA()
+	s.want.Branch = "master"
+	s.want.TimedOut = true
+	c.Check(facts.Result(), DeepEquals, s.want) // only fast facts
+	c.Check(facts.TimedOut.Branch, Equals, false)
+	c.Check(facts.TimedOut.Tag, Equals, true)
+	c.Check(facts.TimedOut.HasUntrackedFiles, Equals, true)
+
+	s.want.HasUntrackedFiles = true
+	s.want.IsDirty = true
+	s.want.TimedOut = false
+	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // no deadline
+}
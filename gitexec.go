@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Git support based on executing git, as alternative to libgit2: single
+// `git status --porcelain=v2 --branch --show-stash` returns most facts
+// and it supports core.untrackedCache and fsmonitor, which makes it
+// faster than libgit2 on large repos (see git.go).
+//
+// Tag is detected in a same way as by libgit2 engine (latest annotated
+// tag on first tagged commit in `git rev-list HEAD` order, which differs
+// from `git describe`), state is detected using files in git dir.
+// RenamesFromRewrites isn't supported: `git status` has no such option.
+// Stash count is read from reflog if git is older than 2.35 (it has no
+// --show-stash).
+
+// gitExecAttrs are attributes supported by VCSInfoGitExec.
+var gitExecAttrs = AttrList{
+	VCS:                 true,
+	RevisionShort:       true,
+	Branch:              true,
+	Tag:                 true,
+	State:               true,
+	HasRemote:           true,
+	CommitsAheadRemote:  true,
+	CommitsBehindRemote: true,
+	HasStashedCommits:   true,
+	StashedCommits:      true,
+	IsDirty:             true,
+	HasAddedFiles:       true,
+	AddedFiles:          true,
+	HasModifiedFiles:    true,
+	ModifiedFiles:       true,
+	HasDeletedFiles:     true,
+	DeletedFiles:        true,
+	HasRenamedFiles:     true,
+	RenamedFiles:        true,
+	HasUnmergedFiles:    true,
+	UnmergedFiles:       true,
+	HasUntrackedFiles:   true,
+}
+
+// gitPorcelainAttrs are attributes detected by `git status --porcelain=v2`.
+var gitPorcelainAttrs = AttrList{
+	RevisionShort:       true,
+	Branch:              true,
+	HasRemote:           true,
+	CommitsAheadRemote:  true,
+	CommitsBehindRemote: true,
+	HasStashedCommits:   true,
+	StashedCommits:      true,
+	IsDirty:             true,
+	HasAddedFiles:       true,
+	AddedFiles:          true,
+	HasModifiedFiles:    true,
+	ModifiedFiles:       true,
+	HasDeletedFiles:     true,
+	DeletedFiles:        true,
+	HasRenamedFiles:     true,
+	RenamedFiles:        true,
+	HasUnmergedFiles:    true,
+	UnmergedFiles:       true,
+	HasUntrackedFiles:   true,
+}
+
+// gitUnmergedCodes are valid XY codes of unmerged entries, see
+// git-status(1). Codes are only validated and then collapsed into
+// UnmergedFiles: Attr has no per-code counters and other engines
+// don't distinguish conflict kinds either.
+var gitUnmergedCodes = map[string]bool{
+	"DD": true, // both deleted
+	"AU": true, // added by us
+	"UD": true, // deleted by them
+	"UA": true, // added by them
+	"DU": true, // deleted by us
+	"AA": true, // both added
+	"UU": true, // both modified
+}
+
+// VCSInfoGitExec returns git facts for current repo using git command.
+func VCSInfoGitExec(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSGit {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(gitExecAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasUnmergedFiles = l.HasUnmergedFiles || l.UnmergedFiles
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.HasStashedCommits = l.HasStashedCommits || l.StashedCommits
+	l.HasRemote = l.HasRemote || l.CommitsAheadRemote || l.CommitsBehindRemote
+	l.VCS = true
+
+	facts.Found.VCS = VCSGit
+	logNoApprox("exec", facts)
+
+	if l.State {
+		facts.Found.State = gitDirState(facts.Repo.GitDir)
+	}
+	// HEAD is read natively, so Branch and RevisionShort are found
+	// even if git status times out.
+	gitHead(newGitRepo(facts.Repo), facts)
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  AttrList{Tag: true},
+			detect: gitExecTag,
+		},
+		{
+			attrs:  gitPorcelainAttrs.Without(gitHeadAttrs),
+			detect: gitExecStatus,
+		},
+	})
+}
+
+// gitCommand returns git command for facts.Repo.
+func gitCommand(ctx context.Context, facts *Facts, args ...string) *exec.Cmd {
+	args = append([]string{"-C", facts.Repo.Root}, args...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	// Prompt shouldn't conflict with git commands running in parallel.
+	cmd.Env = append(os.Environ(), "GIT_OPTIONAL_LOCKS=0", "LC_ALL=C")
+	return cmd
+}
+
+// gitExec executes git command for facts.Repo and returns it's stdout.
+func gitExec(ctx context.Context, facts *Facts, args ...string) ([]byte, error) {
+	out, err := gitCommand(ctx, facts, args...).Output()
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.Printf("git %s: %s", strings.Join(args, " "), err)
+	}
+	return out, err
+}
+
+func gitExecStatus(ctx context.Context, facts *Facts) {
+	l := &facts.Lookup
+	args := []string{"status", "--porcelain=v2", "--branch", "-z"}
+	if l.HasStashedCommits {
+		args = append(args, "--show-stash")
+	}
+	if !l.CommitsAheadRemote && !l.CommitsBehindRemote {
+		args = append(args, "--no-ahead-behind")
+	}
+	if l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked) {
+		args = append(args, "--untracked-files=normal")
+		l.HasUntrackedFiles = true
+	} else {
+		args = append(args, "--untracked-files=no")
+	}
+	if !facts.Req.IncludeSubmodules {
+		args = append(args, "--ignore-submodules=all")
+	}
+	if !l.HasRenamedFiles {
+		args = append(args, "--no-renames")
+	}
+
+	out, err := gitExec(ctx, facts, args...)
+	if err != nil {
+		return
+	}
+	parseGitPorcelainV2(out, &facts.Found)
+	if l.HasStashedCommits && facts.Found.StashedCommits == 0 {
+		// Git before 2.35 has no --show-stash.
+		gitGoStash(ctx, newGitRepo(facts.Repo), facts)
+	}
+	// These facts are detected anyway.
+	l.RevisionShort, l.Branch, l.HasRemote = true, true, true
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles // --no-renames
+	l.HasUnmergedFiles, l.UnmergedFiles = true, true
+}
+
+// parseGitPorcelainV2 parses output of git status --porcelain=v2
+// --branch -z (optionally with --show-stash).
+func parseGitPorcelainV2(out []byte, found *Attr) {
+	records := strings.Split(string(out), "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if record == "" {
+			continue
+		}
+		switch record[0] {
+		case '#':
+			parseGitPorcelainHeader(record, found)
+		case '1', '2':
+			fields := strings.SplitN(record, " ", 3)
+			if len(fields) < 3 || len(fields[1]) != 2 {
+				log.Printf("git status: unexpected record: %q", record)
+				continue
+			}
+			x, y := fields[1][0], fields[1][1]
+			if record[0] == '2' {
+				i++ // skip original path
+				switch x {
+				case 'R':
+					found.RenamedFiles++
+				case 'C':
+					found.AddedFiles++
+				}
+			}
+			if x == 'A' || y == 'A' { // Y is A for intent-to-add
+				found.AddedFiles++
+			}
+			if x == 'M' || y == 'M' {
+				found.ModifiedFiles++
+			}
+			if x == 'D' || y == 'D' {
+				found.DeletedFiles++
+			}
+			if x == 'T' || y == 'T' { // file/symlink
+				found.ModifiedFiles++
+			}
+		case 'u':
+			fields := strings.SplitN(record, " ", 3)
+			if len(fields) < 3 || !gitUnmergedCodes[fields[1]] {
+				log.Printf("git status: unexpected record: %q", record)
+				continue
+			}
+			found.UnmergedFiles++
+		case '?':
+			found.HasUntrackedFiles = true
+		case '!':
+		default:
+			log.Printf("git status: unexpected record: %q", record)
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+	found.HasUnmergedFiles = found.UnmergedFiles > 0
+}
+
+func parseGitPorcelainHeader(record string, found *Attr) {
+	fields := strings.Fields(record)
+	if len(fields) < 3 {
+		log.Printf("git status: unexpected header: %q", record)
+		return
+	}
+	switch fields[1] {
+	case "branch.oid":
+		if fields[2] != "(initial)" && len(fields[2]) >= 7 {
+			found.RevisionShort = fields[2][:7]
+		}
+	case "branch.head":
+		if fields[2] != "(detached)" {
+			found.Branch = fields[2]
+		}
+	case "branch.ab":
+		// Upstream is reported even if it doesn't exist, but ahead/behind
+		// only if it exists.
+		found.HasRemote = true
+		if len(fields) == 4 {
+			found.CommitsAheadRemote, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+			found.CommitsBehindRemote, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+		}
+	case "stash":
+		found.StashedCommits, _ = strconv.Atoi(fields[2])
+		found.HasStashedCommits = found.StashedCommits > 0
+	}
+}
+
+// gitExecTag may walk all commits.
+func gitExecTag(ctx context.Context, facts *Facts) {
+	// Commit → latest annotated tag.
+	out, err := gitExec(ctx, facts, "for-each-ref", "--sort=taggerdate",
+		"--format=%(*objecttype) %(*objectname) %(refname:strip=2)", "refs/tags")
+	if err != nil {
+		return
+	}
+	var lines [][]string
+	var nested []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) == 3 && fields[0] == "tag" {
+			nested = append(nested, fields[2])
+		}
+		lines = append(lines, fields)
+	}
+	peeled, err := gitExecPeelTags(ctx, facts, nested)
+	if err != nil {
+		return
+	}
+	tags := make(map[string]string)
+	for _, fields := range lines {
+		switch {
+		case len(fields) != 3:
+		case fields[0] == "commit": // non-annotated have no type
+			tags[fields[1]] = fields[2] // later overrides former
+		case fields[0] == "tag" && peeled[fields[2]] != "":
+			tags[peeled[fields[2]]] = fields[2]
+		}
+	}
+	if len(tags) == 0 {
+		return
+	}
+
+	revCtx, cancel := context.WithCancel(ctx)
+	cmd := gitCommand(revCtx, facts, "rev-list", "HEAD")
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		log.Println("git rev-list:", err)
+		return
+	}
+	defer func() {
+		cancel() // stop rev-list if tag was found
+		_ = cmd.Wait()
+	}()
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if tag, ok := tags[scanner.Text()]; ok {
+			facts.Found.Tag = tag
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+	}
+}
+
+// gitExecPeelTags returns commits pointed by tags of tags (for-each-ref
+// peels only one level) by tag names. Tags which doesn't point to commit
+// are not returned.
+func gitExecPeelTags(ctx context.Context, facts *Facts, names []string) (map[string]string, error) {
+	peeled := make(map[string]string)
+	if len(names) == 0 {
+		return peeled, nil
+	}
+	var input strings.Builder
+	for _, name := range names {
+		input.WriteString("refs/tags/" + name + "^{commit}\n")
+	}
+	cmd := gitCommand(ctx, facts, "cat-file", "--batch-check=%(objectname) %(objecttype)")
+	cmd.Stdin = strings.NewReader(input.String())
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.Println("git cat-file:", err)
+		return nil, err
+	}
+	for i, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if fields := strings.Fields(line); i < len(names) && len(fields) == 2 && fields[1] == "commit" {
+			peeled[names[i]] = fields[0]
+		}
+	}
+	return peeled, nil
+}
+
+// gitDirState detects state using files in gitDir, in same order as
+// libgit2.
+func gitDirState(gitDir string) VCSState {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(gitDir, name))
+		return err == nil
+	}
+	switch {
+	case exists("rebase-merge/interactive"):
+		return StateRebaseInteractive
+	case exists("rebase-merge"):
+		return StateRebaseMerge
+	case exists("rebase-apply/rebasing"):
+		return StateRebase
+	case exists("rebase-apply/applying"):
+		return StateApplyMailbox
+	case exists("rebase-apply"):
+		return StateApplyMailboxOrRebase
+	case exists("MERGE_HEAD"):
+		return StateMerge
+	case exists("REVERT_HEAD"):
+		return StateRevert
+	case exists("CHERRY_PICK_HEAD"):
+		return StateCherrypick
+	case exists("BISECT_LOG"):
+		return StateBisect
+	}
+	return StateNone
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type GitExecSuite struct {
+	origDir string
+	req     Request
+	want    Attr
+}
+
+var _ = Suite(&GitExecSuite{})
+
+// GitExecEngineSuite runs GitSuite tests using exec engine.
+type GitExecEngineSuite struct{ GitSuite }
+
+var _ = Suite(&GitExecEngineSuite{GitSuite{engine: VCSInfoGitExec}})
+
+func (s *GitExecEngineSuite) TestGitRenamedFiles(c *C) {
+	c.Skip("git status has no RenamesFromRewrites")
+}
+
+func (s *GitExecEngineSuite) TestGitRenamedFiles2(c *C) {
+	c.Skip("git status has no RenamesFromRewrites")
+}
+
+func (s *GitExecSuite) VCSInfoGitExec(ctx context.Context) Attr {
+	facts := Facts{Repo: gitDetect(), Req: s.req}
+	VCSInfoGitExec(ctx, &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *GitExecSuite) SetUpSuite(c *C) {
+	var err error
+	s.origDir, err = os.Getwd()
+	c.Assert(err, IsNil)
+}
+
+func (s *GitExecSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:                 true,
+			Branch:              true,
+			Tag:                 true,
+			State:               true,
+			HasRemote:           true,
+			CommitsAheadRemote:  true,
+			CommitsBehindRemote: true,
+			HasStashedCommits:   true,
+			StashedCommits:      true,
+			IsDirty:             true,
+			HasAddedFiles:       true,
+			AddedFiles:          true,
+			HasModifiedFiles:    true,
+			ModifiedFiles:       true,
+			HasDeletedFiles:     true,
+			DeletedFiles:        true,
+			HasRenamedFiles:     true,
+			RenamedFiles:        true,
+			HasUnmergedFiles:    true,
+			UnmergedFiles:       true,
+			HasUntrackedFiles:   true,
+		},
+		IncludeSubmodules: true,
+	}
+	s.want = Attr{VCS: VCSGit}
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+	git("init")
+	gitconfig()
+}
+
+func (s *GitExecSuite) TearDownSuite(c *C) {
+	c.Assert(os.Chdir(s.origDir), IsNil)
+}
+
+func (s *GitExecSuite) TestParsePorcelainV2(c *C) {
+	out := "# branch.oid 0123456789abcdef0123456789abcdef01234567\x00" +
+		"# branch.head fix/a\x00" +
+		"# branch.upstream origin/fix/a\x00" +
+		"# branch.ab +2 -3\x00" +
+		"# stash 4\x00" +
+		"1 A. N... 000000 100644 100644 0000 1111 new.txt\x00" +
+		"1 MM N... 100644 100644 100644 1111 2222 mod.txt\x00" +
+		"1 .T N... 100644 100644 120000 1111 1111 link\x00" +
+		"1 D. N... 100644 000000 000000 1111 0000 del.txt\x00" +
+		"1 .D N... 100644 100644 000000 1111 1111 missing.txt\x00" +
+		"2 R. N... 100644 100644 100644 1111 1111 R100 new name.txt\x00old name.txt\x00" +
+		"2 RM N... 100644 100644 100644 1111 2222 R90 b.txt\x00a.txt\x00" +
+		"u UU N... 100644 100644 100644 100644 1111 2222 3333 both.txt\x00" +
+		"u DU N... 100644 000000 100644 000000 1111 0000 3333 gone.txt\x00" +
+		"u XX N... 100644 100644 100644 100644 1111 2222 3333 bad.txt\x00" +
+		"? junk.txt\x00" +
+		"! ignored.txt\x00"
+	var found Attr
+	parseGitPorcelainV2([]byte(out), &found)
+	c.Check(found, DeepEquals, Attr{
+		RevisionShort:       "0123456",
+		Branch:              "fix/a",
+		HasRemote:           true,
+		CommitsAheadRemote:  2,
+		CommitsBehindRemote: 3,
+		HasStashedCommits:   true,
+		StashedCommits:      4,
+		HasAddedFiles:       true,
+		AddedFiles:          1,
+		HasModifiedFiles:    true,
+		ModifiedFiles:       3,
+		HasDeletedFiles:     true,
+		DeletedFiles:        2,
+		HasRenamedFiles:     true,
+		RenamedFiles:        2,
+		HasUnmergedFiles:    true,
+		UnmergedFiles:       2,
+		HasUntrackedFiles:   true,
+	})
+
+	found = Attr{}
+	out = "# branch.oid (initial)\x00# branch.head master\x00"
+	parseGitPorcelainV2([]byte(out), &found)
+	c.Check(found, DeepEquals, Attr{Branch: "master"}) // unborn branch
+
+	found = Attr{}
+	out = "# branch.oid 0123456789abcdef0123456789abcdef01234567\x00# branch.head (detached)\x00"
+	parseGitPorcelainV2([]byte(out), &found)
+	c.Check(found, DeepEquals, Attr{RevisionShort: "0123456"})
+
+	found = Attr{}
+	out = "# branch.head master\x00# branch.upstream origin/master\x00# branch.ab +? -?\x00"
+	parseGitPorcelainV2([]byte(out), &found)
+	c.Check(found, DeepEquals, Attr{Branch: "master", HasRemote: true})
+}
+
+func (s *GitExecSuite) TestGitDirState(c *C) {
+	gitDir := c.MkDir()
+	c.Check(gitDirState(gitDir), Equals, StateNone)
+	for _, v := range []struct {
+		name string
+		want VCSState
+	}{
+		{"BISECT_LOG", StateBisect},
+		{"CHERRY_PICK_HEAD", StateCherrypick},
+		{"REVERT_HEAD", StateRevert},
+		{"MERGE_HEAD", StateMerge},
+		{"rebase-apply/", StateApplyMailboxOrRebase},
+		{"rebase-apply/applying", StateApplyMailbox},
+		{"rebase-merge/", StateRebaseMerge},
+		{"rebase-merge/interactive", StateRebaseInteractive},
+	} {
+		name := filepath.Join(gitDir, v.name)
+		if v.name[len(v.name)-1] == '/' {
+			c.Assert(os.Mkdir(name, 0755), IsNil)
+		} else {
+			c.Assert(ioutil.WriteFile(name, nil, 0644), IsNil)
+		}
+		c.Check(gitDirState(gitDir), Equals, v.want, Commentf("%s", v.name))
+	}
+}
+
+func (s *GitExecSuite) TestGitExec(c *C) {
+	s.want.Branch = "master"
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // empty repo
+
+	c.Assert(ioutil.WriteFile("a.txt", []byte("a1"), 0644), IsNil)
+	s.want.HasUntrackedFiles = true
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // untracked
+
+	git("add a.txt")
+	s.want.HasUntrackedFiles = false
+	s.want.IsDirty = true
+	s.want.HasAddedFiles = true
+	s.want.AddedFiles = 1
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // added
+
+	git("commit -m msg1")
+	git("tag v1.0.0 -m msg")
+	git("commit --allow-empty -m msg2")
+	s.want = Attr{VCS: VCSGit, Branch: "master", Tag: "v1.0.0"}
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // tag on previous commit
+
+	c.Assert(ioutil.WriteFile("a.txt", []byte("a2"), 0644), IsNil)
+	git("stash")
+	s.want.HasStashedCommits = true
+	s.want.StashedCommits = 1
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // stashed
+
+	git("mv a.txt b.txt")
+	s.want.IsDirty = true
+	s.want.HasRenamedFiles = true
+	s.want.RenamedFiles = 1
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // renamed
+
+	git("commit -m msg3")
+	git("checkout -b set2")
+	c.Assert(ioutil.WriteFile("b.txt", []byte("b2"), 0644), IsNil)
+	git("commit -am msg4")
+	git("checkout master")
+	c.Assert(ioutil.WriteFile("b.txt", []byte("b3"), 0644), IsNil)
+	git("commit -am msg5")
+	gitforce("merge set2")
+	s.want.State = StateMerge
+	s.want.HasRenamedFiles = false
+	s.want.RenamedFiles = 0
+	s.want.HasUnmergedFiles = true
+	s.want.UnmergedFiles = 1
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // merge conflict
+
+	git("add b.txt")
+	s.want.HasUnmergedFiles = false
+	s.want.UnmergedFiles = 0
+	s.want.HasModifiedFiles = true
+	s.want.ModifiedFiles = 1
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // merge conflict resolved
+}
+
+func (s *GitExecSuite) TestGitExecNestedTag(c *C) {
+	s.req.Attr = AttrList{Tag: true}
+	git("commit --allow-empty -m msg")
+	git("tag inner -m msg")
+	git("tag outer inner -m msg")
+	git("tag -d inner")
+	git("tag tree HEAD^{tree} -m msg")
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, Attr{Tag: "outer"})
+}
+
+func (s *GitExecSuite) TestGitExecRemote(c *C) {
+	s.req.Attr.Branch = false
+	originDir, err := os.Getwd()
+	c.Assert(err, IsNil)
+	cloneDir := c.MkDir()
+	c.Assert(os.Remove(cloneDir), IsNil)
+	c.Assert(originDir, Matches, "^\\S*$") // required to split git params
+	c.Assert(cloneDir, Matches, "^\\S*$")  // required to split git params
+	git("commit --allow-empty -m ROOT")
+	git("clone " + originDir + " " + cloneDir)
+	c.Assert(os.Chdir(cloneDir), IsNil)
+	gitconfig()
+
+	git("commit --allow-empty -m msg1")
+	c.Assert(os.Chdir(originDir), IsNil)
+	git("commit --allow-empty -m msg2")
+	git("commit --allow-empty -m msg3")
+	c.Assert(os.Chdir(cloneDir), IsNil)
+	git("fetch")
+	s.want.HasRemote = true
+	s.want.CommitsAheadRemote = 1
+	s.want.CommitsBehindRemote = 2
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // clone diverse
+
+	s.req.Attr.CommitsAheadRemote = false
+	s.req.Attr.CommitsBehindRemote = false
+	s.want.CommitsAheadRemote = 0
+	s.want.CommitsBehindRemote = 0
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // without counting
+
+	git("checkout @^")
+	s.want.HasRemote = false
+	c.Check(s.VCSInfoGitExec(context.Background()), DeepEquals, s.want) // detached HEAD
+}
+
+func (s *GitExecSuite) TestGitExecTimeout(c *C) {
+	s.req.Attr.TimedOut = true
+	git("commit --allow-empty -m ROOT")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.want.Branch = "master"
+	s.want.TimedOut = true
+	c.Check(s.VCSInfoGitExec(ctx), DeepEquals, s.want) // only fast facts
+}
@@ -0,0 +1,938 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Git support in pure Go, as alternative to libgit2 (see git.go) which
+// doesn't need cgo. It reads repository files directly (see gitrepo.go,
+// gitpack.go, gitindex.go and gitignore.go) and tries to return same
+// facts as libgit2 engine.
+//
+// Not supported: SHA-256 repos, reftable, split and sparse index,
+// core.untrackedCache and fsmonitor (everything is scanned), clean/smudge
+// filters and core.autocrlf (files with changed stat data are compared
+// as is). Index is never updated, so files with changed stat data but
+// same content will be hashed every time until next git command.
+
+// VCSInfoGitGo returns git facts for current repo using pure Go
+// implementation.
+//
+// Independent groups of facts are detected in parallel goroutines, in
+// same way as by VCSInfoGit.
+func VCSInfoGitGo(ctx context.Context, facts *Facts) {
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasUnmergedFiles = l.HasUnmergedFiles || l.UnmergedFiles
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.HasStashedCommits = l.HasStashedCommits || l.StashedCommits
+	l.HasRemote = l.HasRemote || l.CommitsAheadRemote || l.CommitsBehindRemote
+	l.VCS = true
+
+	if facts.Repo.VCS != VCSGit {
+		return
+	}
+	repo, err := openGitRepo(facts.Repo)
+	if err != nil {
+		log.Println("openGitRepo:", err)
+		return // not a (valid) repo
+	}
+	defer repo.close()
+	facts.Found.VCS = VCSGit
+
+	// Fast facts are detected here, before starting goroutines.
+	gitHead(repo, facts)
+	if l.State {
+		facts.Found.State = gitDirState(repo.gitDir)
+	}
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  AttrList{Tag: true},
+			detect: withGitGoRepo(gitGoTag),
+		},
+		{
+			attrs:  AttrList{HasRemote: true, CommitsAheadRemote: true, CommitsBehindRemote: true},
+			detect: withGitGoRepo(gitGoRemote),
+		},
+		{
+			attrs:  AttrList{HasStashedCommits: true, StashedCommits: true},
+			detect: withGitGoRepo(gitGoStash),
+		},
+		{
+			attrs:  statusAttrs,
+			detect: withGitGoRepo(gitGoStatus),
+		},
+		{
+			attrs:  statusAttrs,
+			approx: AttrList{IsDirty: true, HasModifiedFiles: true, ModifiedFiles: true},
+			detect: withGitGoRepo(gitGoStatusStat),
+		},
+	})
+}
+
+// withGitGoRepo opens own repo object for detect because gitRepo is not
+// safe to use in multiple threads.
+func withGitGoRepo(detect func(context.Context, *gitRepo, *Facts)) func(context.Context, *Facts) {
+	return func(ctx context.Context, facts *Facts) {
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+			return
+		}
+		repo, err := openGitRepo(facts.Repo)
+		if err != nil {
+			log.Println("openGitRepo:", err)
+			return
+		}
+		defer repo.close()
+		detect(ctx, repo, facts)
+	}
+}
+
+// gitGoTag may walk all commits.
+func gitGoTag(ctx context.Context, repo *gitRepo, facts *Facts) {
+	refs, err := repo.refs("refs/tags/")
+	if err != nil {
+		log.Println("git tags:", err)
+		return
+	}
+	tags := make(map[gitOid]*gitTagObject)
+	for _, id := range refs {
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+			return
+		}
+		typ, data, err := repo.object(id)
+		if err != nil {
+			log.Println("git tag:", err)
+			continue
+		}
+		if typ != gitObjTag {
+			continue // non-annotated tag
+		}
+		tag := parseGitTag(data)
+		target := tag
+		for i := 0; target.typ == gitObjTag && i < 10; i++ { // peel
+			if data, err = repo.typedObject(target.object, gitObjTag); err != nil {
+				log.Println("git tag:", err)
+				break
+			}
+			target = parseGitTag(data)
+		}
+		if target.typ != gitObjCommit {
+			continue
+		}
+		id = target.object
+		if tags[id] == nil || tags[id].time < tag.time {
+			tags[id] = tag
+		}
+	}
+	if len(tags) == 0 {
+		return
+	}
+
+	_, head, err := repo.head()
+	if err != nil {
+		return // empty repo without commits
+	}
+	walk := newGitRevWalk(repo)
+	err = walk.push(head, 0)
+	for err == nil {
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+			return
+		}
+		var id gitOid
+		var commit *gitCommit
+		if id, commit, err = walk.next(); err != nil {
+			break
+		}
+		if tags[id] != nil {
+			facts.Found.Tag = tags[id].name
+			return
+		}
+		err = walk.pushParents(commit, 0)
+	}
+	if err != io.EOF {
+		log.Println("git rev walk:", err)
+	}
+}
+
+// gitGoRemote may walk commits to calculate distance.
+func gitGoRemote(ctx context.Context, repo *gitRepo, facts *Facts) {
+	l := &facts.Lookup
+	branch, head, err := repo.head()
+	if err != nil || branch == "" {
+		return // empty repo without commits or detached HEAD
+	}
+	upstream, err := repo.upstream(branch)
+	if err != nil {
+		if !errors.Is(err, errGitNotFound) {
+			log.Println("git upstream:", err)
+		}
+		return
+	}
+	facts.Found.HasRemote = true
+	if l.CommitsAheadRemote || l.CommitsBehindRemote {
+		ahead, behind, err := gitAheadBehind(ctx, repo, head, upstream)
+		switch {
+		case ctx.Err() != nil:
+			facts.TimedOut = facts.Lookup
+		case err != nil:
+			log.Println("git ahead/behind:", err)
+		default:
+			facts.Found.CommitsAheadRemote, facts.Found.CommitsBehindRemote = ahead, behind
+		}
+	}
+}
+
+// upstream returns id of upstream branch for local branch (full ref
+// name), using branch.<name>.remote and branch.<name>.merge config.
+func (r *gitRepo) upstream(branch string) (gitOid, error) {
+	name := strings.TrimPrefix(branch, "refs/heads/")
+	remote, _ := r.config.get("branch." + name + ".remote")
+	merge, _ := r.config.get("branch." + name + ".merge")
+	if remote == "" || merge == "" {
+		return gitOid{}, errGitNotFound
+	}
+	ref := merge
+	if remote != "." {
+		ref = ""
+		for _, refspec := range r.config["remote."+remote+".fetch"] {
+			if dst, ok := gitRefspecMap(refspec, merge); ok {
+				ref = dst
+			}
+		}
+		if ref == "" {
+			return gitOid{}, errGitNotFound
+		}
+	}
+	_, id, err := r.resolveRef(ref)
+	return id, err
+}
+
+// gitRefspecMap returns destination of ref for fetch refspec.
+func gitRefspecMap(refspec, ref string) (string, bool) {
+	refspec = strings.TrimPrefix(refspec, "+")
+	i := strings.IndexByte(refspec, ':')
+	if i == -1 || strings.HasPrefix(refspec, "^") {
+		return "", false
+	}
+	src, dst := refspec[:i], refspec[i+1:]
+	star := strings.IndexByte(src, '*')
+	if star == -1 {
+		return dst, src == ref
+	}
+	prefix, suffix := src[:star], src[star+1:]
+	if len(ref) < len(prefix)+len(suffix) || !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) {
+		return "", false
+	}
+	return strings.Replace(dst, "*", ref[len(prefix):len(ref)-len(suffix)], 1), true
+}
+
+// gitAheadBehind returns amount of commits reachable only from local
+// and only from upstream.
+func gitAheadBehind(ctx context.Context, repo *gitRepo, local, upstream gitOid) (ahead, behind int, err error) {
+	const (
+		fromLocal    = 1
+		fromUpstream = 2
+		fromBoth     = fromLocal | fromUpstream
+		stale        = 4
+	)
+	walk := newGitRevWalk(repo)
+	if err = walk.push(local, fromLocal); err == nil {
+		err = walk.push(upstream, fromUpstream)
+	}
+	// Commits with same time may be walked before their children, so
+	// stale commits should be walked again if they was walked without
+	// some flags.
+	walked := make(map[gitOid]int) // flags used to push parents
+	for err == nil && (walk.hasUnmarked(stale) || walk.hasChanged(walked)) {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+		var commit *gitCommit
+		var id gitOid
+		if id, commit, err = walk.next(); err != nil {
+			break
+		}
+		if walk.flags[id]&fromBoth == fromBoth {
+			walk.flags[id] |= stale
+		}
+		walked[id] = walk.flags[id]
+		err = walk.pushParents(commit, walk.flags[id])
+	}
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	for _, flags := range walk.flags {
+		switch flags & fromBoth {
+		case fromLocal:
+			ahead++
+		case fromUpstream:
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// gitRevWalk walks commits in commit time order (latest first).
+type gitRevWalk struct {
+	repo  *gitRepo
+	queue gitCommitQueue
+	seq   int
+	flags map[gitOid]int // seen commits
+}
+
+type gitCommitQueue []gitQueuedCommit
+
+type gitQueuedCommit struct {
+	id     gitOid
+	commit *gitCommit
+	seq    int // keep push order for commits with same time
+}
+
+func (q gitCommitQueue) Len() int { return len(q) }
+func (q gitCommitQueue) Less(i, j int) bool {
+	if q[i].commit.time != q[j].commit.time {
+		return q[i].commit.time > q[j].commit.time
+	}
+	return q[i].seq < q[j].seq
+}
+func (q gitCommitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *gitCommitQueue) Push(x interface{}) { *q = append(*q, x.(gitQueuedCommit)) }
+func (q *gitCommitQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+func newGitRevWalk(repo *gitRepo) *gitRevWalk {
+	return &gitRevWalk{repo: repo, flags: make(map[gitOid]int)}
+}
+
+// push adds commit with flags to walk, unless it was already added with
+// same flags.
+func (w *gitRevWalk) push(id gitOid, flags int) error {
+	old, seen := w.flags[id]
+	if seen && old|flags == old {
+		return nil
+	}
+	w.flags[id] = old | flags
+	commit, err := w.repo.commit(id)
+	if err != nil {
+		return err
+	}
+	w.seq++
+	heap.Push(&w.queue, gitQueuedCommit{id: id, commit: commit, seq: w.seq})
+	return nil
+}
+
+func (w *gitRevWalk) pushParents(commit *gitCommit, flags int) error {
+	for _, parent := range commit.parents {
+		if err := w.push(parent, flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// next returns latest commit from walk or io.EOF.
+func (w *gitRevWalk) next() (gitOid, *gitCommit, error) {
+	if len(w.queue) == 0 {
+		return gitOid{}, nil, io.EOF
+	}
+	c := heap.Pop(&w.queue).(gitQueuedCommit)
+	return c.id, c.commit, nil
+}
+
+// hasChanged returns true if queue has commits which was walked with
+// other flags.
+func (w *gitRevWalk) hasChanged(walked map[gitOid]int) bool {
+	for _, c := range w.queue {
+		if flags, ok := walked[c.id]; ok && flags != w.flags[c.id] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUnmarked returns true if queue has commits without flag.
+func (w *gitRevWalk) hasUnmarked(flag int) bool {
+	for _, c := range w.queue {
+		if w.flags[c.id]&flag == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func gitGoStash(ctx context.Context, repo *gitRepo, facts *Facts) {
+	l := &facts.Lookup
+	if _, _, err := repo.readRef("refs/stash"); err != nil {
+		if !errors.Is(err, errGitNotFound) {
+			log.Println("git stash:", err)
+		}
+		return
+	}
+	f, err := os.Open(filepath.Join(repo.commonDir, "logs", "refs", "stash"))
+	if err != nil {
+		log.Println("git stash:", err)
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && ctx.Err() == nil {
+		if scanner.Text() == "" {
+			continue
+		}
+		facts.Found.HasStashedCommits = true
+		if !l.StashedCommits {
+			break
+		}
+		facts.Found.StashedCommits++
+	}
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		facts.Found.StashedCommits = 0 // incomplete
+	}
+}
+
+// Status flags of path, same as in libgit2.
+const (
+	gitStatusIndexNew = 1 << iota
+	gitStatusIndexModified
+	gitStatusIndexDeleted
+	gitStatusIndexRenamed
+	gitStatusIndexTypeChange
+	gitStatusWtNew
+	gitStatusWtModified
+	gitStatusWtDeleted
+	gitStatusWtTypeChange
+	gitStatusConflicted
+)
+
+const (
+	gitRenameThreshold  = 50  // minimal similarity for rename
+	gitBreakThreshold   = 60  // modified file with lower self-similarity is a rewrite
+	gitRenameLimit      = 200 // max amount of sources/targets for inexact renames
+	gitSubmoduleDefault = "none"
+)
+
+func gitGoStatus(ctx context.Context, repo *gitRepo, facts *Facts) {
+	gitGoStatusWith(ctx, repo, facts, false)
+}
+
+// gitGoStatusStat is approximate gitGoStatus: files with changed stat
+// data are reported as modified without comparing their content (so
+// touched but not changed files are modified too).
+func gitGoStatusStat(ctx context.Context, repo *gitRepo, facts *Facts) {
+	gitGoStatusWith(ctx, repo, facts, true)
+}
+
+func gitGoStatusWith(ctx context.Context, repo *gitRepo, facts *Facts, statOnly bool) {
+	l := &facts.Lookup
+	untracked := l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked)
+
+	idx, err := readGitIndex(filepath.Join(repo.gitDir, "index"))
+	if err != nil {
+		log.Println("git index:", err)
+		return
+	}
+	statuses, err := gitStatusHeadToIndex(repo, idx, l.HasRenamedFiles, facts.Req.RenamesFromRewrites)
+	if err != nil {
+		log.Println("git status:", err)
+		return
+	}
+	if ctx.Err() == nil {
+		gitStatusIndexToWorkdir(ctx, repo, idx, statuses, facts.Req.IncludeSubmodules, statOnly)
+	}
+	if ctx.Err() == nil && untracked {
+		facts.Found.HasUntrackedFiles = gitHasUntracked(ctx, repo, idx)
+	}
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		facts.Found.HasUntrackedFiles = false
+		return
+	}
+
+	for _, status := range statuses {
+		if status&gitStatusConflicted != 0 {
+			facts.Found.UnmergedFiles++
+			continue
+		}
+		if status&gitStatusIndexNew != 0 {
+			facts.Found.AddedFiles++
+		}
+		if status&(gitStatusIndexModified|gitStatusWtModified) != 0 {
+			facts.Found.ModifiedFiles++
+		}
+		if status&(gitStatusIndexDeleted|gitStatusWtDeleted) != 0 {
+			facts.Found.DeletedFiles++
+		}
+		if status&gitStatusIndexRenamed != 0 {
+			facts.Found.RenamedFiles++
+		}
+		if status&(gitStatusIndexTypeChange|gitStatusWtTypeChange) != 0 { // file/symlink
+			facts.Found.ModifiedFiles++
+		}
+	}
+	facts.Found.HasAddedFiles = facts.Found.AddedFiles > 0
+	facts.Found.HasModifiedFiles = facts.Found.ModifiedFiles > 0
+	facts.Found.HasDeletedFiles = facts.Found.DeletedFiles > 0
+	facts.Found.HasRenamedFiles = facts.Found.RenamedFiles > 0
+	facts.Found.HasUnmergedFiles = facts.Found.UnmergedFiles > 0
+	// These counters are detected anyway.
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.RenamedFiles = l.HasRenamedFiles // renames are detected only if requested
+	l.HasUnmergedFiles, l.UnmergedFiles = true, true
+	l.HasUntrackedFiles = untracked
+}
+
+// gitStatusHeadToIndex returns status flags of paths changed between
+// HEAD and index.
+func gitStatusHeadToIndex(repo *gitRepo, idx *gitIndex, renames, fromRewrites bool) (map[string]int, error) {
+	statuses := make(map[string]int)
+	head := make(map[string]gitTreeEntry)
+	same := make(map[string]bool) // dirs same in HEAD and index
+	if _, id, err := repo.head(); err == nil {
+		commit, err := repo.commit(id)
+		if err != nil {
+			return nil, err
+		}
+		if err = gitFlattenTree(repo, commit.tree, "", head, idx.cacheTree, same); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, errGitNotFound) {
+		return nil, err
+	}
+
+	staged := make(map[string]*gitIndexEntry)
+	for i := range idx.entries {
+		e := &idx.entries[i]
+		if e.stage != 0 {
+			statuses[e.path] = gitStatusConflicted
+			continue
+		}
+		if gitInSameDir(e.path, same) {
+			continue
+		}
+		staged[e.path] = e
+		h, ok := head[e.path]
+		switch {
+		case !ok:
+			statuses[e.path] = gitStatusIndexNew
+		case h.mode&gitModeType != e.mode&gitModeType:
+			statuses[e.path] = gitStatusIndexTypeChange
+		case h.id != e.id || h.mode != e.mode:
+			statuses[e.path] = gitStatusIndexModified
+		}
+	}
+	for p := range head {
+		if staged[p] == nil && statuses[p] == 0 {
+			statuses[p] = gitStatusIndexDeleted
+		}
+	}
+
+	if renames {
+		if err := gitFindRenames(repo, statuses, head, staged, fromRewrites); err != nil {
+			return nil, err
+		}
+	}
+	return statuses, nil
+}
+
+// gitInSameDir returns true if any parent dir of p is in same.
+func gitInSameDir(p string, same map[string]bool) bool {
+	if len(same) == 0 {
+		return false
+	}
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if same[dir] {
+			return true
+		}
+	}
+	return same[""]
+}
+
+// gitFlattenTree adds all non-tree entries of tree id to entries,
+// except ones in subtrees which are same in cacheTree (these are added
+// to same).
+func gitFlattenTree(repo *gitRepo, id gitOid, dir string, entries map[string]gitTreeEntry, cacheTree map[string]gitOid, same map[string]bool) error {
+	if cached, ok := cacheTree[dir]; ok && cached == id {
+		same[dir] = true
+		return nil
+	}
+	tree, err := repo.tree(id)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree {
+		p := entry.name
+		if dir != "" {
+			p = dir + "/" + p
+		}
+		if entry.mode == gitModeTree {
+			if err = gitFlattenTree(repo, entry.id, p, entries, cacheTree, same); err != nil {
+				return err
+			}
+			continue
+		}
+		entries[p] = entry
+	}
+	return nil
+}
+
+type gitRenamePair struct {
+	src, dst string
+	score    int
+}
+
+// gitFindRenames replaces added and deleted (or rewritten) statuses with
+// renamed ones.
+func gitFindRenames(repo *gitRepo, statuses map[string]int, head map[string]gitTreeEntry, staged map[string]*gitIndexEntry, fromRewrites bool) error {
+	var srcs, dsts []string
+	for p, status := range statuses {
+		switch status {
+		case gitStatusIndexDeleted:
+			srcs = append(srcs, p)
+		case gitStatusIndexNew:
+			dsts = append(dsts, p)
+		}
+	}
+	if len(dsts) == 0 {
+		return nil
+	}
+	sort.Strings(srcs)
+	sort.Strings(dsts)
+
+	var pairs []gitRenamePair
+	sigs := make(map[gitOid]*gitHashsig)
+	signature := func(id gitOid) (*gitHashsig, error) {
+		if sig, ok := sigs[id]; ok {
+			return sig, nil
+		}
+		data, err := repo.typedObject(id, gitObjBlob)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			sigs[id] = nil // empty files are renamed only if same
+		} else {
+			sigs[id] = newGitHashsig(data)
+		}
+		return sigs[id], nil
+	}
+
+	if fromRewrites {
+		for p, status := range statuses {
+			if status != gitStatusIndexModified || head[p].mode&gitModeType != gitModeFile&gitModeType {
+				continue
+			}
+			a, err := signature(head[p].id)
+			if err != nil {
+				return err
+			}
+			b, err := signature(staged[p].id)
+			if err != nil {
+				return err
+			}
+			if a == nil || b == nil || a.similarity(b) < gitBreakThreshold {
+				srcs = append(srcs, p)
+			}
+		}
+	}
+
+	inexact := len(srcs) <= gitRenameLimit && len(dsts) <= gitRenameLimit
+	for _, src := range srcs {
+		for _, dst := range dsts {
+			a, b := head[src], staged[dst]
+			switch {
+			case a.id == b.id:
+				pairs = append(pairs, gitRenamePair{src: src, dst: dst, score: 101}) // exact first
+			case !inexact, a.mode&gitModeType != gitModeFile&gitModeType, b.mode&gitModeType != gitModeFile&gitModeType:
+			default:
+				sigA, err := signature(a.id)
+				if err != nil {
+					return err
+				}
+				sigB, err := signature(b.id)
+				if err != nil {
+					return err
+				}
+				if sigA == nil || sigB == nil {
+					continue
+				}
+				if score := sigA.similarity(sigB); score >= gitRenameThreshold {
+					pairs = append(pairs, gitRenamePair{src: src, dst: dst, score: score})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].score > pairs[j].score })
+	used := make(map[string]bool)
+	for _, pair := range pairs {
+		if used[pair.src] || used[pair.dst] {
+			continue
+		}
+		used[pair.src], used[pair.dst] = true, true
+		statuses[pair.dst] = gitStatusIndexRenamed
+		if statuses[pair.src] == gitStatusIndexDeleted {
+			delete(statuses, pair.src)
+		} else { // rewritten: old content moved, new content is added
+			statuses[pair.src] = gitStatusIndexNew
+		}
+	}
+	return nil
+}
+
+// gitStatusIndexToWorkdir adds status flags of paths changed between
+// index and workdir. Files with changed stat data are compared by
+// content in parallel, unless statOnly.
+func gitStatusIndexToWorkdir(ctx context.Context, repo *gitRepo, idx *gitIndex, statuses map[string]int, includeSubmodules, statOnly bool) {
+	fileMode := repo.config.bool("core.filemode", true)
+	var submodules map[string]string // ignore setting by path
+	if includeSubmodules {
+		submodules = gitSubmodulesIgnore(repo)
+	}
+
+	results := make([]int, len(idx.entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < runtime.NumCPU(); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = gitWorkdirStatus(ctx, repo, idx, &idx.entries[i], fileMode, submodules, statOnly)
+			}
+		}()
+	}
+	for i := range idx.entries {
+		e := &idx.entries[i]
+		switch {
+		case ctx.Err() != nil:
+		case e.stage != 0, e.skipCheck:
+		case e.mode == gitModeGitlink && !includeSubmodules:
+		default:
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, status := range results {
+		if status != 0 {
+			statuses[idx.entries[i].path] |= status
+		}
+	}
+}
+
+func gitWorkdirStatus(ctx context.Context, repo *gitRepo, idx *gitIndex, e *gitIndexEntry, fileMode bool, submodules map[string]string, statOnly bool) int {
+	if ctx.Err() != nil {
+		return 0
+	}
+	name := filepath.Join(repo.workDir, filepath.FromSlash(e.path))
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return gitStatusWtDeleted
+	}
+	if e.mode == gitModeGitlink {
+		if !fi.IsDir() {
+			return gitStatusWtTypeChange
+		}
+		return gitSubmoduleStatus(ctx, name, e.id, submodules[e.path])
+	}
+
+	var mode uint32
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		mode = gitModeSymlink
+	case fi.Mode().IsRegular() && !fileMode && e.mode&gitModeType == gitModeFile&gitModeType:
+		mode = e.mode
+	case fi.Mode().IsRegular() && fi.Mode()&0100 != 0:
+		mode = gitModeExec
+	case fi.Mode().IsRegular():
+		mode = gitModeFile
+	default:
+		return gitStatusWtDeleted // replaced by dir
+	}
+	switch {
+	case mode&gitModeType != e.mode&gitModeType:
+		return gitStatusWtTypeChange
+	case mode != e.mode, uint32(fi.Size()) != e.size:
+		return gitStatusWtModified
+	}
+	mtime := fi.ModTime().UnixNano()
+	const second = 1e9
+	sameTime := mtime == e.mtime || (e.mtime%second == 0 && mtime/second == e.mtime/second)
+	switch {
+	case sameTime && (statOnly || !idx.isRacy(e)):
+		return 0
+	case statOnly:
+		return gitStatusWtModified
+	}
+
+	var id gitOid
+	if mode == gitModeSymlink {
+		target, err := os.Readlink(name)
+		if err != nil {
+			return gitStatusWtModified
+		}
+		id = gitBlobID(strings.NewReader(target), int64(len(target)))
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return gitStatusWtModified
+		}
+		defer f.Close()
+		id = gitBlobID(f, fi.Size())
+	}
+	if id != e.id {
+		return gitStatusWtModified
+	}
+	return 0
+}
+
+// gitBlobID returns id of blob with content from r.
+func gitBlobID(r io.Reader, size int64) gitOid {
+	var id gitOid
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", size)
+	if _, err := io.Copy(h, r); err != nil {
+		return id
+	}
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// gitSubmodulesIgnore returns submodule.<name>.ignore settings (from
+// .gitmodules, overridden by config) by submodule path.
+func gitSubmodulesIgnore(repo *gitRepo) map[string]string {
+	modules := make(gitConfig)
+	if buf, err := ioutil.ReadFile(filepath.Join(repo.workDir, ".gitmodules")); err == nil {
+		modules.parse(buf)
+	}
+	submodules := make(map[string]string)
+	for key := range modules {
+		if !strings.HasPrefix(key, "submodule.") || !strings.HasSuffix(key, ".path") {
+			continue
+		}
+		name := strings.TrimSuffix(key, ".path")
+		p, _ := modules.get(key)
+		ignore, ok := repo.config.get(name + ".ignore")
+		if !ok {
+			ignore, ok = modules.get(name + ".ignore")
+		}
+		if !ok {
+			ignore = gitSubmoduleDefault
+		}
+		submodules[strings.Trim(p, "/")] = ignore
+	}
+	return submodules
+}
+
+// gitSubmoduleStatus compares submodule in dir with commit id according
+// to ignore setting.
+func gitSubmoduleStatus(ctx context.Context, dir string, id gitOid, ignore string) int {
+	if ignore == "" {
+		ignore = gitSubmoduleDefault
+	}
+	if ignore == "all" {
+		return 0
+	}
+	sub := DetectRepo(dir)
+	if sub.VCS != VCSGit || sub.Root != dir {
+		return 0 // not checked out
+	}
+	repo, err := openGitRepo(sub)
+	if err != nil {
+		log.Println("openGitRepo:", err)
+		return 0
+	}
+	_, head, err := repo.head()
+	repo.close()
+	if err != nil || head != id {
+		return gitStatusWtModified
+	}
+	if ignore == "dirty" {
+		return 0
+	}
+	facts := Facts{
+		Repo: sub,
+		Req: Request{
+			Attr:              AttrList{IsDirty: true},
+			DirtyIfUntracked:  ignore == "none",
+			IncludeSubmodules: true,
+		},
+	}
+	VCSInfoGitGo(ctx, &facts)
+	if facts.Result().IsDirty {
+		return gitStatusWtModified
+	}
+	return 0
+}
+
+// gitHasUntracked returns true if workdir contains not ignored files
+// which are not in index (including nested repos).
+func gitHasUntracked(ctx context.Context, repo *gitRepo, idx *gitIndex) bool {
+	tracked := make(map[string]uint32, len(idx.entries))
+	for _, e := range idx.entries {
+		tracked[e.path] = e.mode
+	}
+	ignore := newGitIgnore(repo)
+	var walk func(dir string) bool
+	walk = func(dir string) bool {
+		files, err := ioutil.ReadDir(filepath.Join(repo.workDir, filepath.FromSlash(dir)))
+		if err != nil || ctx.Err() != nil {
+			return false
+		}
+		for _, fi := range files {
+			relpath := path.Join(dir, fi.Name())
+			_, isTracked := tracked[relpath]
+			switch {
+			case fi.Name() == ".git":
+			case isTracked:
+			case ignore.ignored(relpath, fi.IsDir()):
+			case !fi.IsDir():
+				return true
+			case gitIsNestedRepo(filepath.Join(repo.workDir, filepath.FromSlash(relpath))):
+				return true
+			case walk(relpath):
+				return true
+			}
+		}
+		return false
+	}
+	return walk("")
+}
+
+func gitIsNestedRepo(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+// GitGoSuite runs GitSuite tests using pure Go engine, unless it's
+// already a default engine.
+type GitGoSuite struct{ GitSuite }
+
+func init() {
+	if defaultGitEngine != "go" {
+		Suite(&GitGoSuite{GitSuite{engine: VCSInfoGitGo}})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "sort"
+
+// Content similarity used for rename detection, same idea as libgit2's
+// default metric (hashsig with smart whitespace handling): hash runs of
+// text split by newlines and compare sets of smallest and largest hashes.
+
+const (
+	gitHashsigMaxRun   = 80
+	gitHashsigHeapSize = 127
+	gitHashsigStart    = 0x012345678ABCDEF0
+)
+
+type gitHashsig struct {
+	mins  []uint32 // sorted
+	maxs  []uint32 // sorted
+	lines int
+}
+
+func newGitHashsig(data []byte) *gitHashsig {
+	var hashes []uint32
+	lines := 0
+	skipSpace := true // at line start
+	for i := 0; i < len(data); {
+		state, n := uint64(gitHashsigStart), 0
+		for n < gitHashsigMaxRun && i < len(data) {
+			ch := data[i]
+			i++
+			switch {
+			case ch == '\r':
+				continue
+			case skipSpace && (ch == ' ' || ch == '\t' || ch == '\v' || ch == '\f'):
+				continue
+			}
+			skipSpace = ch == '\n'
+			if ch == '\n' || ch == 0 {
+				lines++
+				break
+			}
+			n++
+			state = state<<5 - state + uint64(ch)
+		}
+		if n > 0 {
+			hashes = append(hashes, uint32(state))
+			for i < len(data) && (data[i] == '\n' || data[i] == 0) {
+				i++
+			}
+		}
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	sig := &gitHashsig{mins: hashes, maxs: hashes, lines: lines}
+	if len(hashes) > gitHashsigHeapSize {
+		sig.mins = hashes[:gitHashsigHeapSize]
+		sig.maxs = hashes[len(hashes)-gitHashsigHeapSize:]
+	}
+	return sig
+}
+
+// similarity returns 0-100.
+func (a *gitHashsig) similarity(b *gitHashsig) int {
+	if len(a.mins) == 0 && len(b.mins) == 0 {
+		if a.lines == 0 && b.lines == 0 {
+			return 100
+		}
+		return 0
+	}
+	if len(a.mins) < gitHashsigHeapSize {
+		return gitHashsigOverlap(a.mins, b.mins)
+	}
+	return (gitHashsigOverlap(a.mins, b.mins) + gitHashsigOverlap(a.maxs, b.maxs)) / 2
+}
+
+func gitHashsigOverlap(a, b []uint32) int {
+	matches := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			i, j, matches = i+1, j+1, matches+1
+		}
+	}
+	return 100 * 2 * matches / (len(a) + len(b))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type GitHashsigSuite struct{}
+
+var _ = Suite(&GitHashsigSuite{})
+
+func (s *GitHashsigSuite) TestSimilarity(c *C) {
+	var lines bytes.Buffer
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&lines, "line %d\n", i)
+	}
+	text := lines.String()
+	for _, v := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 100},
+		{"\n", "", 0},
+		{"a\nb\n", "a\nb\n", 100},
+		{"a\nb\n", "a\r\n  b\n", 100}, // smart whitespace
+		{"a\nb\n", "a b\n", 0},
+		{"a\nb\n", "a\nc\n", 50},
+		{"a\nb\nc\n", "a\n", 50},
+		{text, text, 100},
+		{text, strings.ToUpper(text), 0},
+		{text, "x\n" + text[len("line 0\n"):], 99},
+	} {
+		sim := newGitHashsig([]byte(v.a)).similarity(newGitHashsig([]byte(v.b)))
+		c.Check(sim, Equals, v.want, Commentf("%q %q", v.a, v.b))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+)
+
+// gitHeadAttrs are facts which VCSInfoGitHead is able to detect.
+var gitHeadAttrs = AttrList{VCS: true, RevisionShort: true, Branch: true}
+
+// VCSInfoGitHead returns git facts for current repo which can be
+// detected by reading just HEAD, loose refs and packed-refs. This is a
+// fast path used by any git engine when only gitHeadAttrs are requested.
+func VCSInfoGitHead(ctx context.Context, facts *Facts) {
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(gitHeadAttrs))
+	facts.Lookup.VCS = true
+
+	if facts.Repo.VCS != VCSGit {
+		return
+	}
+	facts.Found.VCS = VCSGit
+
+	gitHead(newGitRepo(facts.Repo), facts)
+}
+
+// gitHead detects RevisionShort and Branch. Branch is detected even if
+// it's unborn (has no commits yet).
+func gitHead(repo *gitRepo, facts *Facts) {
+	branch, head, err := repo.head()
+	if err != nil && !errors.Is(err, errGitNotFound) {
+		log.Println("git HEAD:", err)
+		return
+	}
+	if facts.Lookup.RevisionShort && err == nil {
+		facts.Found.RevisionShort = head.String()[:7]
+	}
+	if facts.Lookup.Branch && strings.HasPrefix(branch, "refs/heads/") {
+		facts.Found.Branch = strings.TrimPrefix(branch, "refs/heads/")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type GitHeadSuite struct {
+	origDir     string
+	origEngines map[string]func(context.Context, *Facts)
+}
+
+var _ = Suite(&GitHeadSuite{})
+
+func (s *GitHeadSuite) SetUpSuite(c *C) {
+	var err error
+	s.origDir, err = os.Getwd()
+	c.Assert(err, IsNil)
+}
+
+func (s *GitHeadSuite) SetUpTest(c *C) {
+	s.origEngines = make(map[string]func(context.Context, *Facts), len(gitEngines))
+	for name, engine := range gitEngines {
+		s.origEngines[name] = engine
+	}
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+	git("init")
+	gitconfig()
+}
+
+func (s *GitHeadSuite) TearDownTest(c *C) {
+	gitEngines = s.origEngines
+}
+
+func (s *GitHeadSuite) TearDownSuite(c *C) {
+	c.Assert(os.Chdir(s.origDir), IsNil)
+}
+
+func (s *GitHeadSuite) VCSInfoGitHead() Attr {
+	facts := Facts{Repo: gitDetect(), Req: Request{Attr: AttrList{VCS: true, RevisionShort: true, Branch: true}}}
+	vcsInfoGitEngine(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *GitHeadSuite) TestGitHead(c *C) {
+	c.Check(s.VCSInfoGitHead(), DeepEquals, Attr{VCS: VCSGit, Branch: "master"}) // unborn
+
+	git("commit --allow-empty -m ROOT")
+	rev := gitRevParse(c, "HEAD").String()[:7]
+	c.Check(s.VCSInfoGitHead(), DeepEquals, Attr{VCS: VCSGit, Branch: "master", RevisionShort: rev}) // loose
+
+	git("pack-refs --all")
+	_, err := os.Stat(filepath.Join(".git", "refs", "heads", "master"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Check(s.VCSInfoGitHead(), DeepEquals, Attr{VCS: VCSGit, Branch: "master", RevisionShort: rev}) // packed
+
+	git("checkout -q --detach")
+	c.Check(s.VCSInfoGitHead(), DeepEquals, Attr{VCS: VCSGit, RevisionShort: rev}) // detached
+
+	git("checkout -q -b fix/a")
+	c.Check(s.VCSInfoGitHead(), DeepEquals, Attr{VCS: VCSGit, Branch: "fix/a", RevisionShort: rev}) // new branch
+}
+
+func (s *GitHeadSuite) TestGitHeadWorktree(c *C) {
+	git("commit --allow-empty -m ROOT")
+	git("pack-refs --all")
+	rev := gitRevParse(c, "HEAD").String()[:7]
+	worktree := filepath.Join(c.MkDir(), "wt")
+	c.Assert(worktree, Matches, "^\\S*$") // required to split git params
+	git("worktree add -q -b fix/a " + worktree)
+	c.Assert(os.Chdir(worktree), IsNil)
+
+	c.Check(s.VCSInfoGitHead(), DeepEquals, Attr{VCS: VCSGit, Branch: "fix/a", RevisionShort: rev}) // packed in common dir
+
+	git("commit --allow-empty -m msg")
+	c.Check(s.VCSInfoGitHead(), DeepEquals, Attr{VCS: VCSGit, Branch: "fix/a", RevisionShort: gitRevParse(c, "HEAD").String()[:7]}) // loose in common dir
+}
+
+func (s *GitHeadSuite) TestGitHeadFastPath(c *C) {
+	var used []AttrList
+	gitEngines["test"] = func(_ context.Context, facts *Facts) {
+		used = append(used, facts.Req.Attr)
+	}
+
+	facts := Facts{Repo: gitDetect(), Req: Request{Attr: AttrList{VCS: true, Branch: true}, GitEngine: "test"}}
+	vcsInfoGitEngine(context.Background(), &facts)
+	c.Check(used, HasLen, 0)
+	c.Check(facts.Lookup, Equals, AttrList{VCS: true, Branch: true})
+	c.Check(facts.Result(), DeepEquals, Attr{VCS: VCSGit, Branch: "master"})
+
+	facts = Facts{Repo: gitDetect(), Req: Request{Attr: AttrList{VCS: true, Branch: true, Tag: true}, GitEngine: "test"}}
+	vcsInfoGitEngine(context.Background(), &facts)
+	c.Check(used, DeepEquals, []AttrList{{VCS: true, Branch: true, Tag: true}})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Hybrid git engine: each group of facts is detected by preferred git
+// engine (see gitEngines), engines are running in parallel. Routes may
+// be changed by Request.GitRoutes.
+
+// gitRoute is a group of facts which may be routed to some git engine.
+type gitRoute struct {
+	name  string
+	attrs AttrList
+	fast  bool // detected before starting goroutines
+}
+
+var gitRoutes = []gitRoute{
+	{name: "head", attrs: AttrList{RevisionShort: true, Branch: true}, fast: true},
+	{name: "state", attrs: AttrList{State: true}, fast: true},
+	{name: "tag", attrs: AttrList{Tag: true}},
+	{name: "remote", attrs: AttrList{HasRemote: true, CommitsAheadRemote: true, CommitsBehindRemote: true}},
+	{name: "stash", attrs: AttrList{HasStashedCommits: true, StashedCommits: true}},
+	{name: "status", attrs: statusAttrs.Without(AttrList{HasUntrackedFiles: true})},
+	{name: "untracked", attrs: AttrList{HasUntrackedFiles: true}},
+}
+
+// defaultGitRoutes are used for routes not set in Request.GitRoutes.
+// Engines which aren't available are replaced by defaultGitEngine.
+// Untracked files are detected by "exec" engine if core.untrackedCache
+// is enabled.
+var defaultGitRoutes = GitRoutes{
+	"head":  "go",      // just a few files to read, no initialization
+	"state": "go",      // same
+	"stash": "libgit2", // libgit2 is faster than `git stash list`
+}
+
+const gitHybridEngine = "hybrid"
+
+func init() {
+	gitEngines[gitHybridEngine] = VCSInfoGitHybrid // avoid initialization loop
+}
+
+// GitRoutes contains git engine names by route names. It implements
+// flag.Value as comma-separated list of route=engine pairs.
+type GitRoutes map[string]string
+
+func (r *GitRoutes) String() string {
+	var pairs []string
+	for route, engine := range *r {
+		pairs = append(pairs, route+"="+engine)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set replaces all routes.
+func (r *GitRoutes) Set(s string) error {
+	routes := make(GitRoutes)
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.IndexByte(pair, '=')
+		if i == -1 {
+			return fmt.Errorf("bad git route %q: want route=engine", pair)
+		}
+		routes[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	if err := routes.validate(); err != nil {
+		return err
+	}
+	*r = routes
+	return nil
+}
+
+func (r GitRoutes) validate() error {
+	for route, engine := range r {
+		known := false
+		for i := range gitRoutes {
+			known = known || gitRoutes[i].name == route
+		}
+		switch {
+		case !known:
+			return fmt.Errorf("unknown git route %q", route)
+		case gitEngines[engine] == nil, engine == gitHybridEngine:
+			return fmt.Errorf("unknown git engine %q for route %q", engine, route)
+		}
+	}
+	return nil
+}
+
+// VCSInfoGitHybrid returns git facts for current repo using different
+// git engines for different groups of facts.
+func VCSInfoGitHybrid(ctx context.Context, facts *Facts) {
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasUnmergedFiles = l.HasUnmergedFiles || l.UnmergedFiles
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.HasStashedCommits = l.HasStashedCommits || l.StashedCommits
+	l.HasRemote = l.HasRemote || l.CommitsAheadRemote || l.CommitsBehindRemote
+	l.VCS = true
+
+	if facts.Repo.VCS != VCSGit {
+		return
+	}
+	facts.Found.VCS = VCSGit
+
+	routes := gitHybridRoutes(facts)
+	if routes["status"] != routes["untracked"] {
+		// Status engine won't scan for untracked files.
+		l.HasUntrackedFiles = l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked)
+	}
+
+	// Routes to same engine are detected by single engine call.
+	var fast, groups gitHybridGroups
+	var used []string
+	for _, route := range gitRoutes {
+		engine := routes[route.name]
+		switch {
+		case route.name == "untracked" && engine == routes["status"]:
+			continue // detected with status
+		case route.name == "status" && engine == routes["untracked"]:
+			route.name, route.attrs = "status+untracked", statusAttrs
+		}
+		if *l == l.Without(route.attrs) {
+			continue
+		}
+		used = append(used, route.name+"="+engine)
+		list := &groups
+		if route.fast {
+			list = &fast
+		}
+		list.add(engine, route.attrs)
+	}
+	log.Println("git routes:", strings.Join(used, " "))
+
+	for _, group := range fast.factGroups() {
+		groupFacts := *facts
+		group.detect(ctx, &groupFacts)
+		facts.Found.merge(groupFacts.Found, group.attrs)
+		facts.Lookup.merge(groupFacts.Lookup, group.attrs)
+		facts.TimedOut.merge(groupFacts.TimedOut, group.attrs)
+		facts.Approx.merge(groupFacts.Approx, group.attrs)
+	}
+	detectGroups(ctx, facts, groups.factGroups())
+}
+
+// gitHybridGroups contains attrs to detect by git engine names, in
+// order of adding.
+type gitHybridGroups struct {
+	engines []string
+	attrs   []AttrList
+}
+
+func (g *gitHybridGroups) add(engine string, attrs AttrList) {
+	for i := range g.engines {
+		if g.engines[i] == engine {
+			g.attrs[i].merge(attrs, attrs)
+			return
+		}
+	}
+	g.engines = append(g.engines, engine)
+	g.attrs = append(g.attrs, attrs)
+}
+
+func (g *gitHybridGroups) factGroups() []factGroup {
+	groups := make([]factGroup, len(g.engines))
+	for i := range groups {
+		groups[i] = factGroup{attrs: g.attrs[i], detect: withGitEngine(g.engines[i], g.attrs[i])}
+	}
+	return groups
+}
+
+// gitHybridRoutes returns engine for each route.
+func gitHybridRoutes(facts *Facts) GitRoutes {
+	routes := make(GitRoutes)
+	for route, engine := range defaultGitRoutes {
+		routes[route] = engine
+	}
+	if _, ok := facts.Req.GitRoutes["untracked"]; !ok && gitEngines["exec"] != nil && gitUntrackedCache(facts.Repo) {
+		routes["untracked"] = "exec"
+	}
+	for route, engine := range facts.Req.GitRoutes {
+		routes[route] = engine
+	}
+	for _, route := range gitRoutes {
+		if engine := routes[route.name]; gitEngines[engine] == nil || engine == gitHybridEngine {
+			routes[route.name] = defaultGitEngine
+		}
+	}
+	return routes
+}
+
+// gitUntrackedCache returns true if git config enables untracked cache.
+// Only config is read, without opening repo objects.
+func gitUntrackedCache(repo Repo) bool {
+	config := loadGitConfig(filepath.Join(newGitRepo(repo).commonDir, "config"))
+	untrackedCache := config.bool("feature.manyfiles", false)
+	return config.bool("core.untrackedcache", untrackedCache)
+}
+
+// withGitEngine returns detect func which use git engine to detect
+// looked up facts within attrs.
+func withGitEngine(engine string, attrs AttrList) func(context.Context, *Facts) {
+	return func(ctx context.Context, facts *Facts) {
+		sub := Facts{Repo: facts.Repo, Req: facts.Req}
+		sub.Req.Attr = facts.Lookup.Without(facts.Lookup.Without(attrs))
+		sub.Req.DirtyIfUntracked = sub.Req.DirtyIfUntracked && attrs.HasUntrackedFiles
+		gitEngines[engine](ctx, &sub)
+		facts.Found, facts.Lookup, facts.TimedOut = sub.Found, sub.Lookup, sub.TimedOut
+		facts.Approx = sub.Approx
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+// GitHybridSuite runs GitSuite tests using hybrid engine with default
+// routes.
+type GitHybridSuite struct{ GitSuite }
+
+var _ = Suite(&GitHybridSuite{GitSuite{engine: VCSInfoGitHybrid}})
+
+func (s *GitHybridSuite) TestGitRoutesSet(c *C) {
+	var routes GitRoutes
+	c.Check(routes.Set("head=exec, status=go"), IsNil)
+	c.Check(routes, DeepEquals, GitRoutes{"head": "exec", "status": "go"})
+	c.Check(routes.String(), Equals, "head=exec,status=go")
+	c.Check(routes.Set("tag=exec"), IsNil)
+	c.Check(routes, DeepEquals, GitRoutes{"tag": "exec"})
+
+	c.Check(routes.Set("tag"), ErrorMatches, `bad git route "tag": want route=engine`)
+	c.Check(routes.Set("nope=go"), ErrorMatches, `unknown git route "nope"`)
+	c.Check(routes.Set("tag=nope"), ErrorMatches, `unknown git engine "nope" for route "tag"`)
+	c.Check(routes.Set("tag=hybrid"), ErrorMatches, `unknown git engine "hybrid" for route "tag"`)
+	c.Check(routes, DeepEquals, GitRoutes{"tag": "exec"})
+}
+
+func (s *GitHybridSuite) TestGitRoutesDefault(c *C) {
+	facts := Facts{Repo: gitDetect()}
+	routes := gitHybridRoutes(&facts)
+	c.Check(routes["head"], Equals, "go")
+	c.Check(routes["untracked"], Equals, defaultGitEngine)
+	if gitEngines["libgit2"] == nil {
+		c.Check(routes["stash"], Equals, defaultGitEngine)
+	}
+
+	git("config feature.manyFiles true")
+	routes = gitHybridRoutes(&facts)
+	c.Check(routes["untracked"], Equals, "exec")
+
+	git("config core.untrackedCache false")
+	routes = gitHybridRoutes(&facts)
+	c.Check(routes["untracked"], Equals, defaultGitEngine)
+
+	git("config core.untrackedCache true")
+	routes = gitHybridRoutes(&facts)
+	c.Check(routes["untracked"], Equals, "exec")
+
+	facts.Req.GitRoutes = GitRoutes{"untracked": "go", "head": "exec"}
+	routes = gitHybridRoutes(&facts)
+	c.Check(routes["untracked"], Equals, "go")
+	c.Check(routes["head"], Equals, "exec")
+}
+
+func (s *GitHybridSuite) TestGitRoutesMixed(c *C) {
+	c.Assert(ioutil.WriteFile("a.txt", []byte("a"), 0644), IsNil)
+	git("add a.txt")
+	git("commit -m msg")
+	git("tag v1.0.0 -m msg")
+	c.Assert(ioutil.WriteFile("a.txt", []byte("b"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile("b.txt", []byte("b"), 0644), IsNil)
+
+	s.want.Branch = "master"
+	s.want.Tag = "v1.0.0"
+	s.want.IsDirty = true
+	s.want.HasModifiedFiles = true
+	s.want.ModifiedFiles = 1
+	s.want.HasUntrackedFiles = true
+	for _, routes := range []GitRoutes{
+		{"head": "exec", "state": "exec", "tag": "exec", "remote": "exec", "stash": "exec", "status": "go", "untracked": "exec"},
+		{"head": "go", "state": "go", "tag": "go", "remote": "go", "stash": "go", "status": "exec", "untracked": "go"},
+		{"head": "go", "state": "go", "tag": "go", "remote": "go", "stash": "go", "status": "go", "untracked": "go"},
+	} {
+		s.req.GitRoutes = routes
+		c.Check(s.VCSInfoGit(), DeepEquals, s.want, Commentf("%v", routes))
+	}
+
+	s.req.Attr.HasUntrackedFiles = false
+	s.want.HasUntrackedFiles = false
+	c.Assert(ioutil.WriteFile("a.txt", []byte("a"), 0644), IsNil)
+	s.want.HasModifiedFiles = false
+	s.want.ModifiedFiles = 0
+	s.req.GitRoutes = GitRoutes{"tag": "go", "status": "go", "untracked": "exec"}
+	c.Check(s.VCSInfoGit(), DeepEquals, s.want) // dirty by untracked b.txt
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Ignore rules: .gitignore files, info/exclude and core.excludesFile,
+// see gitignore(5).
+
+type gitIgnorePattern struct {
+	segments []string // pattern split by "/"
+	base     string   // dir of .gitignore, relative to workdir
+	negate   bool
+	dirOnly  bool
+	anchored bool // match full path (relative to base) instead of name
+}
+
+// gitIgnore checks paths relative to workdir. Files in workdir are
+// loaded on demand, so it's not safe to use in multiple threads.
+type gitIgnore struct {
+	workDir string
+	dirs    map[string][]gitIgnorePattern // .gitignore by dir
+	global  [][]gitIgnorePattern          // in order of precedence
+}
+
+func newGitIgnore(r *gitRepo) *gitIgnore {
+	ig := &gitIgnore{
+		workDir: r.workDir,
+		dirs:    make(map[string][]gitIgnorePattern),
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(r.commonDir, "info", "exclude")); err == nil {
+		ig.global = append(ig.global, parseGitIgnore(buf, ""))
+	}
+	excludesFile, ok := r.config.get("core.excludesfile")
+	if !ok {
+		excludesFile = filepath.Join(gitXDGConfigHome(), "git", "ignore")
+	} else if strings.HasPrefix(excludesFile, "~/") {
+		excludesFile = filepath.Join(os.Getenv("HOME"), excludesFile[2:])
+	}
+	if buf, err := ioutil.ReadFile(excludesFile); err == nil {
+		ig.global = append(ig.global, parseGitIgnore(buf, ""))
+	}
+	return ig
+}
+
+// parseGitIgnore parses patterns from .gitignore in base dir.
+func parseGitIgnore(buf []byte, base string) []gitIgnorePattern {
+	var patterns []gitIgnorePattern
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p := gitIgnorePattern{base: base}
+		if line[0] == '!' {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		p.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		p.segments = strings.Split(strings.Replace(line, "[!", "[^", -1), "/")
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// match returns true if pattern matches relpath.
+func (p *gitIgnorePattern) match(relpath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		if !strings.HasPrefix(relpath, p.base+"/") {
+			return false
+		}
+		relpath = relpath[len(p.base)+1:]
+	}
+	if !p.anchored {
+		ok, _ := path.Match(p.segments[0], path.Base(relpath))
+		return ok
+	}
+	return gitWildmatch(p.segments, strings.Split(relpath, "/"))
+}
+
+// gitWildmatch matches path segments, "**" matches any amount of
+// segments (at least one at end of pattern).
+func gitWildmatch(pattern, name []string) bool {
+	for ; len(pattern) > 0; pattern, name = pattern[1:], name[1:] {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if gitWildmatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+	}
+	return len(name) == 0
+}
+
+// ignored returns true if relpath is ignored. Caller should not check
+// paths inside ignored dirs: they're ignored too.
+func (ig *gitIgnore) ignored(relpath string, isDir bool) bool {
+	for dir := path.Dir(relpath); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+		if match, ignored := gitIgnoreMatch(ig.load(dir), relpath, isDir); match {
+			return ignored
+		}
+		if dir == "" {
+			break
+		}
+	}
+	for _, patterns := range ig.global {
+		if match, ignored := gitIgnoreMatch(patterns, relpath, isDir); match {
+			return ignored
+		}
+	}
+	return false
+}
+
+// gitIgnoreMatch returns result of last matched pattern.
+func gitIgnoreMatch(patterns []gitIgnorePattern, relpath string, isDir bool) (match, ignored bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].match(relpath, isDir) {
+			return true, !patterns[i].negate
+		}
+	}
+	return false, false
+}
+
+func (ig *gitIgnore) load(dir string) []gitIgnorePattern {
+	patterns, ok := ig.dirs[dir]
+	if !ok {
+		buf, _ := ioutil.ReadFile(filepath.Join(ig.workDir, filepath.FromSlash(dir), ".gitignore"))
+		patterns = parseGitIgnore(buf, dir)
+		ig.dirs[dir] = patterns
+	}
+	return patterns
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type GitIgnoreSuite struct{}
+
+var _ = Suite(&GitIgnoreSuite{})
+
+func (s *GitIgnoreSuite) TestWildmatch(c *C) {
+	for _, v := range []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.o", "a.o", true},
+		{"*.o", "dir/a.o", true},
+		{"*.o", "a.c", false},
+		{"/a.o", "a.o", true},
+		{"/a.o", "dir/a.o", false},
+		{"dir/*.o", "dir/a.o", true},
+		{"dir/*.o", "dir/sub/a.o", false},
+		{"dir/*.o", "top/dir/a.o", false},
+		{"**/a.o", "a.o", true},
+		{"**/a.o", "x/y/a.o", true},
+		{"dir/**", "dir/x/y", true},
+		{"dir/**", "dir", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a?c", "abc", true},
+		{"a[!b]c", "abc", false},
+		{"a[!b]c", "axc", true},
+		{"a[a-c]c", "abc", true},
+		{`\#a`, "#a", true},
+	} {
+		patterns := parseGitIgnore([]byte(v.pattern), "")
+		c.Assert(patterns, HasLen, 1)
+		c.Check(patterns[0].match(v.path, false), Equals, v.want, Commentf("%s %s", v.pattern, v.path))
+	}
+}
+
+func (s *GitIgnoreSuite) TestParse(c *C) {
+	patterns := parseGitIgnore([]byte("# comment\n\n!keep\nbuild/ \ntrailing\\ \r\n"), "sub")
+	c.Check(patterns, DeepEquals, []gitIgnorePattern{
+		{segments: []string{"keep"}, base: "sub", negate: true},
+		{segments: []string{"build"}, base: "sub", dirOnly: true},
+		{segments: []string{`trailing\ `}, base: "sub"},
+	})
+}
+
+func (s *GitIgnoreSuite) TestIgnored(c *C) {
+	root := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(root, ".git", "info"), 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(root, "sub"), 0755), IsNil)
+	write := func(name, data string) {
+		c.Assert(ioutil.WriteFile(filepath.Join(root, name), []byte(data), 0644), IsNil)
+	}
+	write(".git/info/exclude", "*.tmp\n")
+	write(".gitignore", "*.log\n!keep.log\nbuild/\n")
+	write("sub/.gitignore", "!*.tmp\nlocal.log\n")
+
+	repo := &gitRepo{workDir: root, commonDir: filepath.Join(root, ".git"), config: gitConfig{
+		"core.excludesfile": {filepath.Join(root, "missing")},
+	}}
+	ig := newGitIgnore(repo)
+	for _, v := range []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a.txt", false, false},
+		{"a.log", false, true},
+		{"keep.log", false, false},
+		{"sub/keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build", true, true},
+		{"a.tmp", false, true},
+		{"sub/a.tmp", false, false},
+		{"sub/local.log", false, true},
+	} {
+		c.Check(ig.ignored(v.path, v.isDir), Equals, v.want, Commentf("%s", v.path))
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Index (dircache) file support, versions 2-4. Split index and sparse
+// index aren't supported.
+
+// Index entry flags.
+const (
+	gitIndexAssumeValid  = 0x8000
+	gitIndexExtended     = 0x4000
+	gitIndexStageMask    = 0x3000
+	gitIndexStageShift   = 12
+	gitIndexSkipWorktree = 0x4000 // extended flags
+)
+
+type gitIndexEntry struct {
+	path      string
+	mode      uint32
+	id        gitOid
+	size      uint32
+	mtime     int64 // nanoseconds
+	ino       uint32
+	stage     int
+	skipCheck bool // assume-valid or skip-worktree
+}
+
+type gitIndex struct {
+	entries   []gitIndexEntry
+	mtime     time.Time         // of index file, entries with same or later mtime are racy
+	cacheTree map[string]gitOid // valid trees by dir path ("" for root)
+}
+
+// readGitIndex reads index file. Missing index is empty.
+func readGitIndex(path string) (*gitIndex, error) {
+	idx := &gitIndex{cacheTree: make(map[string]gitOid)}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	idx.mtime = fi.ModTime()
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = idx.parse(buf); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return idx, nil
+}
+
+func (idx *gitIndex) parse(buf []byte) error {
+	const idSize = len(gitOid{})
+	if len(buf) < 12+idSize || string(buf[:4]) != "DIRC" {
+		return fmt.Errorf("bad index")
+	}
+	version := binary.BigEndian.Uint32(buf[4:])
+	if version < 2 || version > 4 {
+		return fmt.Errorf("unsupported index version %d", version)
+	}
+	n := int(binary.BigEndian.Uint32(buf[8:]))
+	data := buf[12 : len(buf)-idSize] // without checksum
+	errShort := fmt.Errorf("index too short")
+
+	idx.entries = make([]gitIndexEntry, 0, n)
+	var prevPath []byte
+	for i := 0; i < n; i++ {
+		const fixedSize = 40 + idSize + 2
+		if len(data) < fixedSize {
+			return errShort
+		}
+		e := gitIndexEntry{
+			mtime: int64(binary.BigEndian.Uint32(data[8:]))*int64(time.Second) +
+				int64(binary.BigEndian.Uint32(data[12:])),
+			ino:  binary.BigEndian.Uint32(data[20:]),
+			mode: binary.BigEndian.Uint32(data[24:]),
+			size: binary.BigEndian.Uint32(data[36:]),
+		}
+		copy(e.id[:], data[40:])
+		flags := binary.BigEndian.Uint16(data[40+idSize:])
+		e.stage = int(flags&gitIndexStageMask) >> gitIndexStageShift
+		e.skipCheck = flags&gitIndexAssumeValid != 0
+		entrySize := fixedSize
+		if flags&gitIndexExtended != 0 {
+			if len(data) < fixedSize+2 {
+				return errShort
+			}
+			extFlags := binary.BigEndian.Uint16(data[fixedSize:])
+			e.skipCheck = e.skipCheck || extFlags&gitIndexSkipWorktree != 0
+			entrySize += 2
+		}
+		data = data[entrySize:]
+
+		if version == 4 { // path prefix compression, no padding
+			strip, m := binary.Uvarint(data)
+			if m <= 0 || int(strip) > len(prevPath) {
+				return fmt.Errorf("bad index entry %d", i)
+			}
+			data = data[m:]
+			end := bytes.IndexByte(data, 0)
+			if end == -1 {
+				return errShort
+			}
+			path := append(prevPath[:len(prevPath)-int(strip):len(prevPath)-int(strip)], data[:end]...)
+			e.path, prevPath = string(path), path
+			data = data[end+1:]
+		} else {
+			end := bytes.IndexByte(data, 0)
+			if end == -1 {
+				return errShort
+			}
+			e.path = string(data[:end])
+			padded := (entrySize + end + 8) &^ 7
+			if len(data) < padded-entrySize {
+				return errShort
+			}
+			data = data[padded-entrySize:]
+		}
+		if e.mode == gitModeTree {
+			return fmt.Errorf("sparse index is not supported")
+		}
+		idx.entries = append(idx.entries, e)
+	}
+
+	for len(data) >= 8 {
+		signature := string(data[:4])
+		size := binary.BigEndian.Uint32(data[4:])
+		if uint64(len(data)-8) < uint64(size) {
+			return errShort
+		}
+		ext := data[8 : 8+size]
+		data = data[8+size:]
+		switch signature {
+		case "TREE":
+			idx.parseCacheTree(ext, "")
+		case "link":
+			return fmt.Errorf("split index is not supported")
+		case "sdir":
+			return fmt.Errorf("sparse index is not supported")
+		}
+	}
+	return nil
+}
+
+// parseCacheTree parses cache tree entry for dir with given parent
+// path and returns rest of buf.
+func (idx *gitIndex) parseCacheTree(buf []byte, parent string) []byte {
+	nul := bytes.IndexByte(buf, 0)
+	eol := bytes.IndexByte(buf, '\n')
+	if nul == -1 || eol < nul {
+		return nil
+	}
+	path := string(buf[:nul])
+	if parent != "" {
+		path = parent + "/" + path
+	}
+	var count, subtrees int
+	if _, err := fmt.Sscanf(string(buf[nul+1:eol]), "%d %d", &count, &subtrees); err != nil {
+		return nil
+	}
+	buf = buf[eol+1:]
+	if count >= 0 { // invalidated entries have -1
+		var id gitOid
+		if len(buf) < len(id) {
+			return nil
+		}
+		copy(id[:], buf)
+		idx.cacheTree[path] = id
+		buf = buf[len(id):]
+	}
+	for i := 0; i < subtrees && buf != nil; i++ {
+		buf = idx.parseCacheTree(buf, path)
+	}
+	return buf
+}
+
+// isRacy returns true if entry's stat data can't be trusted because file
+// may be modified after writing index in a same time unit.
+func (idx *gitIndex) isRacy(e *gitIndexEntry) bool {
+	return e.mtime >= idx.mtime.UnixNano()
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Pack files support: version 2 .idx, OFS_DELTA and REF_DELTA objects.
+
+// Pack object types in addition to gitObjectType.
+const (
+	gitObjOfsDelta = 6
+	gitObjRefDelta = 7
+)
+
+const (
+	gitPackCacheSize   = 256      // delta bases
+	gitPackMaxCacheObj = 16 << 20 // bytes
+)
+
+var errGitBadPack = errors.New("bad pack")
+
+type gitPack struct {
+	path    string
+	f       *os.File
+	ids     []byte // sorted ids
+	offsets []byte // 32-bit offsets
+	large   []byte // 64-bit offsets
+	fanout  [256]uint32
+	cache   map[int64]gitPackObject
+}
+
+type gitPackObject struct {
+	typ  gitObjectType
+	data []byte
+}
+
+// openGitPacks opens all packs in objDirs, invalid packs are skipped.
+func openGitPacks(objDirs []string) []*gitPack {
+	packs := []*gitPack{}
+	for _, dir := range objDirs {
+		names, _ := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+		for _, name := range names {
+			pack, err := openGitPack(name)
+			if err != nil {
+				log.Println("openGitPack:", err)
+				continue
+			}
+			packs = append(packs, pack)
+		}
+	}
+	return packs
+}
+
+func openGitPack(idxPath string) (*gitPack, error) {
+	idx, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	const headerSize = 8 + 256*4
+	if len(idx) < headerSize || !bytes.Equal(idx[:8], []byte("\377tOc\x00\x00\x00\x02")) {
+		return nil, fmt.Errorf("%s: %w: only version 2 is supported", idxPath, errGitBadPack)
+	}
+	p := &gitPack{
+		path:  strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		cache: make(map[int64]gitPackObject),
+	}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(idx[8+i*4:])
+	}
+	n := int(p.fanout[255])
+	const idSize = len(gitOid{})
+	if len(idx) < headerSize+n*(idSize+4+4) {
+		return nil, fmt.Errorf("%s: %w: too short", idxPath, errGitBadPack)
+	}
+	idx = idx[headerSize:]
+	p.ids, idx = idx[:n*idSize], idx[n*idSize:]
+	idx = idx[n*4:] // CRC32
+	p.offsets, p.large = idx[:n*4], idx[n*4:]
+
+	p.f, err = os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// find returns offset of object in pack.
+func (p *gitPack) find(id gitOid) (int64, bool) {
+	const idSize = len(gitOid{})
+	lo, hi := 0, int(p.fanout[id[0]])
+	if id[0] > 0 {
+		lo = int(p.fanout[id[0]-1])
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.ids[(lo+i)*idSize:(lo+i+1)*idSize], id[:]) >= 0
+	})
+	if i == hi || !bytes.Equal(p.ids[i*idSize:(i+1)*idSize], id[:]) {
+		return 0, false
+	}
+	offset := int64(binary.BigEndian.Uint32(p.offsets[i*4:]))
+	if offset&0x80000000 != 0 {
+		i = int(offset & 0x7fffffff)
+		if len(p.large) < (i+1)*8 {
+			return 0, false
+		}
+		offset = int64(binary.BigEndian.Uint64(p.large[i*8:]))
+	}
+	return offset, true
+}
+
+// object returns type and content of object at offset, with deltas
+// applied.
+func (p *gitPack) object(r *gitRepo, offset int64) (gitObjectType, []byte, error) {
+	if obj, ok := p.cache[offset]; ok {
+		return obj.typ, obj.data, nil
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(p.f, offset, 1<<62))
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+	typ := gitObjectType(b >> 4 & 7)
+	size := uint64(b & 0x0f)
+	for shift := uint(4); b&0x80 != 0; shift += 7 {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", p.path, err)
+		}
+		size |= uint64(b&0x7f) << shift
+	}
+
+	var baseType gitObjectType
+	var base []byte
+	switch typ {
+	case gitObjCommit, gitObjTree, gitObjBlob, gitObjTag:
+	case gitObjOfsDelta:
+		b, err = br.ReadByte()
+		rel := int64(b & 0x7f)
+		for err == nil && b&0x80 != 0 {
+			b, err = br.ReadByte()
+			rel = (rel+1)<<7 | int64(b&0x7f)
+		}
+		if err == nil {
+			baseType, base, err = p.object(r, offset-rel)
+		}
+	case gitObjRefDelta:
+		var baseID gitOid
+		if _, err = io.ReadFull(br, baseID[:]); err == nil {
+			baseType, base, err = r.object(baseID)
+		}
+	default:
+		err = fmt.Errorf("%w: object type %d at %d", errGitBadPack, typ, offset)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(zr, data); err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+	if base != nil {
+		typ = baseType
+		if data, err = applyGitDelta(base, data); err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", p.path, err)
+		}
+	}
+
+	if len(data) <= gitPackMaxCacheObj && typ != gitObjBlob { // trees and commits are likely delta bases
+		if len(p.cache) >= gitPackCacheSize {
+			p.cache = make(map[int64]gitPackObject)
+		}
+		p.cache[offset] = gitPackObject{typ: typ, data: data}
+	}
+	return typ, data, nil
+}
+
+// applyGitDelta returns result of applying delta to base.
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	errDelta := fmt.Errorf("%w: broken delta", errGitBadPack)
+	varint := func() uint64 {
+		var v uint64
+		for shift := uint(0); len(delta) > 0; shift += 7 {
+			b := delta[0]
+			delta = delta[1:]
+			v |= uint64(b&0x7f) << shift
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		return v
+	}
+	if varint() != uint64(len(base)) {
+		return nil, errDelta
+	}
+	result := make([]byte, 0, varint())
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0: // copy from base
+			var offset, size uint64
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errDelta
+				}
+				if i < 4 {
+					offset |= uint64(delta[0]) << (8 * i)
+				} else {
+					size |= uint64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, errDelta
+			}
+			result = append(result, base[offset:offset+size]...)
+		case op != 0: // insert
+			if int(op) > len(delta) {
+				return nil, errDelta
+			}
+			result = append(result, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errDelta
+		}
+	}
+	if len(result) != cap(result) {
+		return nil, errDelta
+	}
+	return result, nil
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Minimal read-only git repository reader used by pure Go engine
+// (see gitgo.go): config, refs, loose and packed objects.
+//
+// Not supported: SHA-256 repos, reftable, split and sparse index,
+// config includes.
+
+// gitOid is a SHA-1 object id.
+type gitOid [20]byte
+
+func (id gitOid) String() string { return hex.EncodeToString(id[:]) }
+
+func (id gitOid) isZero() bool { return id == gitOid{} }
+
+func parseGitOid(s string) (id gitOid, ok bool) {
+	if len(s) != 2*len(id) {
+		return id, false
+	}
+	_, err := hex.Decode(id[:], []byte(s))
+	return id, err == nil
+}
+
+type gitObjectType int
+
+// Object types, values are same as in pack files.
+const (
+	gitObjCommit gitObjectType = 1
+	gitObjTree   gitObjectType = 2
+	gitObjBlob   gitObjectType = 3
+	gitObjTag    gitObjectType = 4
+)
+
+var gitObjectTypes = map[string]gitObjectType{
+	"commit": gitObjCommit,
+	"tree":   gitObjTree,
+	"blob":   gitObjBlob,
+	"tag":    gitObjTag,
+}
+
+// Modes of tree and index entries.
+const (
+	gitModeTree    = 0040000
+	gitModeFile    = 0100644
+	gitModeExec    = 0100755
+	gitModeSymlink = 0120000
+	gitModeGitlink = 0160000
+	gitModeType    = 0170000
+)
+
+var (
+	errGitNotFound    = errors.New("not found")
+	errGitUnsupported = errors.New("unsupported repository format")
+)
+
+type gitRepo struct {
+	gitDir    string
+	commonDir string // differs from gitDir for worktrees
+	workDir   string
+	config    gitConfig
+	objDirs   []string
+	packs     []*gitPack // nil until first packed object lookup
+	packed    map[string]gitOid
+}
+
+// newGitRepo returns repo which can be used only to read refs.
+func newGitRepo(repo Repo) *gitRepo {
+	r := &gitRepo{
+		gitDir:    repo.GitDir,
+		commonDir: repo.GitDir,
+		workDir:   repo.Root,
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(r.gitDir, "commondir")); err == nil {
+		r.commonDir = strings.TrimSpace(string(buf))
+		if !filepath.IsAbs(r.commonDir) {
+			r.commonDir = filepath.Join(r.gitDir, r.commonDir)
+		}
+	}
+	return r
+}
+
+// openGitRepo opens already detected repo.
+// Returned object is not safe to use in multiple threads.
+func openGitRepo(repo Repo) (*gitRepo, error) {
+	r := newGitRepo(repo)
+	if _, err := os.Stat(filepath.Join(r.gitDir, "HEAD")); err != nil {
+		return nil, err
+	}
+
+	r.config = loadGitConfig(filepath.Join(r.commonDir, "config"))
+	if format, _ := r.config.get("extensions.objectformat"); format != "" && format != "sha1" {
+		return nil, fmt.Errorf("%w: objectformat %s", errGitUnsupported, format)
+	}
+	if storage, _ := r.config.get("extensions.refstorage"); storage != "" && storage != "files" {
+		return nil, fmt.Errorf("%w: refstorage %s", errGitUnsupported, storage)
+	}
+
+	r.objDirs = []string{filepath.Join(r.commonDir, "objects")}
+	if buf, err := ioutil.ReadFile(filepath.Join(r.objDirs[0], "info", "alternates")); err == nil {
+		for _, dir := range strings.Split(string(buf), "\n") {
+			switch {
+			case dir == "", dir[0] == '#':
+			case filepath.IsAbs(dir):
+				r.objDirs = append(r.objDirs, dir)
+			default:
+				r.objDirs = append(r.objDirs, filepath.Join(r.objDirs[0], dir))
+			}
+		}
+	}
+	return r, nil
+}
+
+// close closes opened pack files.
+func (r *gitRepo) close() {
+	for _, pack := range r.packs {
+		pack.f.Close()
+	}
+}
+
+// gitConfig contains values by "section.subsection.key" names, with
+// lowercased section and key.
+type gitConfig map[string][]string
+
+// loadGitConfig loads system, global and repo config files.
+// Missing or invalid files are ignored.
+func loadGitConfig(repoConfig string) gitConfig {
+	config := make(gitConfig)
+	var paths []string
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		paths = append(paths, "/etc/gitconfig")
+	}
+	xdg := gitXDGConfigHome()
+	if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+		paths = append(paths, global)
+	} else {
+		paths = append(paths, filepath.Join(xdg, "git", "config"), filepath.Join(os.Getenv("HOME"), ".gitconfig"))
+	}
+	paths = append(paths, repoConfig)
+	for _, path := range paths {
+		if buf, err := ioutil.ReadFile(path); err == nil {
+			config.parse(buf)
+		}
+	}
+	return config
+}
+
+func gitXDGConfigHome() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config")
+}
+
+// parse adds values from git config file.
+func (c gitConfig) parse(buf []byte) {
+	section := ""
+	for _, line := range gitConfigLines(buf) {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", line[0] == '#', line[0] == ';':
+		case line[0] == '[':
+			end := strings.LastIndexByte(line, ']')
+			if end == -1 {
+				return // broken file
+			}
+			section = gitConfigSection(line[1:end])
+			if rest := strings.TrimSpace(line[end+1:]); rest != "" { // [section] key = value
+				c.add(section, rest)
+			}
+		default:
+			c.add(section, line)
+		}
+	}
+}
+
+// gitConfigLines splits buf into lines, joining continued ones.
+func gitConfigLines(buf []byte) []string {
+	var lines []string
+	var cont string
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") {
+			cont += line[:len(line)-1]
+			continue
+		}
+		lines = append(lines, cont+line)
+		cont = ""
+	}
+	return lines
+}
+
+func gitConfigSection(s string) string {
+	if i := strings.IndexByte(s, '"'); i != -1 { // [section "subsection"]
+		sub := strings.TrimSuffix(s[i+1:], `"`)
+		sub = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(sub)
+		return strings.ToLower(strings.TrimSpace(s[:i])) + "." + sub
+	}
+	if i := strings.IndexByte(s, '.'); i != -1 { // deprecated [section.subsection]
+		return strings.ToLower(s[:i]) + "." + strings.ToLower(s[i+1:])
+	}
+	return strings.ToLower(s)
+}
+
+func (c gitConfig) add(section, line string) {
+	key, value := line, "true"
+	if i := strings.IndexByte(line, '='); i != -1 {
+		key, value = strings.TrimSpace(line[:i]), gitConfigValue(line[i+1:])
+	} else if i := strings.IndexAny(line, "#;"); i != -1 {
+		key = strings.TrimSpace(line[:i])
+	}
+	name := section + "." + strings.ToLower(key)
+	c[name] = append(c[name], value)
+}
+
+// gitConfigValue unquotes value and removes comments.
+func gitConfigValue(s string) string {
+	var buf strings.Builder
+	quoted := false
+	s = strings.TrimSpace(s)
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"':
+			quoted = !quoted
+		case ch == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'b':
+				// Backspace isn't supported.
+			default:
+				buf.WriteByte(s[i])
+			}
+		case (ch == '#' || ch == ';') && !quoted:
+			return strings.TrimSpace(buf.String())
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
+}
+
+// get returns last value of key.
+func (c gitConfig) get(key string) (string, bool) {
+	values := c[key]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// bool returns boolean value of key or def if key is not set or invalid.
+func (c gitConfig) bool(key string, def bool) bool {
+	value, ok := c.get(key)
+	if !ok {
+		return def
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0", "":
+		return false
+	}
+	return def
+}
+
+// refDir returns dir which contains loose ref name.
+func (r *gitRepo) refDir(name string) string {
+	switch {
+	case !strings.HasPrefix(name, "refs/"),
+		strings.HasPrefix(name, "refs/bisect/"),
+		strings.HasPrefix(name, "refs/worktree/"),
+		strings.HasPrefix(name, "refs/rewritten/"):
+		return r.gitDir // per-worktree refs
+	}
+	return r.commonDir
+}
+
+// readRef returns either target of symbolic ref name or it's id.
+func (r *gitRepo) readRef(name string) (target string, id gitOid, err error) {
+	buf, err := ioutil.ReadFile(filepath.Join(r.refDir(name), filepath.FromSlash(name)))
+	if err == nil {
+		s := strings.TrimSpace(string(buf))
+		if strings.HasPrefix(s, "ref:") {
+			return strings.TrimSpace(s[4:]), id, nil
+		}
+		var ok bool
+		if id, ok = parseGitOid(s); !ok {
+			return "", id, fmt.Errorf("bad ref %s: %q", name, s)
+		}
+		return "", id, nil
+	} else if !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+		return "", id, err
+	}
+
+	if err = r.loadPackedRefs(); err != nil {
+		return "", id, err
+	}
+	id, ok := r.packed[name]
+	if !ok {
+		return "", id, errGitNotFound
+	}
+	return "", id, nil
+}
+
+// resolveRef returns name of ref (after following symbolic refs) and
+// it's id. Error errGitNotFound is returned with name of missing ref.
+func (r *gitRepo) resolveRef(name string) (string, gitOid, error) {
+	for i := 0; i < 5; i++ {
+		target, id, err := r.readRef(name)
+		if err != nil || target == "" {
+			return name, id, err
+		}
+		name = target
+	}
+	return name, gitOid{}, fmt.Errorf("too deep symbolic ref %s", name)
+}
+
+func (r *gitRepo) loadPackedRefs() error {
+	if r.packed != nil {
+		return nil
+	}
+	r.packed = make(map[string]gitOid)
+	f, err := os.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' { // ^ is peeled tag
+			continue
+		}
+		if i := strings.IndexByte(line, ' '); i != -1 {
+			if id, ok := parseGitOid(line[:i]); ok {
+				r.packed[line[i+1:]] = id
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// refs returns ids of non-symbolic refs with given prefix (like
+// "refs/tags/").
+func (r *gitRepo) refs(prefix string) (map[string]gitOid, error) {
+	if err := r.loadPackedRefs(); err != nil {
+		return nil, err
+	}
+	refs := make(map[string]gitOid)
+	for name, id := range r.packed {
+		if strings.HasPrefix(name, prefix) {
+			refs[name] = id
+		}
+	}
+	dir := r.refDir(prefix)
+	err := filepath.Walk(filepath.Join(dir, filepath.FromSlash(prefix)), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		name := filepath.ToSlash(path[len(dir)+1:])
+		if target, id, err := r.readRef(name); err == nil && target == "" {
+			refs[name] = id // overrides packed
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// head returns name of current branch ("" for detached HEAD) and id of
+// HEAD commit. Error errGitNotFound means unborn branch.
+func (r *gitRepo) head() (branch string, id gitOid, err error) {
+	name, id, err := r.resolveRef("HEAD")
+	if name != "HEAD" {
+		branch = name
+	}
+	return branch, id, err
+}
+
+// object returns type and content of object.
+func (r *gitRepo) object(id gitOid) (gitObjectType, []byte, error) {
+	typ, data, err := r.looseObject(id)
+	if err != errGitNotFound {
+		return typ, data, err
+	}
+	if r.packs == nil {
+		r.packs = openGitPacks(r.objDirs)
+	}
+	for _, pack := range r.packs {
+		if offset, ok := pack.find(id); ok {
+			return pack.object(r, offset)
+		}
+	}
+	return 0, nil, fmt.Errorf("object %s: %w", id, errGitNotFound)
+}
+
+func (r *gitRepo) looseObject(id gitOid) (gitObjectType, []byte, error) {
+	hexID := id.String()
+	for _, dir := range r.objDirs {
+		f, err := os.Open(filepath.Join(dir, hexID[:2], hexID[2:]))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, nil, err
+		}
+		defer f.Close()
+		zr, err := zlib.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return 0, nil, fmt.Errorf("object %s: %w", id, err)
+		}
+		buf, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return 0, nil, fmt.Errorf("object %s: %w", id, err)
+		}
+		i := bytes.IndexByte(buf, 0)
+		header := strings.Fields(string(buf[:i+1]))
+		if i == -1 || len(header) != 2 || gitObjectTypes[header[0]] == 0 {
+			return 0, nil, fmt.Errorf("object %s: bad header", id)
+		}
+		return gitObjectTypes[header[0]], buf[i+1:], nil
+	}
+	return 0, nil, errGitNotFound
+}
+
+// typedObject returns content of object with given type.
+func (r *gitRepo) typedObject(id gitOid, want gitObjectType) ([]byte, error) {
+	typ, data, err := r.object(id)
+	if err == nil && typ != want {
+		err = fmt.Errorf("object %s: unexpected type %d", id, typ)
+	}
+	return data, err
+}
+
+type gitCommit struct {
+	tree    gitOid
+	parents []gitOid
+	time    int64 // committer time
+}
+
+func (r *gitRepo) commit(id gitOid) (*gitCommit, error) {
+	data, err := r.typedObject(id, gitObjCommit)
+	if err != nil {
+		return nil, err
+	}
+	commit := &gitCommit{}
+	for _, line := range gitObjectHeaders(data) {
+		switch {
+		case strings.HasPrefix(line, "tree "):
+			commit.tree, _ = parseGitOid(line[5:])
+		case strings.HasPrefix(line, "parent "):
+			if parent, ok := parseGitOid(line[7:]); ok {
+				commit.parents = append(commit.parents, parent)
+			}
+		case strings.HasPrefix(line, "committer "):
+			commit.time = gitSignatureTime(line)
+		}
+	}
+	return commit, nil
+}
+
+type gitTagObject struct {
+	object gitOid
+	typ    gitObjectType
+	name   string
+	time   int64 // tagger time
+}
+
+func parseGitTag(data []byte) *gitTagObject {
+	tag := &gitTagObject{}
+	for _, line := range gitObjectHeaders(data) {
+		switch {
+		case strings.HasPrefix(line, "object "):
+			tag.object, _ = parseGitOid(line[7:])
+		case strings.HasPrefix(line, "type "):
+			tag.typ = gitObjectTypes[line[5:]]
+		case strings.HasPrefix(line, "tag "):
+			tag.name = line[4:]
+		case strings.HasPrefix(line, "tagger "):
+			tag.time = gitSignatureTime(line)
+		}
+	}
+	return tag
+}
+
+// gitObjectHeaders returns header lines of commit or tag.
+func gitObjectHeaders(data []byte) []string {
+	if i := bytes.Index(data, []byte("\n\n")); i != -1 {
+		data = data[:i]
+	}
+	return strings.Split(string(data), "\n")
+}
+
+// gitSignatureTime returns time from "name <email> time tz".
+func gitSignatureTime(line string) int64 {
+	i := strings.LastIndexByte(line, '>')
+	fields := strings.Fields(line[i+1:])
+	if i == -1 || len(fields) == 0 {
+		return 0
+	}
+	t, _ := strconv.ParseInt(fields[0], 10, 64)
+	return t
+}
+
+type gitTreeEntry struct {
+	name string
+	mode uint32
+	id   gitOid
+}
+
+func (r *gitRepo) tree(id gitOid) ([]gitTreeEntry, error) {
+	data, err := r.typedObject(id, gitObjTree)
+	if err != nil {
+		return nil, err
+	}
+	var entries []gitTreeEntry
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp == -1 || nul < sp || len(data) < nul+1+len(gitOid{}) {
+			return nil, fmt.Errorf("tree %s: bad entry", id)
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("tree %s: %w", id, err)
+		}
+		entry := gitTreeEntry{name: string(data[sp+1 : nul]), mode: uint32(mode)}
+		copy(entry.id[:], data[nul+1:])
+		entries = append(entries, entry)
+		data = data[nul+1+len(entry.id):]
+	}
+	return entries, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type GitRepoSuite struct {
+	origDir string
+}
+
+var _ = Suite(&GitRepoSuite{})
+
+func (s *GitRepoSuite) SetUpSuite(c *C) {
+	var err error
+	s.origDir, err = os.Getwd()
+	c.Assert(err, IsNil)
+}
+
+func (s *GitRepoSuite) SetUpTest(c *C) {
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+	git("init")
+	gitconfig()
+}
+
+func (s *GitRepoSuite) TearDownSuite(c *C) {
+	c.Assert(os.Chdir(s.origDir), IsNil)
+}
+
+func gitRevParse(c *C, rev string) gitOid {
+	out, err := exec.Command("git", "rev-parse", rev).Output()
+	c.Assert(err, IsNil)
+	id, ok := parseGitOid(strings.TrimSpace(string(out)))
+	c.Assert(ok, Equals, true)
+	return id
+}
+
+func (s *GitRepoSuite) TestConfig(c *C) {
+	config := make(gitConfig)
+	config.parse([]byte(`
+# comment
+[Core]
+	FileMode = false
+	bare
+[branch "Fix/A"]
+	remote = origin ; comment
+	merge = "refs/heads/fix/a # not a comment"
+[remote "origin"]
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[alias] x = "a\"b\\c\
+d"
+`))
+	c.Check(config.bool("core.filemode", true), Equals, false)
+	c.Check(config.bool("core.bare", false), Equals, true)
+	c.Check(config.bool("core.missing", true), Equals, true)
+	c.Check(config["branch.Fix/A.remote"], DeepEquals, []string{"origin"})
+	c.Check(config["branch.Fix/A.merge"], DeepEquals, []string{"refs/heads/fix/a # not a comment"})
+	c.Check(config["remote.origin.fetch"], HasLen, 2)
+	c.Check(config["alias.x"], DeepEquals, []string{`a"b\cd`})
+}
+
+func (s *GitRepoSuite) TestRefspecMap(c *C) {
+	for _, v := range []struct {
+		refspec, ref, want string
+		ok                 bool
+	}{
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/fix/a", "refs/remotes/origin/fix/a", true},
+		{"refs/heads/master:refs/remotes/origin/master", "refs/heads/master", "refs/remotes/origin/master", true},
+		{"refs/heads/master:refs/remotes/origin/master", "refs/heads/main", "", false},
+		{"refs/heads/*-x:refs/remotes/o/*", "refs/heads/a-x", "refs/remotes/o/a", true},
+		{"^refs/heads/tmp/*", "refs/heads/tmp/a", "", false},
+	} {
+		dst, ok := gitRefspecMap(v.refspec, v.ref)
+		c.Check(ok, Equals, v.ok, Commentf("%s", v.refspec))
+		if ok {
+			c.Check(dst, Equals, v.want)
+		}
+	}
+}
+
+func (s *GitRepoSuite) TestObjects(c *C) {
+	c.Assert(ioutil.WriteFile("a.txt", []byte(strings.Repeat("line\n", 100)), 0644), IsNil)
+	git("add a.txt")
+	git("commit -m msg1")
+	c.Assert(ioutil.WriteFile("a.txt", []byte(strings.Repeat("line\n", 101)), 0644), IsNil)
+	git("commit -am msg2")
+	git("tag v1.0.0 -m msg")
+
+	check := func() {
+		repo, err := openGitRepo(gitDetect())
+		c.Assert(err, IsNil)
+		defer repo.close()
+		branch, head, err := repo.head()
+		c.Assert(err, IsNil)
+		c.Check(branch, Equals, "refs/heads/master")
+		c.Check(head, Equals, gitRevParse(c, "HEAD"))
+		commit, err := repo.commit(head)
+		c.Assert(err, IsNil)
+		c.Check(commit.tree, Equals, gitRevParse(c, "HEAD^{tree}"))
+		c.Check(commit.parents, DeepEquals, []gitOid{gitRevParse(c, "HEAD^")})
+		tree, err := repo.tree(commit.tree)
+		c.Assert(err, IsNil)
+		c.Check(tree, DeepEquals, []gitTreeEntry{{name: "a.txt", mode: gitModeFile, id: gitRevParse(c, "HEAD:a.txt")}})
+		data, err := repo.typedObject(gitRevParse(c, "HEAD^:a.txt"), gitObjBlob)
+		c.Assert(err, IsNil)
+		c.Check(string(data), Equals, strings.Repeat("line\n", 100))
+		tags, err := repo.refs("refs/tags/")
+		c.Assert(err, IsNil)
+		c.Check(tags, DeepEquals, map[string]gitOid{"refs/tags/v1.0.0": gitRevParse(c, "v1.0.0")})
+	}
+	check() // loose
+
+	git("gc -q")
+	_, err := os.Stat(filepath.Join(".git", "packed-refs"))
+	c.Assert(err, IsNil)
+	check() // packed, with delta
+
+	idx, err := readGitIndex(filepath.Join(".git", "index"))
+	c.Assert(err, IsNil)
+	c.Check(idx.entries, HasLen, 1)
+}
+
+func (s *GitRepoSuite) TestAheadBehindSameTime(c *C) {
+	commit := func(date, msg string) {
+		c.Assert(os.Setenv("GIT_COMMITTER_DATE", date), IsNil)
+		c.Assert(os.Setenv("GIT_AUTHOR_DATE", date), IsNil)
+		git("commit --allow-empty -m " + msg)
+	}
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	defer os.Unsetenv("GIT_AUTHOR_DATE")
+	const t1, t2 = "2020-01-01T00:00:00Z", "2020-01-01T00:00:01Z"
+	commit(t1, "ROOT")
+	commit(t1, "msg1")
+	git("checkout -q -b upstream")
+	commit(t1, "msg2")
+	commit(t1, "msg3")
+	git("checkout -q master")
+	commit(t2, "msg4")
+	commit(t2, "msg5")
+	commit(t2, "msg6")
+
+	repo, err := openGitRepo(gitDetect())
+	c.Assert(err, IsNil)
+	defer repo.close()
+	ahead, behind, err := gitAheadBehind(context.Background(), repo, gitRevParse(c, "master"), gitRevParse(c, "upstream"))
+	c.Check(err, IsNil)
+	c.Check([]int{ahead, behind}, DeepEquals, []int{3, 2})
+
+	c.Assert(os.Setenv("GIT_COMMITTER_DATE", t2), IsNil)
+	git("merge -q --no-edit upstream")
+	ahead, behind, err = gitAheadBehind(context.Background(), repo, gitRevParse(c, "master"), gitRevParse(c, "upstream"))
+	c.Check(err, IsNil)
+	c.Check([]int{ahead, behind}, DeepEquals, []int{4, 0})
+}
+
+func (s *GitRepoSuite) TestIndex(c *C) {
+	c.Assert(os.MkdirAll("dir/sub", 0755), IsNil)
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/sub/d.txt"} {
+		c.Assert(ioutil.WriteFile(name, []byte(name), 0644), IsNil)
+	}
+	git("add .")
+	git("commit -m msg")
+	for _, version := range []string{"2", "3", "4"} {
+		git("update-index --index-version " + version)
+		idx, err := readGitIndex(filepath.Join(".git", "index"))
+		c.Assert(err, IsNil, Commentf("version %s", version))
+		var paths []string
+		for _, e := range idx.entries {
+			paths = append(paths, e.path)
+		}
+		c.Check(paths, DeepEquals, []string{"a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/sub/d.txt"})
+		c.Check(idx.entries[1].id, Equals, gitRevParse(c, "HEAD:dir/b.txt"))
+		c.Check(idx.entries[1].mode, Equals, uint32(gitModeFile))
+		c.Check(idx.entries[1].size, Equals, uint32(len("dir/b.txt")))
+	}
+	idx, err := readGitIndex(filepath.Join(".git", "index"))
+	c.Assert(err, IsNil)
+	c.Check(idx.cacheTree[""], Equals, gitRevParse(c, "HEAD^{tree}"))
+	c.Check(idx.cacheTree["dir/sub"], Equals, gitRevParse(c, "HEAD:dir/sub"))
+
+	idx, err = readGitIndex(filepath.Join(".git", "missing"))
+	c.Assert(err, IsNil)
+	c.Check(idx.entries, HasLen, 0)
+}
+
+func (s *GitRepoSuite) TestPackedStatus(c *C) {
+	c.Assert(ioutil.WriteFile("a.txt", []byte("a"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(".gitignore", []byte("*.log\n"), 0644), IsNil)
+	git("add .")
+	git("commit -m msg1")
+	git("tag v1.0.0 -m msg")
+	git("commit --allow-empty -m msg2")
+	git("gc -q")
+	c.Assert(ioutil.WriteFile("a.txt", []byte("b"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile("debug.log", nil, 0644), IsNil)
+
+	facts := Facts{Repo: gitDetect(), Req: Request{Attr: AttrList{
+		Branch:            true,
+		Tag:               true,
+		ModifiedFiles:     true,
+		HasUntrackedFiles: true,
+	}}}
+	VCSInfoGitGo(context.Background(), &facts)
+	facts.QA()
+	c.Check(facts.Result(), DeepEquals, Attr{Branch: "master", Tag: "v1.0.0", ModifiedFiles: 1})
+}
+
+func (s *GitRepoSuite) TestApproxStatus(c *C) {
+	c.Assert(ioutil.WriteFile("a.txt", []byte("a"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile("b.txt", []byte("b"), 0644), IsNil)
+	git("add .")
+	git("commit -m msg")
+	future := time.Now().Add(time.Hour)
+	c.Assert(os.Chtimes("a.txt", future, future), IsNil) // touched
+	c.Assert(ioutil.WriteFile("b.txt", []byte("c"), 0644), IsNil)
+
+	status := func(req Request) Facts {
+		facts := Facts{Repo: gitDetect(), Req: req}
+		VCSInfoGitGo(context.Background(), &facts)
+		facts.QA()
+		return facts
+	}
+	req := Request{Attr: AttrList{IsDirty: true, ModifiedFiles: true}}
+	facts := status(req)
+	c.Check(facts.Result(), DeepEquals, Attr{IsDirty: true, ModifiedFiles: 1})
+	c.Check(facts.Approx, Equals, AttrList{})
+
+	req.Approx = AttrList{IsDirty: true}
+	facts = status(req)
+	c.Check(facts.Result(), DeepEquals, Attr{IsDirty: true, ModifiedFiles: 1}) // not all accepted
+	c.Check(facts.Approx, Equals, AttrList{})
+
+	req.Approx = AttrList{IsDirty: true, ModifiedFiles: true}
+	facts = status(req)
+	c.Check(facts.Result(), DeepEquals, Attr{IsDirty: true, ModifiedFiles: 2})
+	c.Check(facts.Approx.IsDirty, Equals, true)
+	c.Check(facts.Approx.ModifiedFiles, Equals, true)
+	c.Check(facts.Approx.Tag, Equals, false)
+
+	req.Attr.HasUntrackedFiles = true
+	req.Approx.HasUntrackedFiles = true
+	facts = status(req)
+	c.Check(facts.Approx.IsDirty, Equals, true)
+	c.Check(facts.Approx.HasUntrackedFiles, Equals, false) // detected exactly
+}
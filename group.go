@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// factGroup is a group of facts which can be detected independently of
+// other groups. Detect func should set only own attrs in facts.Found
+// (all other will be ignored) and set facts.TimedOut if it was
+// interrupted by ctx.
+//
+// Same facts may be detected by several groups: approximate one is
+// used instead of accurate ones when all it's requested attrs are
+// accepted by Request.Approx.
+type factGroup struct {
+	attrs  AttrList
+	approx AttrList // attrs detected inaccurately by faster implementation
+	detect func(ctx context.Context, facts *Facts)
+}
+
+// statusAttrs are attributes usually detected by scanning working copy.
+var statusAttrs = AttrList{
+	IsDirty:           true,
+	HasAddedFiles:     true,
+	AddedFiles:        true,
+	HasModifiedFiles:  true,
+	ModifiedFiles:     true,
+	HasDeletedFiles:   true,
+	DeletedFiles:      true,
+	HasRenamedFiles:   true,
+	RenamedFiles:      true,
+	HasUnmergedFiles:  true,
+	UnmergedFiles:     true,
+	HasUntrackedFiles: true,
+}
+
+type groupResult struct {
+	group int
+	facts Facts
+}
+
+// detectGroups runs groups which has looked up attributes in parallel
+// goroutines (each with own copy of facts) and merge their results into
+// facts. When ctx is done it returns at once and attributes of groups
+// which are still running are marked in facts.TimedOut (these
+// goroutines are abandoned).
+func detectGroups(ctx context.Context, facts *Facts, groups []factGroup) {
+	var approx AttrList // detected by approximate groups
+	masks := make([]AttrList, len(groups))
+	for i := range groups {
+		req := facts.Req.Attr.Without(facts.Req.Attr.Without(groups[i].attrs))
+		if groups[i].approx != (AttrList{}) && req != (AttrList{}) && req.Without(facts.Req.Approx) == (AttrList{}) {
+			approx.merge(groups[i].attrs, groups[i].attrs)
+			masks[i] = groups[i].attrs
+		}
+	}
+	for i := range groups {
+		if groups[i].approx == (AttrList{}) {
+			masks[i] = groups[i].attrs.Without(approx)
+		}
+	}
+
+	results := make(chan groupResult, len(groups)) // goroutines won't block on timeout
+	var pending []bool
+	for i := range groups {
+		pending = append(pending, facts.Lookup != facts.Lookup.Without(masks[i]))
+		if !pending[i] {
+			continue
+		}
+		go func(i int, groupFacts Facts) {
+			groups[i].detect(ctx, &groupFacts)
+			groupFacts.Approx.merge(groupFacts.Lookup, groups[i].approx)
+			results <- groupResult{group: i, facts: groupFacts}
+		}(i, *facts)
+	}
+
+	for {
+		wait := false
+		for _, isPending := range pending {
+			wait = wait || isPending
+		}
+		if !wait {
+			return
+		}
+		select {
+		case res := <-results:
+			mask := masks[res.group]
+			pending[res.group] = false
+			facts.Found.merge(res.facts.Found, mask)
+			facts.Lookup.merge(res.facts.Lookup, mask)
+			facts.TimedOut.merge(res.facts.TimedOut, mask)
+			facts.Approx.merge(res.facts.Approx, mask)
+		case <-ctx.Done():
+			for i, isPending := range pending {
+				if isPending {
+					facts.TimedOut.merge(facts.Lookup, masks[i])
+				}
+			}
+			return
+		}
+	}
+}
+
+// logNoApprox logs accepted approximate attributes which will be
+// detected accurately because engine has no approximate implementation.
+func logNoApprox(engine string, facts *Facts) {
+	approx := facts.Req.Approx.Without(facts.Req.Approx.Without(facts.Lookup))
+	if approx != (AttrList{}) {
+		log.Println(engine, "engine has no approximate implementation:", &approx)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type GroupSuite struct{}
+
+var _ = Suite(&GroupSuite{})
+
+func (s *GroupSuite) TestDetectGroupsParallel(c *C) {
+	slowStarted := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	groups := []factGroup{
+		{
+			attrs: AttrList{Tag: true},
+			detect: func(ctx context.Context, facts *Facts) {
+				close(slowStarted)
+				<-release
+				facts.Found.Tag = "late"
+			},
+		},
+		{
+			attrs: AttrList{Branch: true},
+			detect: func(ctx context.Context, facts *Facts) {
+				select {
+				case <-slowStarted: // runs while slow group is running
+					facts.Found.Branch = "master"
+				case <-time.After(time.Second):
+				}
+			},
+		},
+		{
+			attrs: AttrList{StashedCommits: true},
+			detect: func(ctx context.Context, facts *Facts) {
+				c.Error("group without looked up attrs was run")
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	facts := Facts{Lookup: AttrList{Tag: true, Branch: true}}
+	start := time.Now()
+	detectGroups(ctx, &facts, groups)
+	c.Check(time.Since(start) < time.Second, Equals, true)
+	c.Check(facts.Found, DeepEquals, Attr{Branch: "master"})
+	c.Check(facts.TimedOut, Equals, AttrList{Tag: true})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mercurial support is based on vcprompt-hgst approach: cheap facts
+// (state, shelves, branch, revision) are read from .hg directly,
+// everything else is detected by executing hg with HGPLAIN to get stable
+// output not affected by user's config. All hg commands are executed
+// using single hg command server, see hgserver.go. Cheap native facts
+// are read before starting groups, revision/tag and status groups run
+// in parallel and share the server.
+//
+// Status of tracked files is detected using dirstate without executing
+// hg if this can be done accurately: untracked files, subrepos and
+// files which differ only by mtime (and thus need content comparison)
+// fallback to `hg status`.
+//
+// Branch contains active bookmark if any, named branch otherwise.
+// Shelves are reported as stashed commits.
+// Remote-related facts require network access and aren't supported.
+
+// hgAttrs are attributes supported by VCSInfoHg.
+var hgAttrs = AttrList{
+	VCS:               true,
+	RevisionShort:     true,
+	Branch:            true,
+	Tag:               true,
+	State:             true,
+	HasStashedCommits: true,
+	StashedCommits:    true,
+	IsDirty:           true,
+	HasAddedFiles:     true,
+	AddedFiles:        true,
+	HasModifiedFiles:  true,
+	ModifiedFiles:     true,
+	HasDeletedFiles:   true,
+	DeletedFiles:      true,
+	HasRenamedFiles:   true,
+	RenamedFiles:      true,
+	HasUnmergedFiles:  true,
+	UnmergedFiles:     true,
+	HasUntrackedFiles: true,
+}
+
+// hgLogTemplate outputs one fact per line, in order expected by
+// parseHgLog.
+const hgLogTemplate = "{node|short}\n{latesttag}\n"
+
+// VCSInfoHg returns mercurial facts for current repo.
+func VCSInfoHg(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSMercurial {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(hgAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasUnmergedFiles = l.HasUnmergedFiles || l.UnmergedFiles
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.HasStashedCommits = l.HasStashedCommits || l.StashedCommits
+	l.VCS = true
+
+	hgDir := filepath.Join(facts.Repo.Root, ".hg")
+	facts.Found.VCS = VCSMercurial
+
+	if l.Branch {
+		facts.Found.Branch = hgBookmark(hgDir)
+		if facts.Found.Branch == "" {
+			facts.Found.Branch = hgBranch(hgDir)
+		}
+	}
+	if l.State {
+		facts.Found.State = hgState(hgDir)
+	}
+	if l.HasStashedCommits {
+		facts.Found.StashedCommits = hgShelves(hgDir)
+		facts.Found.HasStashedCommits = facts.Found.StashedCommits > 0
+		l.StashedCommits = true
+	}
+
+	hg := newHgClient(facts.Repo.Root)
+	defer hg.close(ctx)
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  AttrList{RevisionShort: true, Tag: true},
+			detect: hg.with(hgRevision),
+		},
+		{
+			attrs:  statusAttrs,
+			detect: hg.with(hgStatus),
+		},
+	})
+}
+
+func hgRevision(ctx context.Context, hg *hgClient, facts *Facts) {
+	if !facts.Lookup.Tag {
+		if rev, ok := hgNativeRevision(facts.Repo.Root); ok {
+			facts.Found.RevisionShort = rev
+			return
+		}
+		log.Println("hg: native revision failed, fallback to hg log")
+	}
+	out, err := hg.run(ctx, facts, "log", "-r", ".", "-T", hgLogTemplate)
+	if err == nil {
+		parseHgLog(out, &facts.Found)
+	}
+}
+
+// hgNativeRevision returns working copy parent if it's known to changelog.
+func hgNativeRevision(root string) (rev string, ok bool) {
+	hgDir := filepath.Join(root, ".hg")
+	p1, _, err := hgDirstateParents(hgDir)
+	if os.IsNotExist(err) { // empty repo
+		return "", true
+	} else if err != nil {
+		log.Println("hg dirstate:", err)
+		return "", false
+	}
+	if p1.isNull() {
+		return "", true
+	}
+	storeDir := filepath.Join(hgDir, "store")
+	if _, err := os.Stat(storeDir); err != nil {
+		storeDir = hgDir // very old repo format
+	}
+	if ok, err := hgChangelogHas(storeDir, p1); err != nil || !ok {
+		log.Println("hg changelog: working copy parent not found:", err)
+		return "", false
+	}
+	return p1.short(), true
+}
+
+// hgBranch returns named branch of working copy (it may differ from
+// branch of working copy parent after `hg branch`).
+func hgBranch(hgDir string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(hgDir, "branch"))
+	if err != nil || len(bytes.TrimSpace(buf)) == 0 {
+		return "default"
+	}
+	return string(bytes.TrimSpace(buf))
+}
+
+func hgStatus(ctx context.Context, hg *hgClient, facts *Facts) {
+	l := &facts.Lookup
+	root := facts.Repo.Root
+	needUntracked := l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked)
+	_, err := os.Stat(filepath.Join(root, ".hgsub"))
+	hasSubrepos := facts.Req.IncludeSubmodules && err == nil
+	if needUntracked || hasSubrepos || !hgNativeStatus(facts, filepath.Join(root, hg.dir)) {
+		if !hgExecStatus(ctx, hg, facts) {
+			return
+		}
+	}
+
+	// Avoid running hg resolve if there are no merge in progress.
+	if !l.HasUnmergedFiles {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(root, hg.dir, "merge")); err != nil {
+		return
+	}
+	out, err := hg.run(ctx, facts, "resolve", "-l")
+	if err == nil {
+		parseHgResolve(out, &facts.Found)
+	}
+}
+
+func hgNativeStatus(facts *Facts, hgDir string) bool {
+	ds, err := readHgDirstate(hgDir)
+	if err != nil {
+		log.Println("hg dirstate:", err)
+		return false
+	}
+	found := facts.Found
+	if !ds.status(facts.Repo.Root, &found) {
+		log.Println("hg dirstate: inexact status, fallback to status command")
+		return false
+	}
+	facts.Found = found
+	l := &facts.Lookup
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.HasRenamedFiles, l.RenamedFiles = true, true
+	return true
+}
+
+func hgExecStatus(ctx context.Context, hg *hgClient, facts *Facts) bool {
+	l := &facts.Lookup
+	args := []string{"status", "-mard"}
+	if l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked) {
+		args = append(args, "-u")
+		l.HasUntrackedFiles = true
+	}
+	if l.HasRenamedFiles {
+		args = append(args, "-C")
+	}
+	if facts.Req.IncludeSubmodules && hg.cmd == "hg" { // sl has no subrepos
+		args = append(args, "-S")
+	}
+	out, err := hg.run(ctx, facts, args...)
+	if err != nil {
+		return false
+	}
+	parseHgStatus(out, &facts.Found)
+	// These counters are detected anyway.
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	return true
+}
+
+// parseHgLog parses output of hg log with hgLogTemplate.
+func parseHgLog(out []byte, found *Attr) {
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < 2 {
+		log.Printf("hg log: unexpected output: %q", out)
+		return
+	}
+	if strings.Trim(lines[0], "0") != "" { // not a null revision
+		found.RevisionShort = lines[0]
+	}
+	if tag := strings.Split(lines[1], ":")[0]; tag != "null" {
+		found.Tag = tag
+	}
+}
+
+// parseHgStatus parses output of hg status (optionally with -C).
+func parseHgStatus(out []byte, found *Attr) {
+	var added, removed []string
+	source := make(map[string]string) // added → copied from
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 3 {
+			continue
+		}
+		code, path := line[0], line[2:]
+		switch code {
+		case ' ': // copy source of previous added file
+			if len(added) > 0 {
+				source[added[len(added)-1]] = path
+			}
+		case 'A':
+			added = append(added, path)
+		case 'M':
+			found.ModifiedFiles++
+		case 'R':
+			removed = append(removed, path)
+		case '!':
+			found.DeletedFiles++
+		case '?':
+			found.HasUntrackedFiles = true
+		default:
+			log.Printf("hg status: unexpected line: %q", line)
+		}
+	}
+	renamed := make(map[string]bool)
+	for _, path := range removed {
+		renamed[path] = false
+	}
+	for _, path := range added {
+		if src, ok := source[path]; ok {
+			if isRenamed, ok := renamed[src]; ok && !isRenamed {
+				renamed[src] = true
+				found.RenamedFiles++
+				continue
+			}
+		}
+		found.AddedFiles++
+	}
+	for _, isRenamed := range renamed {
+		if !isRenamed {
+			found.DeletedFiles++
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+}
+
+// parseHgResolve parses output of hg resolve -l.
+// Unresolved files are also reported as modified by hg status.
+func parseHgResolve(out []byte, found *Attr) {
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "U ") {
+			found.UnmergedFiles++
+		}
+	}
+	found.HasUnmergedFiles = found.UnmergedFiles > 0
+}
+
+// hgState detects state using files in hgDir.
+func hgState(hgDir string) VCSState {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(hgDir, name))
+		return err == nil
+	}
+	switch {
+	case exists("rebasestate"):
+		return StateRebase
+	case exists("histedit-state"):
+		return StateRebaseInteractive
+	case exists("graftstate"):
+		return StateCherrypick
+	}
+	if _, p2, err := hgDirstateParents(hgDir); err == nil && !p2.isNull() {
+		return StateMerge
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(hgDir, "bisect.state")); err == nil && len(bytes.TrimSpace(buf)) > 0 {
+		return StateBisect
+	}
+	return StateNone
+}
+
+// hgShelves returns amount of shelved changes.
+func hgShelves(hgDir string) (n int) {
+	files, err := ioutil.ReadDir(filepath.Join(hgDir, "shelved"))
+	if err != nil {
+		return 0
+	}
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), ".patch") {
+			n++
+		}
+	}
+	return n
+}
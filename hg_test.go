@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func hgcmd(args string) {
+	cmd := exec.Command("hg", strings.Split(args, " ")...)
+	cmd.Env = append(os.Environ(), "HGPLAIN=1", "HGUSER=Nobody <root@localhost>")
+	err := cmd.Run()
+	if err != nil {
+		panic(err)
+	}
+}
+
+type HgSuite struct {
+	origDir string
+	req     Request
+	want    Attr
+}
+
+var _ = Suite(&HgSuite{})
+
+func (s *HgSuite) VCSInfoHg(c *C) Attr {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	facts := Facts{Repo: DetectRepo(cwd), Req: s.req}
+	VCSInfoHg(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *HgSuite) SetUpSuite(c *C) {
+	var err error
+	s.origDir, err = os.Getwd()
+	c.Assert(err, IsNil)
+}
+
+func (s *HgSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:               true,
+			Branch:            true,
+			Tag:               true,
+			State:             true,
+			HasStashedCommits: true,
+			StashedCommits:    true,
+			IsDirty:           true,
+			AddedFiles:        true,
+			ModifiedFiles:     true,
+			DeletedFiles:      true,
+			RenamedFiles:      true,
+			UnmergedFiles:     true,
+			HasUntrackedFiles: true,
+		},
+		DirtyIfUntracked: true,
+	}
+	s.want = Attr{VCS: VCSMercurial}
+	dir, err := filepath.EvalSymlinks(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(dir), IsNil)
+}
+
+func (s *HgSuite) TearDownSuite(c *C) {
+	c.Assert(os.Chdir(s.origDir), IsNil)
+}
+
+func requireHg(c *C) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		c.Skip("hg not installed")
+	}
+}
+
+func (s *HgSuite) requireHg(c *C) {
+	requireHg(c)
+	hgcmd("init")
+}
+
+func (s *HgSuite) TestParseLog(c *C) {
+	cases := []struct {
+		out  string
+		want Attr
+	}{
+		{"000000000000\nnull\n", Attr{}},
+		{"0123456789ab\nv1.0\n", Attr{RevisionShort: "0123456789ab", Tag: "v1.0"}},
+		{"0123456789ab\nv1.0:v1.0-rc\n", Attr{RevisionShort: "0123456789ab", Tag: "v1.0"}},
+		{"garbage", Attr{}},
+	}
+	for _, v := range cases {
+		var found Attr
+		parseHgLog([]byte(v.out), &found)
+		c.Check(found, DeepEquals, v.want, Commentf("%q", v.out))
+	}
+}
+
+func (s *HgSuite) TestParseStatus(c *C) {
+	out := "M mod1\nM mod2\nA new\nA copy\n  mod1\nA moved\n  old\nR old\nR gone\n! missing\n? junk\n"
+	var found Attr
+	parseHgStatus([]byte(out), &found)
+	c.Check(found, DeepEquals, Attr{
+		HasAddedFiles:     true,
+		AddedFiles:        2,
+		HasModifiedFiles:  true,
+		ModifiedFiles:     2,
+		HasDeletedFiles:   true,
+		DeletedFiles:      2,
+		HasRenamedFiles:   true,
+		RenamedFiles:      1,
+		HasUntrackedFiles: true,
+	})
+
+	found = Attr{}
+	parseHgStatus(nil, &found)
+	c.Check(found, DeepEquals, Attr{})
+}
+
+func (s *HgSuite) TestParseResolve(c *C) {
+	var found Attr
+	parseHgResolve([]byte("U a.txt\nR b.txt\nU c.txt\n"), &found)
+	c.Check(found, DeepEquals, Attr{HasUnmergedFiles: true, UnmergedFiles: 2})
+}
+
+func (s *HgSuite) TestState(c *C) {
+	hgDir := ".hg"
+	c.Assert(os.Mkdir(hgDir, 0755), IsNil)
+	c.Check(hgState(hgDir), Equals, StateNone)
+
+	dirstate := filepath.Join(hgDir, "dirstate")
+	parents := make([]byte, 40)
+	parents[0] = 1
+	c.Assert(ioutil.WriteFile(dirstate, parents, 0644), IsNil)
+	c.Check(hgState(hgDir), Equals, StateNone)
+	parents[39] = 1
+	c.Assert(ioutil.WriteFile(dirstate, parents, 0644), IsNil)
+	c.Check(hgState(hgDir), Equals, StateMerge)
+
+	v2 := append([]byte(hgDirstateV2Magic), make([]byte, 64)...)
+	v2[len(hgDirstateV2Magic)] = 1
+	c.Assert(ioutil.WriteFile(dirstate, v2, 0644), IsNil)
+	c.Check(hgState(hgDir), Equals, StateNone)
+	v2[len(hgDirstateV2Magic)+32] = 1
+	c.Assert(ioutil.WriteFile(dirstate, v2, 0644), IsNil)
+	c.Check(hgState(hgDir), Equals, StateMerge)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(hgDir, "graftstate"), nil, 0644), IsNil)
+	c.Check(hgState(hgDir), Equals, StateCherrypick)
+	c.Assert(ioutil.WriteFile(filepath.Join(hgDir, "rebasestate"), nil, 0644), IsNil)
+	c.Check(hgState(hgDir), Equals, StateRebase)
+}
+
+func (s *HgSuite) TestBranch(c *C) {
+	hgDir := ".hg"
+	c.Assert(os.Mkdir(hgDir, 0755), IsNil)
+	c.Check(hgBranch(hgDir), Equals, "default")
+	c.Assert(ioutil.WriteFile(filepath.Join(hgDir, "branch"), []byte("stable\n"), 0644), IsNil)
+	c.Check(hgBranch(hgDir), Equals, "stable")
+}
+
+func (s *HgSuite) TestShelves(c *C) {
+	hgDir := ".hg"
+	c.Check(hgShelves(hgDir), Equals, 0)
+	c.Assert(os.MkdirAll(filepath.Join(hgDir, "shelved"), 0755), IsNil)
+	for _, name := range []string{"default.hg", "default.patch", "default.shelve", "default-01.patch"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(hgDir, "shelved", name), nil, 0644), IsNil)
+	}
+	c.Check(hgShelves(hgDir), Equals, 2)
+}
+
+func (s *HgSuite) TestHgNoRepo(c *C) {
+	s.want.VCS = VCSNone
+	c.Check(s.VCSInfoHg(c), DeepEquals, s.want)
+}
+
+func (s *HgSuite) TestHgEmpty(c *C) {
+	s.requireHg(c)
+	s.want.Branch = "default"
+	c.Check(s.VCSInfoHg(c), DeepEquals, s.want)
+}
+
+func (s *HgSuite) TestHgRevisionBranchTag(c *C) {
+	s.requireHg(c)
+	s.req.Attr.RevisionShort = true
+	c.Assert(ioutil.WriteFile("a.txt", []byte("a"), 0644), IsNil)
+	hgcmd("commit -A -m ROOT")
+	res := s.VCSInfoHg(c)
+	c.Check(res.RevisionShort, Matches, "^[0-9a-f]{12}$")
+	c.Check(res.Branch, Equals, "default")
+	c.Check(res.Tag, Equals, "")
+
+	hgcmd("tag v1.0")
+	hgcmd("branch stable")
+	hgcmd("bookmark feature")
+	res = s.VCSInfoHg(c)
+	c.Check(res.Branch, Equals, "feature")
+	c.Check(res.Tag, Equals, "v1.0")
+
+	hgcmd("bookmark -i")
+	c.Check(s.VCSInfoHg(c).Branch, Equals, "stable")
+}
+
+func (s *HgSuite) TestHgFiles(c *C) {
+	s.requireHg(c)
+	for _, name := range []string{"mod.txt", "del.txt", "old.txt", "rm.txt"} {
+		c.Assert(ioutil.WriteFile(name, []byte(name), 0644), IsNil)
+	}
+	hgcmd("commit -A -m ROOT")
+	c.Check(s.VCSInfoHg(c), DeepEquals, Attr{VCS: VCSMercurial, Branch: "default"})
+
+	c.Assert(ioutil.WriteFile("mod.txt", []byte("changed"), 0644), IsNil)
+	c.Assert(os.Remove("del.txt"), IsNil)
+	hgcmd("mv old.txt new.txt")
+	hgcmd("rm rm.txt")
+	c.Assert(ioutil.WriteFile("add.txt", nil, 0644), IsNil)
+	hgcmd("add add.txt")
+	c.Assert(ioutil.WriteFile("junk.txt", nil, 0644), IsNil)
+	c.Check(s.VCSInfoHg(c), DeepEquals, Attr{
+		VCS:               VCSMercurial,
+		Branch:            "default",
+		IsDirty:           true,
+		AddedFiles:        1,
+		ModifiedFiles:     1,
+		DeletedFiles:      2,
+		RenamedFiles:      1,
+		HasUntrackedFiles: true,
+	})
+}
+
+func (s *HgSuite) TestHgNativeStatus(c *C) {
+	s.requireHg(c)
+	s.req.Attr.HasUntrackedFiles = false
+	s.req.DirtyIfUntracked = false
+	for _, name := range []string{"mod.txt", "old.txt"} {
+		c.Assert(ioutil.WriteFile(name, []byte(name), 0644), IsNil)
+	}
+	hgcmd("commit -A -m ROOT")
+	c.Assert(ioutil.WriteFile("junk.txt", nil, 0644), IsNil)
+	c.Check(s.VCSInfoHg(c), DeepEquals, Attr{VCS: VCSMercurial, Branch: "default"})
+
+	c.Assert(ioutil.WriteFile("mod.txt", []byte("changed"), 0644), IsNil)
+	hgcmd("mv old.txt new.txt")
+	c.Check(s.VCSInfoHg(c), DeepEquals, Attr{
+		VCS:           VCSMercurial,
+		Branch:        "default",
+		IsDirty:       true,
+		ModifiedFiles: 1,
+		RenamedFiles:  1,
+	})
+}
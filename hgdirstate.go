@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Native (without executing hg) reader for .hg files. It is used to
+// get cheap facts and to quickly detect status of tracked files.
+//
+// Formats:
+//	https://www.mercurial-scm.org/wiki/DirState
+//	https://www.mercurial-scm.org/repo/hg/help/internals.dirstate-v2
+//	https://www.mercurial-scm.org/repo/hg/help/internals.revlogs
+//
+// Sapling uses same dirstate v1 format or (by default) treestate format,
+// which starts with parents (as v1) but keeps entries in a separate file.
+// Only parents can be read from treestate.
+
+const (
+	hgDirstateV2Magic = "dirstate-v2\n"
+	hgTreestateMagic  = "\ntreestate\n\x00" // after parents
+)
+
+var (
+	errHgDirstate  = errors.New("hg dirstate: bad format")
+	errHgTreestate = errors.New("hg dirstate: treestate format is not supported")
+	errHgChangelog = errors.New("hg changelog: unsupported format")
+)
+
+// hgNode is a changeset hash.
+type hgNode [20]byte
+
+func (n hgNode) isNull() bool { return n == hgNode{} }
+
+// short returns same value as hg's {node|short}.
+func (n hgNode) short() string { return hex.EncodeToString(n[:6]) }
+
+// hgDirstateEntry uses dirstate v1 semantics.
+type hgDirstateEntry struct {
+	state      byte  // n(ormal), a(dded), r(emoved), m(erged)
+	mode       int32 // file mode (0 if unknown)
+	size       int32 // -1 if unknown, -2 if from other parent
+	mtime      int32 // -1 if unknown (need to compare content)
+	copySource string
+}
+
+type hgDirstate struct {
+	p1, p2  hgNode
+	entries map[string]hgDirstateEntry
+}
+
+// hgDirstateParents returns working copy parents without reading whole
+// dirstate.
+func hgDirstateParents(hgDir string) (p1, p2 hgNode, err error) {
+	f, err := os.Open(filepath.Join(hgDir, "dirstate"))
+	if err != nil {
+		return p1, p2, err
+	}
+	defer f.Close()
+	buf := make([]byte, len(hgDirstateV2Magic)+64)
+	n, _ := io.ReadFull(f, buf)
+	return parseHgDirstateParents(buf[:n])
+}
+
+func parseHgDirstateParents(buf []byte) (p1, p2 hgNode, err error) {
+	// v1: p1[20] p2[20] …
+	// v2 docket: "dirstate-v2\n" p1[32] p2[32] …
+	switch {
+	case bytes.HasPrefix(buf, []byte(hgDirstateV2Magic)):
+		buf = buf[len(hgDirstateV2Magic):]
+		if len(buf) < 64 {
+			return p1, p2, errHgDirstate
+		}
+		copy(p1[:], buf[:20])
+		copy(p2[:], buf[32:52])
+	case len(buf) >= 40:
+		copy(p1[:], buf[:20])
+		copy(p2[:], buf[20:40])
+	default:
+		return p1, p2, errHgDirstate
+	}
+	return p1, p2, nil
+}
+
+// readHgDirstate reads dirstate v1 or v2. Missing dirstate (in empty
+// repo) is not an error.
+func readHgDirstate(hgDir string) (*hgDirstate, error) {
+	ds := &hgDirstate{entries: make(map[string]hgDirstateEntry)}
+	buf, err := ioutil.ReadFile(filepath.Join(hgDir, "dirstate"))
+	if os.IsNotExist(err) {
+		return ds, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ds.p1, ds.p2, err = parseHgDirstateParents(buf); err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(buf, []byte(hgDirstateV2Magic)):
+		err = ds.parseV2(hgDir, buf[len(hgDirstateV2Magic)+64:])
+	case bytes.HasPrefix(buf[40:], []byte(hgTreestateMagic)):
+		err = errHgTreestate
+	default:
+		err = ds.parseV1(buf[40:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+func (ds *hgDirstate) parseV1(buf []byte) error {
+	// Entry: state[1] mode[4] size[4] mtime[4] len[4] name[len]
+	// where name may be "name\0copysource".
+	for len(buf) > 0 {
+		if len(buf) < 17 {
+			return errHgDirstate
+		}
+		e := hgDirstateEntry{
+			state: buf[0],
+			mode:  int32(binary.BigEndian.Uint32(buf[1:])),
+			size:  int32(binary.BigEndian.Uint32(buf[5:])),
+			mtime: int32(binary.BigEndian.Uint32(buf[9:])),
+		}
+		n := int(binary.BigEndian.Uint32(buf[13:]))
+		buf = buf[17:]
+		if n > len(buf) {
+			return errHgDirstate
+		}
+		name := string(buf[:n])
+		buf = buf[n:]
+		if i := strings.IndexByte(name, 0); i != -1 {
+			name, e.copySource = name[:i], name[i+1:]
+		}
+		ds.entries[name] = e
+	}
+	return nil
+}
+
+// Dirstate v2 node flags.
+const (
+	hgWdirTracked = 1 << iota
+	hgP1Tracked
+	hgP2Info
+	hgModeExecPerm
+	hgModeIsSymlink
+	hgHasFallbackExec
+	hgFallbackExec
+	hgHasFallbackSymlink
+	hgFallbackSymlink
+	hgExpectedStateIsModified
+	hgHasModeAndSize
+	hgHasMtime
+	hgMtimeSecondAmbiguous
+)
+
+const (
+	hgV2TreeMetadataSize = 44
+	hgV2NodeSize         = 44
+)
+
+func (ds *hgDirstate) parseV2(hgDir string, docket []byte) error {
+	// Docket (after parents): data_size[4] tree_metadata[44] uuid_len[1] uuid
+	if len(docket) < 4+hgV2TreeMetadataSize+1 {
+		return errHgDirstate
+	}
+	dataSize := int(binary.BigEndian.Uint32(docket))
+	meta := docket[4 : 4+hgV2TreeMetadataSize]
+	uuidLen := int(docket[4+hgV2TreeMetadataSize])
+	uuid := docket[4+hgV2TreeMetadataSize+1:]
+	if len(uuid) < uuidLen {
+		return errHgDirstate
+	}
+	data, err := ioutil.ReadFile(filepath.Join(hgDir, "dirstate."+string(uuid[:uuidLen])))
+	if err != nil {
+		return err
+	}
+	if len(data) < dataSize {
+		return errHgDirstate
+	}
+	data = data[:dataSize]
+	// Tree metadata: root_nodes_start[4] root_nodes_count[4] …
+	return ds.parseV2Nodes(data,
+		binary.BigEndian.Uint32(meta), binary.BigEndian.Uint32(meta[4:]))
+}
+
+func (ds *hgDirstate) parseV2Nodes(data []byte, start, count uint32) error {
+	if uint64(start)+uint64(count)*hgV2NodeSize > uint64(len(data)) {
+		return errHgDirstate
+	}
+	path := func(start uint32, n uint16) (string, error) {
+		if uint64(start)+uint64(n) > uint64(len(data)) {
+			return "", errHgDirstate
+		}
+		return string(data[start : start+uint32(n)]), nil
+	}
+	for i := uint32(0); i < count; i++ {
+		// Node: full_path[4+2] base_name_start[2] copy_source[4+2]
+		// children[4+4] descendants_with_entry_count[4]
+		// tracked_descendants_count[4] flags[2] size[4]
+		// mtime_seconds[4] mtime_nanoseconds[4]
+		node := data[start+i*hgV2NodeSize:][:hgV2NodeSize]
+		name, err := path(binary.BigEndian.Uint32(node), binary.BigEndian.Uint16(node[4:]))
+		if err != nil {
+			return err
+		}
+		var copySource string
+		if srcStart := binary.BigEndian.Uint32(node[8:]); srcStart != 0 {
+			if copySource, err = path(srcStart, binary.BigEndian.Uint16(node[12:])); err != nil {
+				return err
+			}
+		}
+		err = ds.parseV2Nodes(data, binary.BigEndian.Uint32(node[14:]), binary.BigEndian.Uint32(node[18:]))
+		if err != nil {
+			return err
+		}
+		flags := binary.BigEndian.Uint16(node[30:])
+		size := int32(binary.BigEndian.Uint32(node[32:]))
+		mtime := int32(binary.BigEndian.Uint32(node[36:]))
+		if e, ok := hgV2Entry(flags, size, mtime); ok {
+			e.copySource = copySource
+			ds.entries[name] = e
+		}
+	}
+	return nil
+}
+
+// hgV2Entry converts dirstate v2 node data to v1 entry.
+func hgV2Entry(flags uint16, size, mtime int32) (e hgDirstateEntry, ok bool) {
+	wdir, p1, p2 := flags&hgWdirTracked != 0, flags&hgP1Tracked != 0, flags&hgP2Info != 0
+	switch {
+	case !wdir && !p1 && !p2:
+		return e, false // directory or untracked/ignored cache
+	case !wdir:
+		e.state = 'r'
+	case !p1 && !p2:
+		e.state = 'a'
+	case p1 && p2:
+		e.state = 'm'
+	case p2:
+		e.state, e.size = 'n', -2
+	default:
+		e.state, e.size, e.mtime = 'n', -1, -1
+		if flags&hgHasModeAndSize != 0 {
+			e.size = size & 0x7fffffff
+			e.mode = 0644
+			if flags&hgModeExecPerm != 0 {
+				e.mode = 0755
+			}
+			if flags&hgModeIsSymlink != 0 {
+				e.mode |= int32(0120000)
+			} else {
+				e.mode |= int32(0100000)
+			}
+		}
+		if flags&hgHasMtime != 0 && flags&(hgMtimeSecondAmbiguous|hgExpectedStateIsModified) == 0 {
+			e.mtime = mtime & 0x7fffffff
+		}
+		if flags&(hgHasFallbackExec|hgHasFallbackSymlink) != 0 {
+			e.mtime = -1 // not supported, force content check
+		}
+	}
+	return e, true
+}
+
+// status detects status of tracked files in root (untracked files are
+// not detected). It returns false if status of some files can't be
+// detected without comparing content (this can be done only by hg).
+func (ds *hgDirstate) status(root string, found *Attr) (exact bool) {
+	exact = true
+	renamedFrom := make(map[string]bool)
+	for _, e := range ds.entries {
+		if e.state == 'a' && e.copySource != "" && ds.entries[e.copySource].state == 'r' {
+			renamedFrom[e.copySource] = true
+		}
+	}
+	for name, e := range ds.entries {
+		switch e.state {
+		case 'a':
+			if renamedFrom[e.copySource] {
+				found.RenamedFiles++
+			} else {
+				found.AddedFiles++
+			}
+			continue
+		case 'r':
+			if !renamedFrom[name] {
+				found.DeletedFiles++
+			}
+			continue
+		case 'm':
+			found.ModifiedFiles++
+			continue
+		case 'n':
+		default:
+			log.Printf("hg dirstate: unknown state %q of %q", e.state, name)
+			exact = false
+			continue
+		}
+		fi, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name)))
+		switch {
+		case os.IsNotExist(err):
+			found.DeletedFiles++
+		case err != nil:
+			log.Println("hg dirstate:", err)
+			exact = false
+		case e.size == -2:
+			found.ModifiedFiles++
+		case e.size < 0 || e.mode == 0:
+			exact = false
+		case isHgModeChanged(e.mode, fi.Mode()):
+			found.ModifiedFiles++
+		case e.size != int32(fi.Size()&0x7fffffff):
+			found.ModifiedFiles++
+		case e.mtime < 0 || e.mtime != int32(fi.ModTime().Unix()&0x7fffffff):
+			exact = false // same size, need to compare content
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+	return exact
+}
+
+func isHgModeChanged(mode int32, fm os.FileMode) bool {
+	isSymlink := mode&0170000 == 0120000
+	if isSymlink != (fm&os.ModeSymlink != 0) {
+		return true
+	}
+	return !isSymlink && (mode&0100 != 0) != (fm&0100 != 0)
+}
+
+// hgBookmark returns active bookmark or empty string.
+func hgBookmark(hgDir string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(hgDir, "bookmarks.current"))
+	if err != nil {
+		return ""
+	}
+	name := string(bytes.TrimSpace(buf))
+	// Bookmark is inactive if it was deleted.
+	buf, err = ioutil.ReadFile(filepath.Join(hgDir, "bookmarks"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if i := strings.IndexByte(line, ' '); i != -1 && line[i+1:] == name {
+			return name
+		}
+	}
+	return ""
+}
+
+// hgChangelogHas returns true if changelog index in storeDir contains
+// node. Only revlog v1 (RevlogNG) format is supported.
+func hgChangelogHas(storeDir string, node hgNode) (bool, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(storeDir, "00changelog.i"))
+	if err != nil {
+		return false, err
+	}
+	// Entry: offset_flags[8] comp_len[4] uncomp_len[4] base[4] link[4]
+	// p1[4] p2[4] node[32]; first 4 bytes of first entry contains
+	// flags[2] version[2] instead of offset.
+	const entrySize = 64
+	const flagInline = 1 << 0
+	if len(buf) == 0 {
+		return false, nil
+	}
+	if len(buf) < entrySize || binary.BigEndian.Uint16(buf[2:]) != 1 {
+		return false, errHgChangelog
+	}
+	inline := binary.BigEndian.Uint16(buf)&flagInline != 0
+	if !inline {
+		// Recent revisions are more likely to be working copy parent.
+		for pos := len(buf) - len(buf)%entrySize - entrySize; pos >= 0; pos -= entrySize {
+			if bytes.Equal(buf[pos+32:pos+52], node[:]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	for pos := 0; pos+entrySize <= len(buf); {
+		if bytes.Equal(buf[pos+32:pos+52], node[:]) {
+			return true, nil
+		}
+		pos += entrySize + int(binary.BigEndian.Uint32(buf[pos+8:]))
+	}
+	return false, nil
+}
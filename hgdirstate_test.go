@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type HgDirstateSuite struct {
+	root  string
+	hgDir string
+}
+
+var _ = Suite(&HgDirstateSuite{})
+
+func (s *HgDirstateSuite) SetUpTest(c *C) {
+	s.root = c.MkDir()
+	s.hgDir = filepath.Join(s.root, ".hg")
+	c.Assert(os.Mkdir(s.hgDir, 0755), IsNil)
+}
+
+var (
+	testHgP1 = hgNode{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	testHgP2 = hgNode{0xfe, 0xdc}
+)
+
+type testHgEntry struct {
+	name  string
+	state byte
+	mode  int32
+	size  int32
+	mtime int32
+	copy  string
+}
+
+func testHgDirstateV1(p1, p2 hgNode, entries []testHgEntry) []byte {
+	buf := append(append([]byte(nil), p1[:]...), p2[:]...)
+	for _, e := range entries {
+		name := e.name
+		if e.copy != "" {
+			name += "\x00" + e.copy
+		}
+		var hdr [17]byte
+		hdr[0] = e.state
+		binary.BigEndian.PutUint32(hdr[1:], uint32(e.mode))
+		binary.BigEndian.PutUint32(hdr[5:], uint32(e.size))
+		binary.BigEndian.PutUint32(hdr[9:], uint32(e.mtime))
+		binary.BigEndian.PutUint32(hdr[13:], uint32(len(name)))
+		buf = append(append(buf, hdr[:]...), name...)
+	}
+	return buf
+}
+
+type testHgNode struct {
+	path     string
+	copy     string
+	flags    uint16
+	size     int32
+	mtime    int32
+	children []testHgNode
+}
+
+// testHgDirstateV2 returns docket and data files.
+func testHgDirstateV2(p1, p2 hgNode, uuid string, nodes []testHgNode) (docket, data []byte) {
+	data = []byte{0} // offset 0 means "no copy source"
+	var write func(nodes []testHgNode) (start uint32)
+	write = func(nodes []testHgNode) uint32 {
+		var buf []byte
+		for _, n := range nodes {
+			childStart := write(n.children)
+			pathStart := uint32(len(data))
+			data = append(data, n.path...)
+			var copyStart uint32
+			if n.copy != "" {
+				copyStart = uint32(len(data))
+				data = append(data, n.copy...)
+			}
+			node := make([]byte, hgV2NodeSize)
+			binary.BigEndian.PutUint32(node[0:], pathStart)
+			binary.BigEndian.PutUint16(node[4:], uint16(len(n.path)))
+			binary.BigEndian.PutUint32(node[8:], copyStart)
+			binary.BigEndian.PutUint16(node[12:], uint16(len(n.copy)))
+			binary.BigEndian.PutUint32(node[14:], childStart)
+			binary.BigEndian.PutUint32(node[18:], uint32(len(n.children)))
+			binary.BigEndian.PutUint16(node[30:], n.flags)
+			binary.BigEndian.PutUint32(node[32:], uint32(n.size))
+			binary.BigEndian.PutUint32(node[36:], uint32(n.mtime))
+			buf = append(buf, node...)
+		}
+		start := uint32(len(data))
+		data = append(data, buf...)
+		return start
+	}
+	rootStart := write(nodes)
+
+	docket = []byte(hgDirstateV2Magic)
+	docket = append(append(docket, p1[:]...), make([]byte, 12)...)
+	docket = append(append(docket, p2[:]...), make([]byte, 12)...)
+	var hdr [4 + hgV2TreeMetadataSize]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(len(data)))
+	binary.BigEndian.PutUint32(hdr[4:], rootStart)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(nodes)))
+	docket = append(append(append(docket, hdr[:]...), byte(len(uuid))), uuid...)
+	return docket, data
+}
+
+func (s *HgDirstateSuite) write(c *C, name string, data []byte) {
+	c.Assert(ioutil.WriteFile(filepath.Join(s.hgDir, name), data, 0644), IsNil)
+}
+
+func (s *HgDirstateSuite) TestParents(c *C) {
+	_, _, err := hgDirstateParents(s.hgDir)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	s.write(c, "dirstate", testHgDirstateV1(testHgP1, hgNode{}, nil))
+	p1, p2, err := hgDirstateParents(s.hgDir)
+	c.Check(err, IsNil)
+	c.Check(p1, Equals, testHgP1)
+	c.Check(p1.short(), Equals, "0123456789ab")
+	c.Check(p2.isNull(), Equals, true)
+
+	docket, _ := testHgDirstateV2(testHgP1, testHgP2, "abc", nil)
+	s.write(c, "dirstate", docket)
+	p1, p2, err = hgDirstateParents(s.hgDir)
+	c.Check(err, IsNil)
+	c.Check(p1, Equals, testHgP1)
+	c.Check(p2, Equals, testHgP2)
+
+	s.write(c, "dirstate", []byte("short"))
+	_, _, err = hgDirstateParents(s.hgDir)
+	c.Check(err, Equals, errHgDirstate)
+}
+
+func (s *HgDirstateSuite) TestReadV1(c *C) {
+	s.write(c, "dirstate", testHgDirstateV1(testHgP1, testHgP2, []testHgEntry{
+		{name: "a.txt", state: 'n', mode: 0100644, size: 3, mtime: 1000},
+		{name: "dir/b.txt", state: 'a', mode: 0, size: -1, mtime: -1, copy: "a.txt"},
+	}))
+	ds, err := readHgDirstate(s.hgDir)
+	c.Assert(err, IsNil)
+	c.Check(ds.p1, Equals, testHgP1)
+	c.Check(ds.p2, Equals, testHgP2)
+	c.Check(ds.entries, DeepEquals, map[string]hgDirstateEntry{
+		"a.txt":     {state: 'n', mode: 0100644, size: 3, mtime: 1000},
+		"dir/b.txt": {state: 'a', mode: 0, size: -1, mtime: -1, copySource: "a.txt"},
+	})
+
+	buf := testHgDirstateV1(testHgP1, testHgP2, []testHgEntry{{name: "a.txt", state: 'n'}})
+	s.write(c, "dirstate", buf[:len(buf)-1])
+	_, err = readHgDirstate(s.hgDir)
+	c.Check(err, Equals, errHgDirstate)
+}
+
+func (s *HgDirstateSuite) TestTreestate(c *C) {
+	buf := testHgDirstateV1(testHgP1, testHgP2, nil)
+	s.write(c, "dirstate", append(buf, hgTreestateMagic+"\x00\x00\x00\x00"...))
+	p1, p2, err := hgDirstateParents(s.hgDir)
+	c.Check(err, IsNil)
+	c.Check(p1, Equals, testHgP1)
+	c.Check(p2, Equals, testHgP2)
+	_, err = readHgDirstate(s.hgDir)
+	c.Check(err, Equals, errHgTreestate)
+}
+
+func (s *HgDirstateSuite) TestReadV2(c *C) {
+	const tracked = hgWdirTracked | hgP1Tracked
+	docket, data := testHgDirstateV2(testHgP1, hgNode{}, "0123abcd", []testHgNode{
+		{path: "a.txt", flags: tracked | hgHasModeAndSize | hgHasMtime, size: 3, mtime: 1000},
+		{path: "x.sh", flags: tracked | hgHasModeAndSize | hgModeExecPerm, size: 5},
+		{path: "dir", children: []testHgNode{
+			{path: "dir/new.txt", flags: hgWdirTracked, copy: "a.txt"},
+			{path: "dir/gone.txt", flags: hgP1Tracked},
+			{path: "dir/merged.txt", flags: tracked | hgP2Info},
+			{path: "dir/other.txt", flags: hgWdirTracked | hgP2Info},
+			{path: "dir/racy.txt", flags: tracked | hgHasModeAndSize | hgHasMtime | hgMtimeSecondAmbiguous, size: 1, mtime: 1000},
+		}},
+	})
+	s.write(c, "dirstate", docket)
+	s.write(c, "dirstate.0123abcd", data)
+	ds, err := readHgDirstate(s.hgDir)
+	c.Assert(err, IsNil)
+	c.Check(ds.p1, Equals, testHgP1)
+	c.Check(ds.p2.isNull(), Equals, true)
+	c.Check(ds.entries, DeepEquals, map[string]hgDirstateEntry{
+		"a.txt":          {state: 'n', mode: 0100644, size: 3, mtime: 1000},
+		"x.sh":           {state: 'n', mode: 0100755, size: 5, mtime: -1},
+		"dir/new.txt":    {state: 'a', copySource: "a.txt"},
+		"dir/gone.txt":   {state: 'r'},
+		"dir/merged.txt": {state: 'm'},
+		"dir/other.txt":  {state: 'n', size: -2},
+		"dir/racy.txt":   {state: 'n', mode: 0100644, size: 1, mtime: -1},
+	})
+
+	s.write(c, "dirstate.0123abcd", data[:len(data)-1])
+	_, err = readHgDirstate(s.hgDir)
+	c.Check(err, Equals, errHgDirstate)
+}
+
+func (s *HgDirstateSuite) TestStatus(c *C) {
+	mtime := time.Unix(1500000000, 0)
+	file := func(name, content string, mode os.FileMode) {
+		path := filepath.Join(s.root, name)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte(content), mode), IsNil)
+		c.Assert(os.Chmod(path, mode), IsNil)
+		c.Assert(os.Chtimes(path, mtime, mtime), IsNil)
+	}
+	file("clean.txt", "abc", 0644)
+	file("resized.txt", "abcd", 0644)
+	file("chmod.sh", "abc", 0755)
+	file("dir/new.txt", "abc", 0644)
+	file("moved.txt", "abc", 0644)
+	file("merged.txt", "abc", 0644)
+	file("other.txt", "abc", 0644)
+	ts := int32(mtime.Unix())
+	ds := &hgDirstate{entries: map[string]hgDirstateEntry{
+		"clean.txt":   {state: 'n', mode: 0100644, size: 3, mtime: ts},
+		"resized.txt": {state: 'n', mode: 0100644, size: 3, mtime: ts},
+		"chmod.sh":    {state: 'n', mode: 0100644, size: 3, mtime: ts},
+		"missing.txt": {state: 'n', mode: 0100644, size: 3, mtime: ts},
+		"dir/new.txt": {state: 'a', size: -1, mtime: -1},
+		"removed.txt": {state: 'r'},
+		"orig.txt":    {state: 'r'},
+		"moved.txt":   {state: 'a', size: -1, mtime: -1, copySource: "orig.txt"},
+		"merged.txt":  {state: 'm'},
+		"other.txt":   {state: 'n', size: -2},
+	}}
+	var found Attr
+	c.Check(ds.status(s.root, &found), Equals, true)
+	c.Check(found, DeepEquals, Attr{
+		HasAddedFiles:    true,
+		AddedFiles:       1,
+		HasModifiedFiles: true,
+		ModifiedFiles:    4,
+		HasDeletedFiles:  true,
+		DeletedFiles:     2,
+		HasRenamedFiles:  true,
+		RenamedFiles:     1,
+	})
+
+	for _, e := range []hgDirstateEntry{
+		{state: 'n', mode: 0100644, size: 3, mtime: ts + 1},
+		{state: 'n', mode: 0100644, size: 3, mtime: -1},
+		{state: 'n', size: -1, mtime: -1},
+	} {
+		ds = &hgDirstate{entries: map[string]hgDirstateEntry{"clean.txt": e}}
+		found = Attr{}
+		c.Check(ds.status(s.root, &found), Equals, false, Commentf("%+v", e))
+	}
+}
+
+func (s *HgDirstateSuite) TestBookmark(c *C) {
+	c.Check(hgBookmark(s.hgDir), Equals, "")
+	s.write(c, "bookmarks.current", []byte("feature"))
+	c.Check(hgBookmark(s.hgDir), Equals, "")
+	s.write(c, "bookmarks", []byte("0123456789abcdef0123456789abcdef01234567 feature\n"))
+	c.Check(hgBookmark(s.hgDir), Equals, "feature")
+}
+
+func testHgChangelog(inline bool, nodes ...hgNode) []byte {
+	var buf []byte
+	for i, node := range nodes {
+		entry := make([]byte, 64)
+		if i == 0 {
+			binary.BigEndian.PutUint16(entry[2:], 1) // version
+			if inline {
+				binary.BigEndian.PutUint16(entry[0:], 1)
+			}
+		}
+		copy(entry[32:], node[:])
+		if inline {
+			binary.BigEndian.PutUint32(entry[8:], 3)
+			entry = append(entry, "xyz"...)
+		}
+		buf = append(buf, entry...)
+	}
+	return buf
+}
+
+func (s *HgDirstateSuite) TestChangelog(c *C) {
+	_, err := hgChangelogHas(s.hgDir, testHgP1)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	for _, inline := range []bool{false, true} {
+		s.write(c, "00changelog.i", testHgChangelog(inline, testHgP2, testHgP1))
+		ok, err := hgChangelogHas(s.hgDir, testHgP1)
+		c.Check(err, IsNil)
+		c.Check(ok, Equals, true, Commentf("inline=%v", inline))
+		s.write(c, "00changelog.i", testHgChangelog(inline, testHgP2))
+		ok, err = hgChangelogHas(s.hgDir, testHgP1)
+		c.Check(err, IsNil)
+		c.Check(ok, Equals, false, Commentf("inline=%v", inline))
+	}
+
+	s.write(c, "00changelog.i", make([]byte, 64)) // version 0
+	_, err = hgChangelogHas(s.hgDir, testHgP1)
+	c.Check(err, Equals, errHgChangelog)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Mercurial command server protocol is described at
+// https://www.mercurial-scm.org/wiki/CommandServer
+//
+// Starting hg is the slowest part of any hg command, so all commands
+// needed to detect facts for a repo are executed by a single
+// `hg serve --cmdserver pipe` process. Commands are executed one by one
+// (this is a protocol limitation), but in parallel with native facts
+// detected by other groups (e.g. dirstate scan). Server is started
+// lazily, by first command, because most of the time native facts are
+// enough and hg isn't needed at all.
+//
+// `hg summary` isn't used: all facts it reports which have matching
+// Attr fields are detected cheaper by reading .hg or by status and log.
+
+// hgServerMaxMessage limits size of message read from server, to not
+// allocate arbitrary amount of memory because of broken header.
+const hgServerMaxMessage = 16 << 20
+
+var (
+	errHgServerInput    = errors.New("hg command server: input requested")
+	errHgServerProtocol = errors.New("hg command server: protocol error")
+)
+
+// hgClient executes hg commands for a repo using command server if
+// possible or separate hg processes otherwise. It's also used to execute
+// sl (which has no compatible command server) for Sapling repo.
+// It's safe for concurrent use.
+type hgClient struct {
+	root   string
+	cmd    string // hg or sl
+	dir    string // .hg or .sl
+	mu     sync.Mutex
+	server *hgServer
+	failed bool // don't try to start command server again
+}
+
+// hgServer is a connection to a running command server.
+type hgServer struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+func newHgClient(root string) *hgClient {
+	return &hgClient{root: root, cmd: "hg", dir: ".hg"}
+}
+
+func newSlClient(root string) *hgClient {
+	return &hgClient{root: root, cmd: "sl", dir: ".sl", failed: true}
+}
+
+// with provides detect with shared hg client.
+func (hg *hgClient) with(detect func(context.Context, *hgClient, *Facts)) func(context.Context, *Facts) {
+	return func(ctx context.Context, facts *Facts) {
+		detect(ctx, hg, facts)
+	}
+}
+
+// run executes hg command and returns it's stdout.
+// On ctx timeout it sets facts.TimedOut.
+func (hg *hgClient) run(ctx context.Context, facts *Facts, args ...string) ([]byte, error) {
+	hg.mu.Lock()
+	defer hg.mu.Unlock()
+
+	if hg.server == nil && !hg.failed {
+		var err error
+		hg.server, err = startHgServer(ctx, hg.root)
+		if err != nil && ctx.Err() == nil {
+			log.Println(err)
+		}
+		hg.failed = err != nil
+	}
+
+	var out []byte
+	var err error
+	if hg.server != nil {
+		out, err = hg.server.runcommand(args...)
+		if _, isExit := err.(hgExitError); err != nil && !isExit && ctx.Err() == nil {
+			log.Println(err)
+			hg.server.close()
+			hg.server, hg.failed = nil, true
+		}
+	}
+	if hg.server == nil {
+		out, err = hg.exec(ctx, args...)
+	}
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.Printf("%s %s: %s", hg.cmd, strings.Join(args, " "), err)
+	}
+	return out, err
+}
+
+// exec executes hg command in a separate process.
+func (hg *hgClient) exec(ctx context.Context, args ...string) ([]byte, error) {
+	args = append([]string{"-R", hg.root}, args...)
+	cmd := exec.CommandContext(ctx, hg.cmd, args...)
+	cmd.Env = append(os.Environ(), "HGPLAIN=1")
+	return cmd.Output()
+}
+
+// close stops command server. If ctx is done it won't wait for commands
+// which are still running - server will be killed by ctx anyway.
+func (hg *hgClient) close(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+	hg.mu.Lock()
+	defer hg.mu.Unlock()
+	if hg.server != nil {
+		hg.server.close()
+		hg.server = nil
+	}
+}
+
+// startHgServer starts command server for repo at root. Server will be
+// killed when ctx is done.
+func startHgServer(ctx context.Context, root string) (*hgServer, error) {
+	cmd := exec.CommandContext(ctx, "hg", "-R", root, "serve", "--cmdserver", "pipe")
+	cmd.Env = append(os.Environ(), "HGPLAIN=1")
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("hg command server: %s", err)
+	}
+	s := &hgServer{cmd: cmd, in: in, out: bufio.NewReader(out)}
+	if err = s.hello(); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *hgServer) close() {
+	s.in.Close()
+	if s.cmd != nil {
+		_ = s.cmd.Wait() // it's either exited by EOF or killed by ctx
+	}
+}
+
+// readChannel reads next message from server. For input channels data
+// is nil.
+func (s *hgServer) readChannel() (channel byte, data []byte, err error) {
+	var hdr [5]byte
+	if _, err = io.ReadFull(s.out, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	channel, size := hdr[0], binary.BigEndian.Uint32(hdr[1:])
+	if channel == 'I' || channel == 'L' {
+		return channel, nil, nil
+	}
+	if size > hgServerMaxMessage {
+		return 0, nil, fmt.Errorf("message too large: %d bytes", size)
+	}
+	data = make([]byte, size)
+	if _, err = io.ReadFull(s.out, data); err != nil {
+		return 0, nil, err
+	}
+	return channel, data, nil
+}
+
+// hello checks server's hello message.
+func (s *hgServer) hello() error {
+	channel, data, err := s.readChannel()
+	if err != nil {
+		return fmt.Errorf("hg command server: %s", err)
+	} else if channel != 'o' {
+		return errHgServerProtocol
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "capabilities:") {
+			for _, capability := range strings.Fields(line[len("capabilities:"):]) {
+				if capability == "runcommand" {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("hg command server: runcommand not supported: %q", data)
+}
+
+// hgExitError is a non-zero exit code of hg command.
+type hgExitError int32
+
+func (e hgExitError) Error() string { return fmt.Sprintf("exit status %d", int32(e)) }
+
+// runcommand executes hg command and returns it's output.
+func (s *hgServer) runcommand(args ...string) ([]byte, error) {
+	req := []byte("runcommand\n")
+	arg := []byte(strings.Join(args, "\x00"))
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(arg)))
+	req = append(append(req, size[:]...), arg...)
+	if _, err := s.in.Write(req); err != nil {
+		return nil, fmt.Errorf("hg command server: %s", err)
+	}
+
+	var out, stderr bytes.Buffer
+	for {
+		channel, data, err := s.readChannel()
+		if err != nil {
+			return nil, fmt.Errorf("hg command server: %s", err)
+		}
+		switch channel {
+		case 'o':
+			out.Write(data)
+		case 'e':
+			stderr.Write(data)
+		case 'r':
+			if len(data) != 4 {
+				return nil, errHgServerProtocol
+			}
+			if stderr.Len() > 0 {
+				log.Printf("hg %s: %s", strings.Join(args, " "), bytes.TrimSpace(stderr.Bytes()))
+			}
+			if code := int32(binary.BigEndian.Uint32(data)); code != 0 {
+				return out.Bytes(), hgExitError(code)
+			}
+			return out.Bytes(), nil
+		case 'I', 'L':
+			// Server will wait for input forever, so this connection
+			// can't be used anymore.
+			return nil, errHgServerInput
+		default:
+			// Unknown optional (lowercase) channels should be ignored.
+			if 'A' <= channel && channel <= 'Z' {
+				return nil, errHgServerProtocol
+			}
+		}
+	}
+}
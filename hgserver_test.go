@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	. "gopkg.in/check.v1"
+)
+
+type HgServerSuite struct{}
+
+var _ = Suite(&HgServerSuite{})
+
+type testWriteCloser struct{ bytes.Buffer }
+
+func (*testWriteCloser) Close() error { return nil }
+
+func testHgMsg(channel byte, data string) string {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	return string(channel) + string(size[:]) + data
+}
+
+func testHgResult(code int32) string {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(code))
+	return testHgMsg('r', string(data[:]))
+}
+
+func testHgServer(out string) (*hgServer, *testWriteCloser) {
+	in := &testWriteCloser{}
+	return &hgServer{in: in, out: bufio.NewReader(bytes.NewBufferString(out))}, in
+}
+
+func (s *HgServerSuite) TestHello(c *C) {
+	cases := []struct {
+		out     string
+		wantErr bool
+	}{
+		{testHgMsg('o', "capabilities: getencoding runcommand\nencoding: UTF-8\npid: 42"), false},
+		{testHgMsg('o', "capabilities: getencoding\nencoding: UTF-8"), true},
+		{testHgMsg('e', "capabilities: runcommand"), true},
+		{testHgMsg('o', "capabilities: runcommand")[:8], true},
+		{"", true},
+	}
+	for _, v := range cases {
+		srv, _ := testHgServer(v.out)
+		err := srv.hello()
+		c.Check(err != nil, Equals, v.wantErr, Commentf("%q: %v", v.out, err))
+	}
+}
+
+func (s *HgServerSuite) TestRuncommand(c *C) {
+	srv, in := testHgServer(
+		testHgMsg('o', "M a.txt\n") + testHgMsg('d', "debug") + testHgMsg('e', "warning") +
+			testHgMsg('o', "A b.txt\n") + testHgResult(0) +
+			testHgMsg('o', "partial") + testHgResult(1) +
+			"L\x00\x00\x10\x00")
+	out, err := srv.runcommand("status", "-mard")
+	c.Check(err, IsNil)
+	c.Check(string(out), Equals, "M a.txt\nA b.txt\n")
+	c.Check(in.String(), Equals, "runcommand\n\x00\x00\x00\x0cstatus\x00-mard")
+
+	out, err = srv.runcommand("resolve", "-l")
+	c.Check(err, Equals, hgExitError(1))
+	c.Check(string(out), Equals, "partial")
+
+	_, err = srv.runcommand("commit")
+	c.Check(err, Equals, errHgServerInput)
+
+	_, err = srv.runcommand("log")
+	c.Check(err, ErrorMatches, "hg command server: EOF")
+
+	srv, _ = testHgServer(testHgMsg('X', "") + testHgResult(0))
+	_, err = srv.runcommand("log")
+	c.Check(err, Equals, errHgServerProtocol)
+
+	srv, _ = testHgServer(testHgMsg('r', "\x00"))
+	_, err = srv.runcommand("log")
+	c.Check(err, Equals, errHgServerProtocol)
+
+	srv, _ = testHgServer("o\xff\xff\xff\xff")
+	_, err = srv.runcommand("log")
+	c.Check(err, ErrorMatches, "hg command server: message too large: 4294967295 bytes")
+}
+
+func (s *HgServerSuite) TestClient(c *C) {
+	requireHg(c)
+	root := c.MkDir()
+	hgcmd("init " + root)
+	hg := newHgClient(root)
+	defer hg.close(context.Background())
+	facts := &Facts{}
+	for i := 0; i < 2; i++ {
+		out, err := hg.run(context.Background(), facts, "branch")
+		c.Check(err, IsNil)
+		c.Check(string(out), Equals, "default\n")
+	}
+	c.Check(hg.server, NotNil)
+	_, err := hg.run(context.Background(), facts, "log", "-r", "nosuchrev")
+	c.Check(err, Equals, hgExitError(255))
+	c.Check(hg.server, NotNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	facts.Lookup = AttrList{Tag: true}
+	_, err = hg.run(ctx, facts, "branch")
+	c.Check(err, Equals, context.Canceled)
+	c.Check(facts.TimedOut, DeepEquals, AttrList{Tag: true})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Jujutsu support is based on executing jj: first command updates
+// working copy commit (this is how jj detects working copy changes) and
+// returns most facts, other commands (if needed) are executed in
+// parallel with --ignore-working-copy.
+//
+// Colocated repo (with both .jj and .git) is handled as jj repo because
+// git sees it as detached HEAD with changes not yet committed by jj.
+//
+// RevisionShort contains commit id and ChangeID contains change id of
+// working copy commit. Branch contains bookmarks of working copy commit
+// or (if it has none) of it's parents, as usually bookmarks in jj are
+// moved to @- before push. IsDirty means working copy commit isn't empty.
+// Conflicts are recorded in commits, so unmerged files are files with
+// conflicts in working copy commit.
+// Remote-related facts and untracked files (jj tracks new files
+// automatically) aren't supported.
+
+// jjAttrs are attributes supported by VCSInfoJj.
+var jjAttrs = AttrList{
+	VCS:                true,
+	RevisionShort:      true,
+	ChangeID:           true,
+	Branch:             true,
+	IsDirty:            true,
+	IsDescriptionEmpty: true,
+	HasAddedFiles:      true,
+	AddedFiles:         true,
+	HasModifiedFiles:   true,
+	ModifiedFiles:      true,
+	HasDeletedFiles:    true,
+	DeletedFiles:       true,
+	HasRenamedFiles:    true,
+	RenamedFiles:       true,
+	HasUnmergedFiles:   true,
+	UnmergedFiles:      true,
+}
+
+// jjLogTemplate outputs one fact per line, in order expected by
+// parseJjLog.
+const jjLogTemplate = `change_id.short() ++ "\n" ++
+	commit_id.short() ++ "\n" ++
+	local_bookmarks.map(|b| b.name()).join(",") ++ "\n" ++
+	parents.map(|c| c.local_bookmarks().map(|b| b.name()).join(",")).join(",") ++ "\n" ++
+	if(empty, "empty") ++ "\n" ++
+	if(description, "", "no description") ++ "\n" ++
+	if(conflict, "conflict") ++ "\n"`
+
+// VCSInfoJj returns jujutsu facts for current repo.
+func VCSInfoJj(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSJujutsu {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(jjAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasUnmergedFiles = l.HasUnmergedFiles || l.UnmergedFiles
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.VCS = true
+
+	facts.Found.VCS = VCSJujutsu
+
+	out, err := jj(ctx, facts, "log", "-r", "@", "--no-graph", "-T", jjLogTemplate)
+	if err != nil {
+		return
+	}
+	// These facts are detected anyway and needed to avoid running
+	// other commands.
+	l.IsDirty, l.HasUnmergedFiles = true, true
+	var found Attr
+	parseJjLog(out, &found)
+	facts.Found.merge(found, l.Without(AttrList{VCS: true}))
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs: AttrList{
+				HasAddedFiles:    true,
+				AddedFiles:       true,
+				HasModifiedFiles: true,
+				ModifiedFiles:    true,
+				HasDeletedFiles:  true,
+				DeletedFiles:     true,
+				HasRenamedFiles:  true,
+				RenamedFiles:     true,
+			},
+			detect: jjDiff,
+		},
+		{
+			attrs:  AttrList{UnmergedFiles: true},
+			detect: jjResolve,
+		},
+	})
+}
+
+// jj executes jj command for facts.Repo and returns it's stdout.
+func jj(ctx context.Context, facts *Facts, args ...string) ([]byte, error) {
+	args = append([]string{"-R", facts.Repo.Root, "--no-pager", "--color", "never"}, args...)
+	cmd := exec.CommandContext(ctx, "jj", args...)
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.Printf("jj %s: %s", strings.Join(args, " "), err)
+	}
+	return out, err
+}
+
+func jjDiff(ctx context.Context, facts *Facts) {
+	if !facts.Found.IsDirty {
+		return // working copy commit is empty
+	}
+	out, err := jj(ctx, facts, "diff", "-r", "@", "--summary", "--ignore-working-copy")
+	if err != nil {
+		return
+	}
+	parseJjDiff(out, &facts.Found)
+	// These counters are detected anyway.
+	l := &facts.Lookup
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.HasRenamedFiles, l.RenamedFiles = true, true
+}
+
+func jjResolve(ctx context.Context, facts *Facts) {
+	if !facts.Found.HasUnmergedFiles {
+		return // jj resolve fails without conflicts
+	}
+	out, err := jj(ctx, facts, "resolve", "--list", "-r", "@", "--ignore-working-copy")
+	if err != nil {
+		return
+	}
+	parseJjResolve(out, &facts.Found)
+}
+
+// parseJjLog parses output of jj log with jjLogTemplate.
+func parseJjLog(out []byte, found *Attr) {
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < 7 {
+		log.Printf("jj log: unexpected output: %q", out)
+		return
+	}
+	found.ChangeID = lines[0]
+	found.RevisionShort = lines[1]
+	found.Branch = lines[2]
+	if found.Branch == "" {
+		found.Branch = lines[3]
+	}
+	found.IsDirty = lines[4] == ""
+	found.IsDescriptionEmpty = lines[5] != ""
+	found.HasUnmergedFiles = lines[6] != ""
+}
+
+// parseJjDiff parses output of jj diff --summary.
+func parseJjDiff(out []byte, found *Attr) {
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 3 {
+			continue
+		}
+		switch line[0] {
+		case 'A', 'C':
+			found.AddedFiles++
+		case 'M':
+			found.ModifiedFiles++
+		case 'D':
+			found.DeletedFiles++
+		case 'R':
+			found.RenamedFiles++
+		default:
+			log.Printf("jj diff: unexpected line: %q", line)
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+}
+
+// parseJjResolve parses output of jj resolve --list.
+func parseJjResolve(out []byte, found *Attr) {
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) != "" {
+			found.UnmergedFiles++
+		}
+	}
+	found.HasUnmergedFiles = found.UnmergedFiles > 0
+}
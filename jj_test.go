@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func jjcmd(dir, args string) {
+	cmd := exec.Command("jj", strings.Split(args, " ")...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "JJ_USER=Nobody", "JJ_EMAIL=root@localhost")
+	err := cmd.Run()
+	if err != nil {
+		panic(err)
+	}
+}
+
+type JjSuite struct {
+	root string
+	req  Request
+}
+
+var _ = Suite(&JjSuite{})
+
+func (s *JjSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:                true,
+			RevisionShort:      true,
+			ChangeID:           true,
+			Branch:             true,
+			IsDirty:            true,
+			IsDescriptionEmpty: true,
+			AddedFiles:         true,
+			ModifiedFiles:      true,
+			DeletedFiles:       true,
+			RenamedFiles:       true,
+			UnmergedFiles:      true,
+		},
+	}
+	s.root = c.MkDir()
+}
+
+func (s *JjSuite) VCSInfoJj(c *C) Attr {
+	facts := Facts{Repo: Repo{VCS: VCSJujutsu, Root: s.root}, Req: s.req}
+	VCSInfoJj(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *JjSuite) requireJj(c *C) {
+	if _, err := exec.LookPath("jj"); err != nil {
+		c.Skip("jj not installed")
+	}
+	jjcmd(s.root, "git init --colocate")
+}
+
+func (s *JjSuite) TestParseLog(c *C) {
+	cases := []struct {
+		out  string
+		want Attr
+	}{
+		{"zzzzzzzz\n00000000\n\n\nempty\nno description\n\n", Attr{ChangeID: "zzzzzzzz", RevisionShort: "00000000", IsDescriptionEmpty: true}},
+		{"kxqpmyzw\n0123abcd\nfeature,fix\nmain\n\n\nconflict\n", Attr{ChangeID: "kxqpmyzw", RevisionShort: "0123abcd", Branch: "feature,fix", IsDirty: true, HasUnmergedFiles: true}},
+		{"kxqpmyzw\n0123abcd\n\nmain\nempty\n\n\n", Attr{ChangeID: "kxqpmyzw", RevisionShort: "0123abcd", Branch: "main"}},
+		{"garbage", Attr{}},
+	}
+	for _, v := range cases {
+		var found Attr
+		parseJjLog([]byte(v.out), &found)
+		c.Check(found, DeepEquals, v.want, Commentf("%q", v.out))
+	}
+}
+
+func (s *JjSuite) TestParseDiff(c *C) {
+	var found Attr
+	parseJjDiff([]byte("M mod.txt\nA new.txt\nC {a.txt => copy.txt}\nD del.txt\nR {old.txt => new2.txt}\nM dir/mod.txt\n"), &found)
+	c.Check(found, DeepEquals, Attr{
+		HasAddedFiles:    true,
+		AddedFiles:       2,
+		HasModifiedFiles: true,
+		ModifiedFiles:    2,
+		HasDeletedFiles:  true,
+		DeletedFiles:     1,
+		HasRenamedFiles:  true,
+		RenamedFiles:     1,
+	})
+}
+
+func (s *JjSuite) TestParseResolve(c *C) {
+	var found Attr
+	parseJjResolve([]byte("a.txt    2-sided conflict\nb.txt    2-sided conflict including 1 deletion\n"), &found)
+	c.Check(found, DeepEquals, Attr{HasUnmergedFiles: true, UnmergedFiles: 2})
+}
+
+func (s *JjSuite) TestJjEmpty(c *C) {
+	s.requireJj(c)
+	res := s.VCSInfoJj(c)
+	c.Check(res.ChangeID, Matches, "^[k-z]+$")
+	c.Check(res.RevisionShort, Matches, "^[0-9a-f]+$")
+	res.ChangeID, res.RevisionShort = "", ""
+	c.Check(res, DeepEquals, Attr{VCS: VCSJujutsu, IsDescriptionEmpty: true})
+}
+
+func (s *JjSuite) TestJjChanges(c *C) {
+	s.requireJj(c)
+	s.req.Attr.RevisionShort, s.req.Attr.ChangeID = false, false
+	for _, name := range []string{"mod.txt", "del.txt"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(s.root, name), []byte(name), 0644), IsNil)
+	}
+	jjcmd(s.root, "commit -m ROOT")
+	jjcmd(s.root, "bookmark create main -r @-")
+	c.Check(s.VCSInfoJj(c), DeepEquals, Attr{VCS: VCSJujutsu, Branch: "main", IsDescriptionEmpty: true})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "mod.txt"), []byte("changed"), 0644), IsNil)
+	c.Assert(os.Remove(filepath.Join(s.root, "del.txt")), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "add.txt"), nil, 0644), IsNil)
+	jjcmd(s.root, "describe -m WIP")
+	c.Check(s.VCSInfoJj(c), DeepEquals, Attr{
+		VCS:           VCSJujutsu,
+		Branch:        "main",
+		IsDirty:       true,
+		AddedFiles:    1,
+		ModifiedFiles: 1,
+		DeletedFiles:  1,
+	})
+}
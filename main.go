@@ -1,5 +1,21 @@
 package main
 
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
 // Goals:
 // - vcprompt drop-in replacement mode
 //   * rename binary in GH releases? move to cmd/vcprompt/?
@@ -36,42 +52,258 @@ package main
 // - show something special when timeout happens to avoid needs in
 //   configuring anything per-repo
 func main() {
-	// TODO
-	// - parse flags
-	//   * TODO check all vcprompt/vcs_info/etc. implementations and
-	//     try to define most-compatible to everyone flags list
-	//   * output help
-	//   * setup log to STDERR only in debug mode, otherwise to /dev/null
-	// - detect VCS here, to avoid trying different VCS engines
-	//   one-by-one and have each one read same dirs again and again
-	//   * TODO check how vcs_info does this
-	//   * chdir to repo root, to avoid more detection by executed
-	//     commands and simplify rest of code
-	// - exit without output (except log) if no VCS detected
-	// - get rest of configuration (from environment?)
-	// - call VCS engine to gather facts according to configuration
-	// - output facts in user-defined format
-	//   * boolean fact  detected     ? pre/user-defined value : nothing
-	//   * boolean fact  not detected ? pre/user-defined value : nothing
-	//   * enum fact                    pre/user-defined value
-	//   * value fact    not empty    ? fact's value           : nothing
-	//   * value fact    empty        ? pre/user-defined value : nothing
-	//   * is boolean fact == true    ? sub-format strings for true/false
-	//   * is enum fact == some value ? sub-format strings for true/false
-	// - add boolean facts for: revision, branch, tag
-	// - different format strings (choose one either by VCSType or by
-	//   repo path - let user define pairs "repo path - format name"):
-	//   * default
-	//   * for each VCSType
-	//   * custom names
-	//   * predefined-hardcoded (for quick start / compatibility modes)
-	// - different option lists just like formats - per repo/VCSType/…
-	// - probably it makes sense to use same format strings as zsh:
-	//   * we anyway should support %b for branch because of vcprompt
-	//   * this force %… in format, with %% for % and conflict with
-	//     zsh's PS1 %b (just like this happens for vcs_info)
-	//   * conditional %(… true false) and %) for ) is no worse than
-	//     anything else (except if I like to use Go templates here)
-	//   * TODO check all vcprompt/vcs_info/etc. implementations and
-	//     try to define most-compatible to everyone format syntax
+	isVCPrompt := false
+	if args := os.Args[1:]; filepath.Base(os.Args[0]) == "vcprompt" {
+		vcpromptParse(args)
+		isVCPrompt = true
+	} else if len(args) > 0 && (args[0] == "-vcprompt" || args[0] == "--vcprompt") {
+		vcpromptParse(args[1:])
+		isVCPrompt = true
+	} else {
+		flag.Parse()
+		if flag.NArg() > 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		flag.Visit(func(f *flag.Flag) { cfg.isSet[f.Name] = true })
+		modes := 0
+		for _, isSet := range []bool{cfg.format != "", cfg.template != "", cfg.json, cfg.eval != ""} {
+			if isSet {
+				modes++
+			}
+		}
+		if modes > 1 {
+			fmt.Fprintln(os.Stderr, "flags -format, -template, -json and -eval are mutually exclusive")
+			os.Exit(2)
+		}
+		if cfg.isSet["format-name"] && modes > 0 {
+			fmt.Fprintln(os.Stderr, "flag -format-name can't be used with -format, -template, -json and -eval")
+			os.Exit(2)
+		}
+		if gitEngines[cfg.req.GitEngine] == nil {
+			fmt.Fprintf(os.Stderr, "unknown git engine: %q\n", cfg.req.GitEngine)
+			os.Exit(2)
+		}
+	}
+
+	if !cfg.debug {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Println("os.Getwd:", err)
+		return
+	}
+	repo := DetectRepo(cwd)
+	vcsInfo := vcsInfo[repo.VCS]
+	if vcsInfo == nil {
+		log.Printf("VCS not supported: %q", repo.VCS)
+		return
+	}
+
+	// Load config before chdir to resolve relative -config path.
+	if !isVCPrompt {
+		conf, err := LoadConfig(cfg.config)
+		if err == nil {
+			err = applyConfig(conf, repo.VCS, repo.Root)
+		}
+		if err == nil {
+			err = setupOutput()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	// Avoid more detection by VCS engines and executed commands.
+	if err := os.Chdir(repo.Root); err != nil {
+		log.Println("os.Chdir:", err)
+		return
+	}
+	dir, err := filepath.Rel(repo.Root, cwd)
+	if err != nil {
+		log.Println("filepath.Rel:", err)
+		return
+	}
+
+	req := cfg.req
+	req.Attr = cfg.attrs
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	start := time.Now()
+	facts := Facts{Repo: repo, Req: req}
+	vcsInfo(ctx, &facts)
+	log.Println("gather facts:", time.Since(start))
+	if facts.TimedOut != (AttrList{}) {
+		log.Println("timed out:", &facts.TimedOut)
+	}
+	if facts.Approx != (AttrList{}) {
+		log.Println("approximate:", &facts.Approx)
+	}
+	if cfg.debug {
+		facts.QA()
+	}
+
+	if facts.Found.VCS == VCSNone {
+		return
+	}
+	cfg.output(os.Stdout, facts, dir)
+}
+
+// Default configuration.
+const (
+	defaultTimeout = time.Second
+	defaultAttrs   = "VCS,Branch,State,IsDirty,HasUntrackedFiles"
+)
+
+var cfg struct {
+	debug      bool
+	timeout    time.Duration
+	attrs      AttrList
+	format     string
+	formatName string
+	template   string
+	json       bool
+	eval       string
+	config     string
+	req        Request
+	output     func(w io.Writer, facts Facts, dir string)
+	isSet      map[string]bool // flags set in command line
+}
+
+// vcsInfo contains VCS engines for all supported VCS.
+var vcsInfo = map[VCSType]func(context.Context, *Facts){
+	VCSGit:        vcsInfoGitEngine,
+	VCSMercurial:  VCSInfoHg,
+	VCSSubversion: VCSInfoSvn,
+	VCSBazaar:     VCSInfoBzr,
+	VCSJujutsu:    VCSInfoJj,
+	VCSFossil:     VCSInfoFossil,
+	VCSSapling:    VCSInfoSl,
+	VCSDarcs:      VCSInfoDarcs,
+	VCSPijul:      VCSInfoPijul,
+	VCSCVS:        VCSInfoCVS,
+}
+
+// vcsInfoGitEngine returns git facts using engine chosen by request.
+func vcsInfoGitEngine(ctx context.Context, facts *Facts) {
+	engine := facts.Req.GitEngine
+	if engine == "" {
+		engine = defaultGitEngine
+	}
+	if facts.Req.Attr.Without(gitHeadAttrs) == (AttrList{}) {
+		VCSInfoGitHead(ctx, facts)
+		return
+	}
+	if len(facts.Req.GitRoutes) > 0 && engine != gitHybridEngine {
+		log.Printf("git routes are ignored by %q engine", engine)
+	}
+	gitEngines[engine](ctx, facts)
+}
+
+// gitEngineNames returns sorted names of available git engines.
+func gitEngineNames() []string {
+	names := make([]string, 0, len(gitEngines))
+	for name := range gitEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	flag.Usage = usage
+	if err := cfg.attrs.Set(defaultAttrs); err != nil {
+		panic(err)
+	}
+	cfg.req.IncludeSubmodules = true
+	cfg.output = printAttrs
+	cfg.isSet = make(map[string]bool)
+	flag.BoolVar(&cfg.debug, "debug", false, "log to STDERR")
+	flag.DurationVar(&cfg.timeout, "timeout", defaultTimeout, "max `duration` to gather facts")
+	flag.Var(&cfg.attrs, "attrs", "comma-separated `list` of attributes to output")
+	flag.StringVar(&cfg.format, "format", "", "output attributes using `format` instead of list")
+	flag.StringVar(&cfg.formatName, "format-name", "", "output attributes using format with given `name` from config or predefined")
+	flag.StringVar(&cfg.template, "template", "", "output attributes using Go `template` instead of list")
+	flag.BoolVar(&cfg.json, "json", false, "output facts (all attributes unless -attrs is set) in JSON instead of list")
+	flag.StringVar(&cfg.eval, "eval", "", "output attributes (all unless -attrs is set) as variables for `shell` (sh, bash, zsh, fish) instead of list: vcs_NAME where NAME is attribute name in JSON (e.g. vcs_is_dirty)")
+	flag.BoolVar(&cfg.req.DirtyIfUntracked, "dirty-if-untracked", cfg.req.DirtyIfUntracked, "consider untracked files as dirty")
+	flag.BoolVar(&cfg.req.RenamesFromRewrites, "renames-from-rewrites", cfg.req.RenamesFromRewrites, "detect renames from rewritten files")
+	flag.BoolVar(&cfg.req.IncludeSubmodules, "submodules", cfg.req.IncludeSubmodules, "check changes in submodules")
+	flag.StringVar(&cfg.req.GitEngine, "git-engine", defaultGitEngine, "git `engine`") // usage lists engines registered by init
+	flag.Var(&cfg.req.Approx, "approx", "comma-separated `list` of attributes which may be detected approximately")
+	flag.Var(&cfg.req.GitRoutes, "git-routes", "comma-separated `list` of route=engine for hybrid git engine")
+	flag.StringVar(&cfg.config, "config", defaultConfigPath(), "config `file`")
+}
+
+// setupOutput set attributes and output func according to output mode.
+func setupOutput() error {
+	switch {
+	case cfg.format != "":
+		format, err := ParseFormat(cfg.format)
+		if err != nil {
+			return err
+		}
+		cfg.attrs = format.Attrs()
+		cfg.output = func(w io.Writer, facts Facts, _ string) {
+			io.WriteString(w, format.Execute(facts.Result(), facts.Approx))
+		}
+	case cfg.template != "":
+		tmpl, err := ParseTemplate(cfg.template)
+		if err != nil {
+			return err
+		}
+		cfg.attrs = TemplateAttrs(tmpl)
+		cfg.output = func(w io.Writer, facts Facts, _ string) {
+			if err := ExecuteTemplate(w, tmpl, facts); err != nil {
+				log.Println("template:", err)
+			}
+		}
+	case cfg.json:
+		if !cfg.isSet["attrs"] {
+			cfg.attrs = allAttrs()
+		}
+		cfg.output = func(w io.Writer, facts Facts, _ string) {
+			if err := json.NewEncoder(w).Encode(facts); err != nil {
+				log.Println("json:", err)
+			}
+		}
+	case cfg.eval != "":
+		if !evalShells[cfg.eval] {
+			return fmt.Errorf("unsupported shell: %q", cfg.eval)
+		}
+		if !cfg.isSet["attrs"] {
+			cfg.attrs = allAttrs()
+		}
+		cfg.output = func(w io.Writer, facts Facts, _ string) {
+			if err := printEval(w, cfg.eval, facts.Result(), facts.Approx); err != nil {
+				log.Println("eval:", err)
+			}
+		}
+	}
+	return nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(out, "       %s -vcprompt [vcprompt flags]\n", filepath.Base(os.Args[0]))
+	flag.Lookup("git-engine").Usage = "git `engine`: " + strings.Join(gitEngineNames(), ", ")
+	flag.PrintDefaults()
+	all := allAttrs()
+	fmt.Fprintf(out, "\nAttributes:\n  %s\n", strings.Replace(all.String(), ",", " ", -1))
+}
+
+// printAttrs outputs requested attributes, one per line.
+func printAttrs(w io.Writer, facts Facts, _ string) {
+	list := reflect.ValueOf(facts.Req.Attr)
+	attr := reflect.ValueOf(facts.Result())
+	for i := 0; i < list.NumField(); i++ {
+		if list.Field(i).Bool() {
+			fmt.Fprintf(w, "%s=%v\n", list.Type().Field(i).Name, attr.Field(i))
+		}
+	}
 }
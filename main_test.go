@@ -0,0 +1,35 @@
+package main
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type MainSuite struct{}
+
+var _ = Suite(&MainSuite{})
+
+func (s *MainSuite) TestSetupOutputAllAttrs(c *C) {
+	saved := cfg
+	defer func() { cfg = saved }()
+
+	var defaults AttrList
+	c.Assert(defaults.Set(defaultAttrs), IsNil)
+	cases := []struct {
+		json     bool
+		eval     string
+		attrsSet bool
+		want     AttrList
+	}{
+		{false, "", false, defaults},
+		{true, "", false, allAttrs()},
+		{true, "", true, defaults},
+		{false, "sh", false, allAttrs()},
+		{false, "sh", true, defaults},
+	}
+	for _, v := range cases {
+		cfg.json, cfg.eval, cfg.attrs = v.json, v.eval, defaults
+		cfg.isSet = map[string]bool{"attrs": v.attrsSet}
+		c.Check(setupOutput(), IsNil)
+		c.Check(cfg.attrs, Equals, v.want, Commentf("json=%v eval=%q attrs=%v", v.json, v.eval, v.attrsSet))
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Pijul support is based on executing pijul, except for current channel
+// which is read from .pijul/config.
+//
+// Branch contains current channel. Unrecorded changes are detected using
+// `pijul diff --short`. Pijul tags have no names, so Tag contains first
+// line of latest tag's message.
+// Untracked files, revision (pijul has no single hash for channel
+// state), state and remote-related facts aren't supported.
+
+// pijulAttrs are attributes supported by VCSInfoPijul.
+var pijulAttrs = AttrList{
+	VCS:              true,
+	Branch:           true,
+	Tag:              true,
+	IsDirty:          true,
+	HasAddedFiles:    true,
+	AddedFiles:       true,
+	HasModifiedFiles: true,
+	ModifiedFiles:    true,
+	HasDeletedFiles:  true,
+	DeletedFiles:     true,
+	HasRenamedFiles:  true,
+	RenamedFiles:     true,
+}
+
+// pijulDefaultChannel is used when .pijul/config has no current channel.
+const pijulDefaultChannel = "main"
+
+// VCSInfoPijul returns pijul facts for current repo.
+func VCSInfoPijul(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSPijul {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(pijulAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.VCS = true
+
+	facts.Found.VCS = VCSPijul
+
+	if l.Branch {
+		facts.Found.Branch = pijulChannel(filepath.Join(facts.Repo.Root, ".pijul"))
+	}
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  AttrList{Tag: true},
+			detect: pijulTag,
+		},
+		{
+			attrs:  statusAttrs,
+			detect: pijulStatus,
+		},
+	})
+}
+
+// pijulChannel returns current channel.
+func pijulChannel(pijulDir string) string {
+	f, err := os.Open(filepath.Join(pijulDir, "config"))
+	if err != nil {
+		return pijulDefaultChannel
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Config is in TOML, but only top-level key is needed.
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "current_channel" {
+			continue
+		}
+		channel, err := strconv.Unquote(strings.TrimSpace(kv[1]))
+		if err != nil {
+			log.Println("pijul config: bad current_channel:", err)
+			break
+		}
+		return channel
+	}
+	return pijulDefaultChannel
+}
+
+// pijul executes pijul command for facts.Repo and returns it's stdout.
+func pijul(ctx context.Context, facts *Facts, args ...string) ([]byte, error) {
+	args = append(args, "--repository", facts.Repo.Root)
+	cmd := exec.CommandContext(ctx, "pijul", args...)
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.Printf("pijul %s: %s", strings.Join(args, " "), err)
+	}
+	return out, err
+}
+
+func pijulTag(ctx context.Context, facts *Facts) {
+	out, err := pijul(ctx, facts, "tag")
+	if err == nil {
+		facts.Found.Tag = parsePijulTags(out)
+	}
+}
+
+func pijulStatus(ctx context.Context, facts *Facts) {
+	out, err := pijul(ctx, facts, "diff", "--short")
+	if err != nil {
+		return
+	}
+	parsePijulDiff(out, &facts.Found)
+	// These counters are detected anyway.
+	l := &facts.Lookup
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.HasRenamedFiles, l.RenamedFiles = true, true
+}
+
+// parsePijulTags parses output of pijul tag (in log-like format, from
+// latest to oldest) and returns first line of latest tag's message.
+func parsePijulTags(out []byte) string {
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "    ") && strings.TrimSpace(line) != "" {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// parsePijulDiff parses output of pijul diff --short. It contains a
+// line per change, so same file may be reported more than once.
+func parsePijulDiff(out []byte, found *Attr) {
+	const (
+		modified = iota + 1
+		renamed
+		deleted
+		added
+	)
+	files := make(map[string]int)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		path := strings.TrimSpace(fields[1])
+		var status int
+		switch fields[0] {
+		case "A":
+			status = added
+		case "D":
+			status = deleted
+		case "MV":
+			status = renamed
+		case "M", "R", "UD", "SC", "UC", "SL", "UL":
+			status = modified
+		default:
+			log.Printf("pijul diff: unexpected line: %q", line)
+			continue
+		}
+		if status > files[path] { // added/deleted file may also be edited
+			files[path] = status
+		}
+	}
+	for _, status := range files {
+		switch status {
+		case added:
+			found.AddedFiles++
+		case deleted:
+			found.DeletedFiles++
+		case renamed:
+			found.RenamedFiles++
+		case modified:
+			found.ModifiedFiles++
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type PijulSuite struct {
+	root string
+	req  Request
+}
+
+var _ = Suite(&PijulSuite{})
+
+func (s *PijulSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:           true,
+			Branch:        true,
+			Tag:           true,
+			IsDirty:       true,
+			AddedFiles:    true,
+			ModifiedFiles: true,
+		},
+	}
+	s.root = c.MkDir()
+}
+
+func (s *PijulSuite) VCSInfoPijul(c *C) Attr {
+	facts := Facts{Repo: Repo{VCS: VCSPijul, Root: s.root}, Req: s.req}
+	VCSInfoPijul(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *PijulSuite) pijul(c *C, args ...string) {
+	cmd := exec.Command("pijul", args...)
+	cmd.Dir = s.root
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+}
+
+func (s *PijulSuite) TestParseDiff(c *C) {
+	out := "M  mod.txt\nM  mod.txt\nA  new.txt\nM  new.txt\nD  old.txt\nMV  moved.txt\nSC  conflict.txt\n"
+	var found Attr
+	parsePijulDiff([]byte(out), &found)
+	c.Check(found, DeepEquals, Attr{
+		HasAddedFiles:    true,
+		AddedFiles:       1,
+		HasModifiedFiles: true,
+		ModifiedFiles:    2,
+		HasDeletedFiles:  true,
+		DeletedFiles:     1,
+		HasRenamedFiles:  true,
+		RenamedFiles:     1,
+	})
+
+	found = Attr{}
+	parsePijulDiff(nil, &found)
+	c.Check(found, DeepEquals, Attr{})
+}
+
+func (s *PijulSuite) TestParseTags(c *C) {
+	c.Check(parsePijulTags(nil), Equals, "")
+	out := "State ABCDEF\nAuthor: nobody\nDate: today\n\n    v1.1\n    details\n\nState 123456\n\n    v1.0\n"
+	c.Check(parsePijulTags([]byte(out)), Equals, "v1.1")
+}
+
+func (s *PijulSuite) TestChannel(c *C) {
+	pijulDir := filepath.Join(s.root, ".pijul")
+	c.Assert(os.Mkdir(pijulDir, 0755), IsNil)
+	c.Check(pijulChannel(pijulDir), Equals, "main")
+
+	config := "# comment\ncurrent_channel = \"feature\"\n\n[hooks]\nrecord = []\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(pijulDir, "config"), []byte(config), 0644), IsNil)
+	c.Check(pijulChannel(pijulDir), Equals, "feature")
+
+	config = "[hooks]\ncurrent_channel = \"feature\"\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(pijulDir, "config"), []byte(config), 0644), IsNil)
+	c.Check(pijulChannel(pijulDir), Equals, "main")
+}
+
+func (s *PijulSuite) TestPijul(c *C) {
+	if _, err := exec.LookPath("pijul"); err != nil {
+		c.Skip("pijul not installed")
+	}
+	s.pijul(c, "init")
+	c.Check(s.VCSInfoPijul(c), DeepEquals, Attr{VCS: VCSPijul, Branch: "main"})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "a.txt"), []byte("a\n"), 0644), IsNil)
+	s.pijul(c, "add", "a.txt")
+	c.Check(s.VCSInfoPijul(c), DeepEquals, Attr{VCS: VCSPijul, Branch: "main", IsDirty: true, AddedFiles: 1})
+
+	s.pijul(c, "record", "-a", "-m", "first", "--author", "nobody")
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "a.txt"), []byte("b\n"), 0644), IsNil)
+	c.Check(s.VCSInfoPijul(c), DeepEquals, Attr{VCS: VCSPijul, Branch: "main", IsDirty: true, ModifiedFiles: 1})
+}
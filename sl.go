@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Sapling support reuses Mercurial one: cheap facts are read from .sl
+// directly, tracked files status is detected using dirstate if it's in
+// v1 format (default treestate format isn't supported), everything else
+// is detected by executing sl (it has no hg-compatible command server).
+//
+// Branch contains active bookmark (Sapling has no named branches).
+// Remote-related facts are detected against main remote bookmark (see
+// slMainBookmark) as it was fetched by last pull. Shelves are reported as
+// stashed commits.
+
+// slAttrs are attributes supported by VCSInfoSl.
+var slAttrs = AttrList{
+	VCS:                 true,
+	RevisionShort:       true,
+	Branch:              true,
+	State:               true,
+	HasRemote:           true,
+	CommitsAheadRemote:  true,
+	CommitsBehindRemote: true,
+	HasStashedCommits:   true,
+	StashedCommits:      true,
+	IsDirty:             true,
+	HasAddedFiles:       true,
+	AddedFiles:          true,
+	HasModifiedFiles:    true,
+	ModifiedFiles:       true,
+	HasDeletedFiles:     true,
+	DeletedFiles:        true,
+	HasRenamedFiles:     true,
+	RenamedFiles:        true,
+	HasUnmergedFiles:    true,
+	UnmergedFiles:       true,
+	HasUntrackedFiles:   true,
+}
+
+// slRemoteTemplate (after fmt.Sprintf with remote bookmark name)
+// outputs one fact per line, in order expected by parseSlRemote.
+const slRemoteTemplate = `{revset("present(%%s)", %[1]q)|count}\n` +
+	`{revset("only(., present(%%s))", %[1]q)|count}\n` +
+	`{revset("only(present(%%s), .)", %[1]q)|count}\n`
+
+// VCSInfoSl returns sapling facts for current repo.
+func VCSInfoSl(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSSapling {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(slAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasUnmergedFiles = l.HasUnmergedFiles || l.UnmergedFiles
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.HasStashedCommits = l.HasStashedCommits || l.StashedCommits
+	l.HasRemote = l.HasRemote || l.CommitsAheadRemote || l.CommitsBehindRemote
+	l.VCS = true
+
+	slDir := filepath.Join(facts.Repo.Root, ".sl")
+	facts.Found.VCS = VCSSapling
+
+	if l.RevisionShort {
+		p1, _, err := hgDirstateParents(slDir)
+		if err != nil && !os.IsNotExist(err) { // dirstate is missing in empty repo
+			log.Println("sl dirstate:", err)
+		} else if !p1.isNull() {
+			facts.Found.RevisionShort = p1.short()
+		}
+	}
+	if l.Branch {
+		facts.Found.Branch = slBookmark(slDir)
+	}
+	if l.State {
+		facts.Found.State = hgState(slDir)
+	}
+	if l.HasStashedCommits {
+		facts.Found.StashedCommits = hgShelves(slDir)
+		facts.Found.HasStashedCommits = facts.Found.StashedCommits > 0
+		l.StashedCommits = true
+	}
+
+	sl := newSlClient(facts.Repo.Root)
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  AttrList{HasRemote: true, CommitsAheadRemote: true, CommitsBehindRemote: true},
+			detect: sl.with(slRemote),
+		},
+		{
+			attrs:  statusAttrs,
+			detect: sl.with(hgStatus),
+		},
+	})
+}
+
+// slBookmark returns active bookmark or empty string.
+func slBookmark(slDir string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(slDir, "bookmarks.current"))
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(buf))
+}
+
+// slMainBookmark returns name of remote bookmark which is pulled by
+// default (Sapling hoists default remote as "remote").
+func slMainBookmark(ctx context.Context, sl *hgClient, facts *Facts) string {
+	out, err := sl.run(ctx, facts, "config", "remotenames.selectivepulldefault")
+	if name := strings.TrimSpace(string(out)); err == nil && name != "" {
+		return "remote/" + name
+	}
+	return "remote/main"
+}
+
+func slRemote(ctx context.Context, sl *hgClient, facts *Facts) {
+	upstream := slMainBookmark(ctx, sl, facts)
+	if ctx.Err() != nil {
+		return
+	}
+	out, err := sl.run(ctx, facts, "log", "-r", ".", "-T", fmt.Sprintf(slRemoteTemplate, upstream))
+	if err == nil {
+		parseSlRemote(out, &facts.Found)
+		facts.Lookup.CommitsAheadRemote = true
+		facts.Lookup.CommitsBehindRemote = true
+	}
+}
+
+// parseSlRemote parses output of sl log with slRemoteTemplate.
+func parseSlRemote(out []byte, found *Attr) {
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < 3 {
+		log.Printf("sl log: unexpected output: %q", out)
+		return
+	}
+	found.HasRemote = lines[0] != "" && lines[0] != "0"
+	if found.HasRemote {
+		ahead, err1 := strconv.Atoi(lines[1])
+		behind, err2 := strconv.Atoi(lines[2])
+		if err1 != nil || err2 != nil {
+			log.Printf("sl log: unexpected output: %q", out)
+		}
+		found.CommitsAheadRemote = ahead
+		found.CommitsBehindRemote = behind
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SlSuite struct {
+	root  string
+	slDir string
+	req   Request
+}
+
+var _ = Suite(&SlSuite{})
+
+func (s *SlSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:            true,
+			RevisionShort:  true,
+			Branch:         true,
+			State:          true,
+			StashedCommits: true,
+		},
+	}
+	s.root = c.MkDir()
+	s.slDir = filepath.Join(s.root, ".sl")
+	c.Assert(os.Mkdir(s.slDir, 0755), IsNil)
+}
+
+func (s *SlSuite) VCSInfoSl(c *C) Attr {
+	facts := Facts{Repo: Repo{VCS: VCSSapling, Root: s.root}, Req: s.req}
+	VCSInfoSl(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *SlSuite) write(c *C, name string, data []byte) {
+	c.Assert(os.MkdirAll(filepath.Dir(filepath.Join(s.slDir, name)), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.slDir, name), data, 0644), IsNil)
+}
+
+func (s *SlSuite) TestParseRemote(c *C) {
+	cases := []struct {
+		out  string
+		want Attr
+	}{
+		{"", Attr{}},
+		{"0\n0\n", Attr{}},
+		{"0\n3\n0\n", Attr{}},
+		{"1\n0\n0\n", Attr{HasRemote: true}},
+		{"1\n3\n2\n", Attr{HasRemote: true, CommitsAheadRemote: 3, CommitsBehindRemote: 2}},
+	}
+	for _, v := range cases {
+		var found Attr
+		parseSlRemote([]byte(v.out), &found)
+		c.Check(found, DeepEquals, v.want, Commentf("%q", v.out))
+	}
+}
+
+func (s *SlSuite) TestRemoteTemplate(c *C) {
+	c.Check(fmt.Sprintf(slRemoteTemplate, "remote/main"), Equals,
+		`{revset("present(%s)", "remote/main")|count}\n`+
+			`{revset("only(., present(%s))", "remote/main")|count}\n`+
+			`{revset("only(present(%s), .)", "remote/main")|count}\n`)
+}
+
+func (s *SlSuite) TestSlEmpty(c *C) {
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{VCS: VCSSapling})
+}
+
+func (s *SlSuite) TestSlNative(c *C) {
+	s.write(c, "dirstate", append(testHgDirstateV1(testHgP1, hgNode{}, nil), hgTreestateMagic...))
+	s.write(c, "bookmarks.current", []byte("feature\n"))
+	s.write(c, "shelved/a.patch", nil)
+	s.write(c, "shelved/a.oshelve", nil)
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{
+		VCS:            VCSSapling,
+		RevisionShort:  "0123456789ab",
+		Branch:         "feature",
+		StashedCommits: 1,
+	})
+
+	s.req.Attr = AttrList{State: true}
+	s.write(c, "dirstate", testHgDirstateV1(testHgP1, testHgP2, nil))
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{State: StateMerge})
+	s.write(c, "rebasestate", nil)
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{State: StateRebase})
+}
+
+func (s *SlSuite) TestSlNativeStatus(c *C) {
+	s.req.Attr = AttrList{IsDirty: true, ModifiedFiles: true, DeletedFiles: true}
+	mtime := time.Unix(1500000000, 0)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(s.root, name)
+		c.Assert(ioutil.WriteFile(path, []byte("abc"), 0644), IsNil)
+		c.Assert(os.Chtimes(path, mtime, mtime), IsNil)
+	}
+	ts := int32(mtime.Unix())
+	entries := []testHgEntry{
+		{name: "a.txt", state: 'n', mode: 0100644, size: 3, mtime: ts},
+		{name: "b.txt", state: 'n', mode: 0100644, size: 3, mtime: ts},
+	}
+	s.write(c, "dirstate", testHgDirstateV1(testHgP1, hgNode{}, entries))
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "a.txt"), []byte("abcd"), 0644), IsNil)
+	c.Assert(os.Remove(filepath.Join(s.root, "b.txt")), IsNil)
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{IsDirty: true, ModifiedFiles: 1, DeletedFiles: 1})
+}
+
+func (s *SlSuite) TestSlStatus(c *C) {
+	if _, err := exec.LookPath("sl"); err != nil {
+		c.Skip("sl not installed")
+	}
+	c.Assert(os.Remove(s.slDir), IsNil)
+	cmd := exec.Command("sl", "init", "--git", s.root)
+	c.Assert(cmd.Run(), IsNil)
+	s.req.Attr = AttrList{IsDirty: true, AddedFiles: true, HasUntrackedFiles: true, CommitsAheadRemote: true}
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "a.txt"), nil, 0644), IsNil)
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{IsDirty: true, HasUntrackedFiles: true})
+	c.Assert(exec.Command("sl", "-R", s.root, "add", filepath.Join(s.root, "a.txt")).Run(), IsNil)
+	c.Check(s.VCSInfoSl(c), DeepEquals, Attr{IsDirty: true, AddedFiles: 1})
+}
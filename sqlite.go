@@ -0,0 +1,20 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+)
+
+// openSQLite opens existing SQLite database in read-only mode.
+func openSQLite(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err // avoid creating empty db by driver
+	}
+	u := url.URL{
+		Scheme:   "file",
+		Path:     path,
+		RawQuery: "mode=ro&" + sqliteBusyTimeout,
+	}
+	return sql.Open(sqliteDriver, u.String())
+}
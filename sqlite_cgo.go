@@ -0,0 +1,12 @@
+//go:build !purego
+
+package main
+
+import (
+	_ "github.com/mattn/go-sqlite3" // sqlite3 driver for database/sql
+)
+
+const (
+	sqliteDriver      = "sqlite3"
+	sqliteBusyTimeout = "_busy_timeout=100"
+)
@@ -0,0 +1,12 @@
+//go:build purego
+
+package main
+
+import (
+	_ "modernc.org/sqlite" // pure Go sqlite driver for database/sql
+)
+
+const (
+	sqliteDriver      = "sqlite"
+	sqliteBusyTimeout = "_pragma=busy_timeout(100)"
+)
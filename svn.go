@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Subversion (1.8+) support is based on reading working copy database
+// .svn/wc.db directly, without executing svn.
+//
+// RevisionShort is a revision of working copy root (mixed-revision
+// working copies aren't detected). Branch and Tag are detected using
+// standard repository layout (trunk, branches/NAME, tags/NAME).
+//
+// Status is detected in a way similar to `svn status`:
+// - replaced files are reported as modified
+// - moved files are reported as renamed
+// - property changes are reported as modified
+// - missing files are reported as deleted
+// - tree, text and property conflicts are reported as unmerged
+// Directories make repo dirty but aren't counted.
+// Files with content translation (svn:keywords, svn:eol-style,
+// svn:special) are compared with pristine by size only.
+// Only svn:ignore, svn:global-ignores and default global-ignores are
+// used to detect unversioned files (user's config is ignored).
+
+// svnAttrs are attributes supported by VCSInfoSvn.
+var svnAttrs = AttrList{
+	VCS:               true,
+	RevisionShort:     true,
+	Branch:            true,
+	Tag:               true,
+	IsDirty:           true,
+	HasAddedFiles:     true,
+	AddedFiles:        true,
+	HasModifiedFiles:  true,
+	ModifiedFiles:     true,
+	HasDeletedFiles:   true,
+	DeletedFiles:      true,
+	HasRenamedFiles:   true,
+	RenamedFiles:      true,
+	HasUnmergedFiles:  true,
+	UnmergedFiles:     true,
+	HasUntrackedFiles: true,
+}
+
+// svnDefaultGlobalIgnores is default value of miscellany.global-ignores.
+var svnDefaultGlobalIgnores = strings.Fields("*.o *.lo *.la *.al .libs *.so *.so.[0-9]* *.a *.pyc *.pyo __pycache__ *.rej *~ #*# .#* .*.swp .DS_Store [Tt]humbs.db")
+
+var errSvnSkel = errors.New("svn: bad skel")
+
+// VCSInfoSvn returns subversion facts for current repo.
+func VCSInfoSvn(ctx context.Context, facts *Facts) {
+	if facts.Repo.VCS != VCSSubversion {
+		return
+	}
+
+	// Honor requested attributes.
+	facts.Lookup = facts.Req.Attr.Without(facts.Req.Attr.Without(svnAttrs))
+	l := &facts.Lookup
+
+	// Needs to lookup for dependent attributes too.
+	l.HasUnmergedFiles = l.HasUnmergedFiles || l.UnmergedFiles
+	l.HasRenamedFiles = l.HasRenamedFiles || l.RenamedFiles
+	l.HasDeletedFiles = l.HasDeletedFiles || l.DeletedFiles
+	l.HasModifiedFiles = l.HasModifiedFiles || l.ModifiedFiles
+	l.HasAddedFiles = l.HasAddedFiles || l.AddedFiles
+	l.VCS = true
+
+	facts.Found.VCS = VCSSubversion
+
+	db, err := openSQLite(filepath.Join(facts.Repo.Root, ".svn", "wc.db"))
+	if err != nil {
+		log.Println("svn wc.db:", err)
+		return
+	}
+	defer db.Close()
+
+	detectGroups(ctx, facts, []factGroup{
+		{
+			attrs:  AttrList{RevisionShort: true, Branch: true, Tag: true},
+			detect: withSvnDB(db, svnInfo),
+		},
+		{
+			attrs:  statusAttrs,
+			detect: withSvnDB(db, svnStatus),
+		},
+	})
+}
+
+// withSvnDB provides detect with shared db (which is safe for
+// concurrent use).
+func withSvnDB(db *sql.DB, detect func(context.Context, *sql.DB, *Facts)) func(context.Context, *Facts) {
+	return func(ctx context.Context, facts *Facts) {
+		detect(ctx, db, facts)
+	}
+}
+
+func svnInfo(ctx context.Context, db *sql.DB, facts *Facts) {
+	var rev sql.NullInt64
+	var reposPath sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT revision, repos_path FROM nodes
+		WHERE local_relpath = '' AND op_depth = 0`).Scan(&rev, &reposPath)
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return
+	} else if err != nil {
+		log.Println("svn info:", err)
+		return
+	}
+	l := &facts.Lookup
+	if l.RevisionShort && rev.Valid {
+		facts.Found.RevisionShort = strconv.FormatInt(rev.Int64, 10)
+	}
+	branch, tag := svnBranch(reposPath.String)
+	if l.Branch {
+		facts.Found.Branch = branch
+	}
+	if l.Tag {
+		facts.Found.Tag = tag
+	}
+}
+
+// svnBranch returns branch or tag name for path inside repository
+// using standard layout.
+func svnBranch(reposPath string) (branch, tag string) {
+	parts := strings.Split(reposPath, "/")
+	for i, part := range parts {
+		switch {
+		case part == "trunk":
+			return "trunk", ""
+		case part == "branches" && i+1 < len(parts):
+			return parts[i+1], ""
+		case part == "tags" && i+1 < len(parts):
+			return "", parts[i+1]
+		}
+	}
+	return "", ""
+}
+
+// svnNode is a working (top-most) layer of node with some facts about
+// lower layers.
+type svnNode struct {
+	opDepth    int
+	presence   string
+	kind       string
+	movedHere  bool
+	movedAway  bool // some lower layer was moved
+	hasBase    bool // some lower layer exists
+	size       sql.NullInt64
+	mtime      sql.NullInt64 // in microseconds
+	checksum   sql.NullString
+	props      []byte
+	propsMod   bool
+	conflicted bool
+}
+
+// svnDepth returns op_depth of working operation rooted at relpath.
+func svnDepth(relpath string) int {
+	if relpath == "" {
+		return 0
+	}
+	return strings.Count(relpath, "/") + 1
+}
+
+func (n *svnNode) isVersioned() bool {
+	return n.presence == "normal" || n.presence == "incomplete"
+}
+
+func svnStatus(ctx context.Context, db *sql.DB, facts *Facts) {
+	nodes, err := svnReadNodes(ctx, db)
+	if ctx.Err() != nil {
+		facts.TimedOut = facts.Lookup
+		return
+	} else if err != nil {
+		log.Println("svn status:", err)
+		return
+	}
+
+	root := facts.Repo.Root
+	found := &facts.Found
+	for relpath, n := range nodes {
+		if ctx.Err() != nil {
+			facts.TimedOut = facts.Lookup
+			facts.Found = Attr{}
+			return
+		}
+		if relpath == "" && !n.propsMod && !n.conflicted {
+			continue
+		}
+		// Children of copied/moved dir are reported by their op root,
+		// but they may be changed after copy like base nodes.
+		op := n.opDepth > 0 && !(n.isVersioned() && n.opDepth < svnDepth(relpath))
+		count := func(counter *int) {
+			if n.kind == "dir" {
+				found.IsDirty = true
+			} else {
+				*counter++
+			}
+		}
+		switch {
+		case n.conflicted:
+			count(&found.UnmergedFiles)
+		case op && n.isVersioned() && n.movedHere:
+			count(&found.RenamedFiles)
+		case op && n.isVersioned() && n.hasBase:
+			count(&found.ModifiedFiles)
+		case op && n.isVersioned():
+			count(&found.AddedFiles)
+		case op && !n.movedAway:
+			count(&found.DeletedFiles)
+		case op:
+		case !n.isVersioned():
+		case svnIsMissing(root, relpath):
+			count(&found.DeletedFiles)
+		case n.propsMod || svnIsModified(root, relpath, n):
+			count(&found.ModifiedFiles)
+		}
+	}
+	found.HasAddedFiles = found.AddedFiles > 0
+	found.HasModifiedFiles = found.ModifiedFiles > 0
+	found.HasDeletedFiles = found.DeletedFiles > 0
+	found.HasRenamedFiles = found.RenamedFiles > 0
+	found.HasUnmergedFiles = found.UnmergedFiles > 0
+
+	l := &facts.Lookup
+	if l.HasUntrackedFiles || (l.IsDirty && facts.Req.DirtyIfUntracked) {
+		found.HasUntrackedFiles = svnHasUnversioned(root, "", nodes, svnDefaultGlobalIgnores)
+		l.HasUntrackedFiles = true
+	}
+	// These counters are detected anyway.
+	l.HasAddedFiles, l.AddedFiles = true, true
+	l.HasModifiedFiles, l.ModifiedFiles = true, true
+	l.HasDeletedFiles, l.DeletedFiles = true, true
+	l.HasRenamedFiles, l.RenamedFiles = true, true
+	l.HasUnmergedFiles, l.UnmergedFiles = true, true
+}
+
+// svnReadNodes returns working layer of all nodes by local_relpath.
+func svnReadNodes(ctx context.Context, db *sql.DB) (map[string]*svnNode, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT local_relpath, op_depth, presence, kind,
+			moved_here IS NOT NULL AND moved_here != 0,
+			moved_to IS NOT NULL,
+			translated_size, last_mod_time, checksum, properties
+		FROM nodes ORDER BY local_relpath, op_depth`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	nodes := make(map[string]*svnNode)
+	for rows.Next() {
+		var relpath string
+		var n svnNode
+		err = rows.Scan(&relpath, &n.opDepth, &n.presence, &n.kind,
+			&n.movedHere, &n.movedAway,
+			&n.size, &n.mtime, &n.checksum, &n.props)
+		if err != nil {
+			return nil, err
+		}
+		if lower := nodes[relpath]; lower != nil {
+			n.movedAway = n.movedAway || lower.movedAway
+			n.hasBase = lower.hasBase || lower.isVersioned()
+		}
+		nodes[relpath] = &n
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT local_relpath, properties,
+			conflict_data IS NOT NULL OR tree_conflict_data IS NOT NULL OR
+			conflict_old IS NOT NULL OR conflict_new IS NOT NULL OR
+			conflict_working IS NOT NULL OR prop_reject IS NOT NULL
+		FROM actual_node`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var relpath string
+		var props []byte
+		var conflicted bool
+		if err = rows.Scan(&relpath, &props, &conflicted); err != nil {
+			return nil, err
+		}
+		n := nodes[relpath]
+		if n == nil && !conflicted {
+			continue
+		} else if n == nil { // tree conflict on unversioned node
+			n = &svnNode{presence: "normal"}
+			nodes[relpath] = n
+		}
+		n.conflicted = conflicted
+		if props != nil {
+			n.propsMod = !bytes.Equal(props, n.props)
+			n.props = props
+		}
+	}
+	return nodes, rows.Err()
+}
+
+func svnIsMissing(root, relpath string) bool {
+	_, err := os.Lstat(filepath.Join(root, filepath.FromSlash(relpath)))
+	return os.IsNotExist(err)
+}
+
+// svnIsModified compares file in working copy with pristine.
+func svnIsModified(root, relpath string, n *svnNode) bool {
+	if n.kind != "file" {
+		return false
+	}
+	name := filepath.Join(root, filepath.FromSlash(relpath))
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return true
+	}
+	sizeMatch := n.size.Valid && n.size.Int64 >= 0 && fi.Size() == n.size.Int64
+	if n.size.Valid && n.size.Int64 >= 0 && !sizeMatch {
+		return true
+	}
+	if sizeMatch && n.mtime.Valid && fi.ModTime().UnixNano()/1000 == n.mtime.Int64 {
+		return false
+	}
+
+	props, err := parseSvnProps(n.props)
+	if err != nil {
+		log.Printf("svn props %s: %s", relpath, err)
+	}
+	for _, name := range []string{"svn:keywords", "svn:eol-style", "svn:special"} {
+		if _, ok := props[name]; ok {
+			return !sizeMatch
+		}
+	}
+	const prefix = "$sha1$"
+	if !strings.HasPrefix(n.checksum.String, prefix) {
+		return true
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return true
+	}
+	return hex.EncodeToString(h.Sum(nil)) != n.checksum.String[len(prefix):]
+}
+
+// svnHasUnversioned returns true if versioned dir (relative to root)
+// or it's versioned subdirs contain unversioned not ignored files.
+// Global is a list of inherited global ignore patterns.
+func svnHasUnversioned(root, dir string, nodes map[string]*svnNode, global []string) bool {
+	var ignores []string
+	if n := nodes[dir]; n != nil {
+		props, err := parseSvnProps(n.props)
+		if err != nil {
+			log.Printf("svn props %s: %s", dir, err)
+		}
+		global = append(global[:len(global):len(global)], strings.Fields(props["svn:global-ignores"])...)
+		ignores = append(strings.Fields(props["svn:ignore"]), global...)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+	if err != nil {
+		return false
+	}
+	for _, fi := range files {
+		if dir == "" && fi.Name() == ".svn" {
+			continue
+		}
+		relpath := path.Join(dir, fi.Name())
+		n := nodes[relpath]
+		switch {
+		case n == nil && svnIsIgnored(fi.Name(), ignores):
+		case n == nil:
+			return true
+		case n.kind == "dir" && n.isVersioned():
+			if svnHasUnversioned(root, relpath, nodes, global) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func svnIsIgnored(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSvnProps parses properties serialized as a skel: list of
+// alternating names and values.
+func parseSvnProps(skel []byte) (map[string]string, error) {
+	props := make(map[string]string)
+	skel = bytes.TrimSpace(skel)
+	if len(skel) == 0 {
+		return props, nil
+	}
+	if len(skel) < 2 || skel[0] != '(' || skel[len(skel)-1] != ')' {
+		return props, errSvnSkel
+	}
+	var atoms []string
+	for buf := skel[1 : len(skel)-1]; ; {
+		buf = bytes.TrimLeft(buf, " \t\n\r\f")
+		if len(buf) == 0 {
+			break
+		}
+		var atom []byte
+		switch c := buf[0]; {
+		case '0' <= c && c <= '9': // explicit atom: "LEN DATA"
+			i := bytes.IndexAny(buf, " \t\n\r\f")
+			if i < 0 {
+				return props, errSvnSkel
+			}
+			size, err := strconv.Atoi(string(buf[:i]))
+			if err != nil || i+1+size > len(buf) {
+				return props, errSvnSkel
+			}
+			atom, buf = buf[i+1:i+1+size], buf[i+1+size:]
+		case c == '(' || c == ')':
+			return props, errSvnSkel
+		default: // implicit atom
+			i := bytes.IndexAny(buf, " \t\n\r\f()")
+			if i < 0 {
+				i = len(buf)
+			}
+			atom, buf = buf[:i], buf[i:]
+		}
+		atoms = append(atoms, string(atom))
+	}
+	if len(atoms)%2 != 0 {
+		return props, errSvnSkel
+	}
+	for i := 0; i < len(atoms); i += 2 {
+		props[atoms[i]] = atoms[i+1]
+	}
+	return props, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// testSvnSchema is a subset of wc.db schema used by VCSInfoSvn.
+const testSvnSchema = `
+CREATE TABLE NODES (
+	wc_id INTEGER NOT NULL, local_relpath TEXT NOT NULL,
+	op_depth INTEGER NOT NULL, parent_relpath TEXT,
+	repos_id INTEGER, repos_path TEXT, revision INTEGER,
+	presence TEXT NOT NULL, moved_here INTEGER, moved_to TEXT,
+	kind TEXT NOT NULL, properties BLOB, checksum TEXT,
+	translated_size INTEGER, last_mod_time INTEGER,
+	PRIMARY KEY (wc_id, local_relpath, op_depth));
+CREATE TABLE ACTUAL_NODE (
+	wc_id INTEGER NOT NULL, local_relpath TEXT NOT NULL,
+	parent_relpath TEXT, properties BLOB,
+	conflict_old TEXT, conflict_new TEXT, conflict_working TEXT,
+	prop_reject TEXT, changelist TEXT, text_mod TEXT,
+	tree_conflict_data TEXT, conflict_data BLOB,
+	PRIMARY KEY (wc_id, local_relpath));
+`
+
+type SvnSuite struct {
+	root  string
+	db    *sql.DB
+	req   Request
+	mtime time.Time
+}
+
+var _ = Suite(&SvnSuite{})
+
+func (s *SvnSuite) SetUpTest(c *C) {
+	s.req = Request{
+		Attr: AttrList{
+			VCS:               true,
+			RevisionShort:     true,
+			Branch:            true,
+			Tag:               true,
+			IsDirty:           true,
+			AddedFiles:        true,
+			ModifiedFiles:     true,
+			DeletedFiles:      true,
+			RenamedFiles:      true,
+			UnmergedFiles:     true,
+			HasUntrackedFiles: true,
+		},
+	}
+	s.mtime = time.Unix(1500000000, 123456000)
+	s.root = c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(s.root, ".svn"), 0755), IsNil)
+	var err error
+	s.db, err = sql.Open(sqliteDriver, filepath.Join(s.root, ".svn", "wc.db"))
+	c.Assert(err, IsNil)
+	_, err = s.db.Exec(testSvnSchema)
+	c.Assert(err, IsNil)
+	s.node(c, "", 0, "normal", "dir", nil)
+	_, err = s.db.Exec(`UPDATE nodes SET repos_path = 'project/trunk', revision = 42`)
+	c.Assert(err, IsNil)
+}
+
+func (s *SvnSuite) TearDownTest(c *C) {
+	c.Assert(s.db.Close(), IsNil)
+}
+
+func (s *SvnSuite) VCSInfoSvn(c *C) Attr {
+	facts := Facts{Repo: Repo{VCS: VCSSubversion, Root: s.root}, Req: s.req}
+	VCSInfoSvn(context.Background(), &facts)
+	facts.QA()
+	return facts.Result()
+}
+
+func (s *SvnSuite) node(c *C, relpath string, opDepth int, presence, kind string, props []byte) {
+	_, err := s.db.Exec(`INSERT INTO nodes (wc_id, local_relpath, op_depth, presence, kind, properties) VALUES (1, ?, ?, ?, ?, ?)`,
+		relpath, opDepth, presence, kind, props)
+	c.Assert(err, IsNil)
+}
+
+func (s *SvnSuite) exec(c *C, query string, args ...interface{}) {
+	_, err := s.db.Exec(query, args...)
+	c.Assert(err, IsNil)
+}
+
+// file creates versioned unmodified file.
+func (s *SvnSuite) file(c *C, relpath, content string) {
+	name := filepath.Join(s.root, filepath.FromSlash(relpath))
+	c.Assert(ioutil.WriteFile(name, []byte(content), 0644), IsNil)
+	c.Assert(os.Chtimes(name, s.mtime, s.mtime), IsNil)
+	sum := sha1.Sum([]byte(content))
+	_, err := s.db.Exec(`INSERT INTO nodes (wc_id, local_relpath, op_depth, presence, kind, checksum, translated_size, last_mod_time) VALUES (1, ?, 0, 'normal', 'file', ?, ?, ?)`,
+		relpath, "$sha1$"+hex.EncodeToString(sum[:]), len(content), s.mtime.UnixNano()/1000)
+	c.Assert(err, IsNil)
+}
+
+func (s *SvnSuite) TestBranch(c *C) {
+	cases := []struct {
+		path, branch, tag string
+	}{
+		{"", "", ""},
+		{"trunk", "trunk", ""},
+		{"project/trunk/src", "trunk", ""},
+		{"branches/feature", "feature", ""},
+		{"project/branches/feature/src", "feature", ""},
+		{"project/branches", "", ""},
+		{"tags/v1.0/src", "", "v1.0"},
+		{"project/src", "", ""},
+	}
+	for _, v := range cases {
+		branch, tag := svnBranch(v.path)
+		c.Check(branch, Equals, v.branch, Commentf("%q", v.path))
+		c.Check(tag, Equals, v.tag, Commentf("%q", v.path))
+	}
+}
+
+func (s *SvnSuite) TestParseProps(c *C) {
+	cases := []struct {
+		skel    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"()", map[string]string{}, false},
+		{"(svn:eol-style 6 native)", map[string]string{"svn:eol-style": "native"}, false},
+		{"(svn:ignore 10 *.o\nbuild\n x 1 y)", map[string]string{"svn:ignore": "*.o\nbuild\n", "x": "y"}, false},
+		{"(4 a(b) 0 )", map[string]string{"a(b)": ""}, false},
+		{"(svn:ignore)", map[string]string{}, true},
+		{"(x 10 y)", map[string]string{}, true},
+		{"(x (y))", map[string]string{}, true},
+		{"x y", map[string]string{}, true},
+	}
+	for _, v := range cases {
+		props, err := parseSvnProps([]byte(v.skel))
+		c.Check(err != nil, Equals, v.wantErr, Commentf("%q", v.skel))
+		c.Check(props, DeepEquals, v.want, Commentf("%q", v.skel))
+	}
+}
+
+func (s *SvnSuite) TestSvnNoRepo(c *C) {
+	c.Assert(os.Remove(filepath.Join(s.root, ".svn", "wc.db")), IsNil)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{VCS: VCSSubversion})
+	_, err := os.Stat(filepath.Join(s.root, ".svn", "wc.db"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SvnSuite) TestSvnInfo(c *C) {
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{
+		VCS:           VCSSubversion,
+		RevisionShort: "42",
+		Branch:        "trunk",
+	})
+	s.exec(c, `UPDATE nodes SET repos_path = 'tags/v1.0'`)
+	s.req.Attr = AttrList{Tag: true}
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{Tag: "v1.0"})
+}
+
+func (s *SvnSuite) TestSvnClean(c *C) {
+	s.file(c, "a.txt", "a")
+	c.Assert(os.Mkdir(filepath.Join(s.root, "dir"), 0755), IsNil)
+	s.node(c, "dir", 0, "normal", "dir", nil)
+	s.file(c, "dir/b.txt", "b")
+	s.node(c, "gone.txt", 0, "not-present", "file", nil)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{
+		VCS:           VCSSubversion,
+		RevisionShort: "42",
+		Branch:        "trunk",
+	})
+}
+
+func (s *SvnSuite) TestSvnStatus(c *C) {
+	s.req.Attr = AttrList{IsDirty: true, AddedFiles: true, ModifiedFiles: true, DeletedFiles: true, RenamedFiles: true, UnmergedFiles: true}
+	for _, name := range []string{"mod.txt", "touch.txt", "resize.txt", "missing.txt", "del.txt", "old.txt", "repl.txt", "prop.txt", "conflict.txt"} {
+		s.file(c, name, name)
+	}
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "mod.txt"), []byte("MOD.TXT"), 0644), IsNil)
+	now := time.Now()
+	c.Assert(os.Chtimes(filepath.Join(s.root, "touch.txt"), now, now), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "resize.txt"), []byte("resized"), 0644), IsNil)
+	c.Assert(os.Remove(filepath.Join(s.root, "missing.txt")), IsNil)
+	c.Assert(os.Remove(filepath.Join(s.root, "del.txt")), IsNil)
+	s.node(c, "del.txt", 1, "base-deleted", "file", nil)
+	c.Assert(os.Rename(filepath.Join(s.root, "old.txt"), filepath.Join(s.root, "new.txt")), IsNil)
+	s.node(c, "old.txt", 1, "base-deleted", "file", nil)
+	s.exec(c, `UPDATE nodes SET moved_to = 'new.txt' WHERE local_relpath = 'old.txt' AND op_depth = 1`)
+	s.node(c, "new.txt", 1, "normal", "file", nil)
+	s.exec(c, `UPDATE nodes SET moved_here = 1 WHERE local_relpath = 'new.txt'`)
+	s.node(c, "repl.txt", 1, "normal", "file", nil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "add.txt"), nil, 0644), IsNil)
+	s.node(c, "add.txt", 1, "normal", "file", nil)
+	c.Assert(os.Mkdir(filepath.Join(s.root, "adddir"), 0755), IsNil)
+	s.node(c, "adddir", 1, "normal", "dir", nil)
+	s.exec(c, `INSERT INTO actual_node (wc_id, local_relpath, properties) VALUES (1, 'prop.txt', '(x 1 y)')`)
+	s.exec(c, `INSERT INTO actual_node (wc_id, local_relpath, changelist) VALUES (1, 'a.txt', 'cl')`)
+	s.exec(c, `INSERT INTO actual_node (wc_id, local_relpath, conflict_data) VALUES (1, 'conflict.txt', '()')`)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{
+		IsDirty:       true,
+		AddedFiles:    1,
+		ModifiedFiles: 4,
+		DeletedFiles:  2,
+		RenamedFiles:  1,
+		UnmergedFiles: 1,
+	})
+}
+
+func (s *SvnSuite) TestSvnDirtyDir(c *C) {
+	s.req.Attr = AttrList{IsDirty: true, AddedFiles: true}
+	c.Assert(os.Mkdir(filepath.Join(s.root, "adddir"), 0755), IsNil)
+	s.node(c, "adddir", 1, "normal", "dir", nil)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{IsDirty: true})
+}
+
+func (s *SvnSuite) TestSvnCopiedDir(c *C) {
+	s.req.Attr = AttrList{IsDirty: true, AddedFiles: true, ModifiedFiles: true}
+	c.Assert(os.Mkdir(filepath.Join(s.root, "cpdir"), 0755), IsNil)
+	s.node(c, "cpdir", 1, "normal", "dir", nil)
+	s.file(c, "cpdir/a.txt", "a")
+	s.file(c, "cpdir/b.txt", "b")
+	s.exec(c, `UPDATE nodes SET op_depth = 1 WHERE local_relpath LIKE 'cpdir/%'`)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{IsDirty: true})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "cpdir", "b.txt"), []byte("B"), 0644), IsNil)
+	s.file(c, "cpdir/new.txt", "new")
+	s.exec(c, `UPDATE nodes SET op_depth = 2 WHERE local_relpath = 'cpdir/new.txt'`)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{IsDirty: true, AddedFiles: 1, ModifiedFiles: 1})
+}
+
+func (s *SvnSuite) TestSvnTranslated(c *C) {
+	s.req.Attr = AttrList{ModifiedFiles: true}
+	s.file(c, "kw.txt", "$Id$")
+	s.exec(c, `UPDATE nodes SET properties = '(svn:keywords 2 Id)' WHERE local_relpath = 'kw.txt'`)
+	name := filepath.Join(s.root, "kw.txt")
+	c.Assert(ioutil.WriteFile(name, []byte("$Id: x"), 0644), IsNil)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{ModifiedFiles: 1})
+	s.exec(c, `UPDATE nodes SET translated_size = 6 WHERE local_relpath = 'kw.txt'`)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{})
+}
+
+func (s *SvnSuite) TestSvnUnversioned(c *C) {
+	s.req.Attr = AttrList{HasUntrackedFiles: true}
+	s.file(c, "a.txt", "a")
+	c.Assert(os.Mkdir(filepath.Join(s.root, "dir"), 0755), IsNil)
+	s.node(c, "dir", 0, "normal", "dir", []byte("(svn:ignore 6 build\n)"))
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{})
+
+	for _, name := range []string{"a.o", "dir/build", "dir/x.pyc"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(s.root, name), nil, 0644), IsNil)
+	}
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "build"), nil, 0644), IsNil)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{HasUntrackedFiles: true})
+	c.Assert(os.Remove(filepath.Join(s.root, "build")), IsNil)
+
+	s.exec(c, `UPDATE nodes SET properties = '(svn:global-ignores 5 *.tmp)' WHERE local_relpath = ''`)
+	c.Assert(os.Mkdir(filepath.Join(s.root, "dir", "sub"), 0755), IsNil)
+	s.node(c, "dir/sub", 0, "normal", "dir", nil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "dir", "sub", "x.tmp"), nil, 0644), IsNil)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{})
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "dir", "sub", "x.txt"), nil, 0644), IsNil)
+	c.Check(s.VCSInfoSvn(c), DeepEquals, Attr{HasUntrackedFiles: true})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode/utf8"
+)
+
+// Templates use Go text/template syntax with Attr as data, e.g.:
+//
+//	{{.VCS}}:{{.Branch}}{{if .IsDirty}}*{{end}}
+//	{{if eq .State.String "merge"}}…{{end}}
+//
+// Additional functions:
+//	color "bold red" TEXT    wrap TEXT into ANSI escape sequences
+//	zcolor "bold red" TEXT   same, with escapes marked as zero-width for zsh %{…%}
+//	bcolor "bold red" TEXT   same, with escapes marked as zero-width for bash
+//	                         (\001…\002, because \[…\] isn't interpreted in
+//	                         output of command substitution)
+//	trunc N TEXT             truncate TEXT to N runes (including "…")
+//	plural N ONE MANY        ONE if N is 1, MANY otherwise
+//	approx NAME              true if attribute NAME was detected approximately
+
+var templateFuncs = template.FuncMap{
+	"color":  templateColor("", ""),
+	"zcolor": templateColor("%{", "%}"),
+	"bcolor": templateColor("\x01", "\x02"),
+	"trunc":  templateTrunc,
+	"plural": templatePlural,
+	"approx": templateApprox(AttrList{}),
+}
+
+var ansiColors = map[string]int{
+	"reset":     0,
+	"bold":      1,
+	"dim":       2,
+	"italic":    3,
+	"underline": 4,
+	"reverse":   7,
+	"black":     30,
+	"red":       31,
+	"green":     32,
+	"yellow":    33,
+	"blue":      34,
+	"magenta":   35,
+	"cyan":      36,
+	"white":     37,
+}
+
+// ParseTemplate parses text/template.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("vcprompt-fast").Funcs(templateFuncs).Parse(text)
+}
+
+// ExecuteTemplate applies t to result of facts.
+func ExecuteTemplate(w io.Writer, t *template.Template, facts Facts) error {
+	t.Funcs(template.FuncMap{"approx": templateApprox(facts.Approx)})
+	return t.Execute(w, facts.Result())
+}
+
+// TemplateAttrs returns attributes used by t.
+// If t use whole Attr (as {{.}}) then all attributes will be returned.
+func TemplateAttrs(t *template.Template) AttrList {
+	l := AttrList{VCS: true}
+	v := reflect.ValueOf(&l).Elem()
+	need := func(name string) {
+		if f := v.FieldByName(name); f.IsValid() {
+			f.SetBool(true)
+		}
+	}
+	all := false
+	// isAttr is true while dot is Attr (i.e. outside of range and with).
+	var walk func(node parse.Node, isAttr bool)
+	walk = func(node parse.Node, isAttr bool) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			if node != nil {
+				for _, n := range node.Nodes {
+					walk(n, isAttr)
+				}
+			}
+		case *parse.ActionNode:
+			walk(node.Pipe, isAttr)
+		case *parse.IfNode:
+			walk(node.Pipe, isAttr)
+			walk(node.List, isAttr)
+			walk(node.ElseList, isAttr)
+		case *parse.RangeNode:
+			walk(node.Pipe, isAttr)
+			walk(node.List, false)
+			walk(node.ElseList, isAttr)
+		case *parse.WithNode:
+			walk(node.Pipe, isAttr)
+			walk(node.List, false)
+			walk(node.ElseList, isAttr)
+		case *parse.TemplateNode:
+			walk(node.Pipe, isAttr)
+		case *parse.PipeNode:
+			if node != nil {
+				for _, cmd := range node.Cmds {
+					walk(cmd, isAttr)
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range node.Args {
+				walk(arg, isAttr)
+			}
+		case *parse.ChainNode:
+			walk(node.Node, isAttr)
+		case *parse.FieldNode:
+			if isAttr {
+				need(node.Ident[0])
+			}
+		case *parse.VariableNode:
+			switch {
+			case node.Ident[0] != "$":
+			case len(node.Ident) > 1:
+				need(node.Ident[1])
+			default:
+				all = true
+			}
+		case *parse.DotNode:
+			all = all || isAttr
+		}
+	}
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			walk(tmpl.Tree.Root, true)
+		}
+	}
+	if all {
+		return allAttrs()
+	}
+	return l
+}
+
+// templateColor returns color func which wraps escape sequences into
+// start and end to mark them as zero-width for shell prompt.
+func templateColor(start, end string) func(colors, text string) (string, error) {
+	return func(colors, text string) (string, error) {
+		var codes []string
+		for _, name := range strings.Fields(colors) {
+			code, ok := ansiColors[name]
+			if !ok {
+				return "", fmt.Errorf("unknown color: %q", name)
+			}
+			codes = append(codes, fmt.Sprint(code))
+		}
+		if len(codes) == 0 || text == "" {
+			return text, nil
+		}
+		return start + "\x1b[" + strings.Join(codes, ";") + "m" + end + text + start + "\x1b[0m" + end, nil
+	}
+}
+
+func templateTrunc(n int, text string) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:n-1]) + "…"
+}
+
+func templateApprox(approx AttrList) func(string) (bool, error) {
+	return func(name string) (bool, error) {
+		f := reflect.ValueOf(approx).FieldByName(name)
+		if !f.IsValid() {
+			return false, fmt.Errorf("unknown attribute: %q", name)
+		}
+		return f.Bool(), nil
+	}
+}
+
+func templatePlural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
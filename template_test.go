@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type TemplateSuite struct{}
+
+var _ = Suite(&TemplateSuite{})
+
+func (s *TemplateSuite) TestAttrs(c *C) {
+	cases := []struct {
+		text string
+		want AttrList
+	}{
+		{"", AttrList{}},
+		{"{{.Branch}}", AttrList{Branch: true}},
+		{"{{if .IsDirty}}*{{else if .HasUntrackedFiles}}?{{end}}", AttrList{IsDirty: true, HasUntrackedFiles: true}},
+		{`{{if eq .State.String "merge"}}M{{end}}`, AttrList{State: true}},
+		{"{{with .Tag}}{{.}} {{$.RevisionShort}}{{end}}", AttrList{Tag: true, RevisionShort: true}},
+		{"{{range $i, $_ := .Branch}}{{.}}{{end}}", AttrList{Branch: true}},
+		{`{{plural .ModifiedFiles "file" "files" | printf "%s"}}`, AttrList{ModifiedFiles: true}},
+	}
+	for _, v := range cases {
+		tmpl, err := ParseTemplate(v.text)
+		c.Assert(err, IsNil, Commentf("%q", v.text))
+		v.want.VCS = true
+		c.Check(TemplateAttrs(tmpl), DeepEquals, v.want, Commentf("%q", v.text))
+	}
+
+	for _, text := range []string{"{{.}}", "{{printf `%v` $}}", `{{define "x"}}{{.StashedCommits}}{{end}}{{template "x" .}}`} {
+		tmpl, err := ParseTemplate(text)
+		c.Assert(err, IsNil)
+		l := TemplateAttrs(tmpl)
+		c.Check(strings.Count(l.String(), ",")+1, Equals, reflect.TypeOf(Attr{}).NumField(), Commentf("%q", text))
+	}
+}
+
+func (s *TemplateSuite) TestExecute(c *C) {
+	res := Attr{
+		VCS:              VCSGit,
+		Branch:           "feature/very-long-name",
+		State:            StateRebaseInteractive,
+		HasModifiedFiles: true,
+		ModifiedFiles:    1,
+		AddedFiles:       2,
+	}
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"{{.VCS}}:{{.Branch}}", "git:feature/very-long-name"},
+		{"{{.VCS.String}} {{.State}} {{.State.String}}", "git rebase-i rebase-i"},
+		{`{{if eq .State.String "rebase-i"}}REBASE{{end}}`, "REBASE"},
+		{"{{trunc 10 .Branch}}|{{trunc 30 .Branch}}|{{trunc 0 .Branch}}", "feature/v…|feature/very-long-name|"},
+		{`{{.ModifiedFiles}} {{plural .ModifiedFiles "file" "files"}}`, "1 file"},
+		{`{{.AddedFiles}} {{plural .AddedFiles "file" "files"}}`, "2 files"},
+		{`{{color "red" .VCS.String}}`, "\x1b[31mgit\x1b[0m"},
+		{`{{color "bold green" "x"}}{{color "red" .Tag}}{{color "" "y"}}`, "\x1b[1;32mx\x1b[0my"},
+		{`{{zcolor "red" .VCS.String}}{{zcolor "" "y"}}`, "%{\x1b[31m%}git%{\x1b[0m%}y"},
+		{`{{bcolor "red" .VCS.String}}{{bcolor "red" .Tag}}`, "\x01\x1b[31m\x02git\x01\x1b[0m\x02"},
+	}
+	for _, v := range cases {
+		tmpl, err := ParseTemplate(v.text)
+		c.Assert(err, IsNil, Commentf("%q", v.text))
+		var buf strings.Builder
+		c.Check(tmpl.Execute(&buf, res), IsNil)
+		c.Check(buf.String(), Equals, v.want, Commentf("%q", v.text))
+	}
+
+	tmpl, err := ParseTemplate(`{{color "pink" "x"}}`)
+	c.Assert(err, IsNil)
+	c.Check(tmpl.Execute(&strings.Builder{}, res), ErrorMatches, `.*unknown color: "pink"`)
+	tmpl, err = ParseTemplate(`{{zcolor "pink" "x"}}{{bcolor "pink" "x"}}`)
+	c.Assert(err, IsNil)
+	c.Check(tmpl.Execute(&strings.Builder{}, res), ErrorMatches, `.*unknown color: "pink"`)
+}
+
+func (s *TemplateSuite) TestTemplateApprox(c *C) {
+	tmpl, err := ParseTemplate(`{{if approx "IsDirty"}}~{{end}}{{if .IsDirty}}*{{end}}{{if approx "Tag"}}?{{end}}`)
+	c.Assert(err, IsNil)
+	facts := Facts{Req: Request{Attr: AttrList{IsDirty: true}}, Found: Attr{IsDirty: true}, Approx: AttrList{IsDirty: true}}
+	var buf strings.Builder
+	c.Check(ExecuteTemplate(&buf, tmpl, facts), IsNil)
+	c.Check(buf.String(), Equals, "~*")
+
+	tmpl, err = ParseTemplate(`{{approx "Nope"}}`)
+	c.Assert(err, IsNil)
+	c.Check(ExecuteTemplate(&strings.Builder{}, tmpl, facts), ErrorMatches, `.*unknown attribute: "Nope"`)
+}
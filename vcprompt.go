@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Compatibility mode with original vcprompt: same flags, same format
+// codes, no trailing newline.
+//
+// Format codes:
+//	%b  branch
+//	%r  revision (short hash for git)
+//	%h  same as %r
+//	%a  action (merge, rebase, …)
+//	%m  "+" if there are uncommitted changes (untracked files ignored)
+//	%u  "?" if there are untracked files
+//	%n  VCS name
+//	%P  current dir relative to repo root
+//	%%  "%"
+// Unknown codes are output as is.
+
+const vcpromptDefaultFormat = "[%n:%b] "
+
+func vcpromptParse(args []string) {
+	fs := flag.NewFlagSet("vcprompt", flag.ExitOnError)
+	format := fs.String("f", vcpromptDefaultFormat, "`format` string")
+	timeout := fs.Int("t", 0, "`timeout` in milliseconds (0 for default)")
+	fs.BoolVar(&cfg.debug, "d", false, "debug mode: log to STDERR")
+	if env := os.Getenv("VCPROMPT_FORMAT"); env != "" {
+		*format = env
+	}
+	if env := os.Getenv("VCPROMPT_TIMEOUT"); env != "" {
+		fmt.Sscan(env, timeout)
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *timeout > 0 {
+		cfg.timeout = time.Duration(*timeout) * time.Millisecond
+	}
+	cfg.attrs = vcpromptAttrs(*format)
+	cfg.req.DirtyIfUntracked = false
+	cfg.output = func(w io.Writer, facts Facts, dir string) {
+		io.WriteString(w, vcprompt(*format, facts.Result(), dir))
+	}
+}
+
+// vcpromptAttrs returns attributes required to output format.
+func vcpromptAttrs(format string) AttrList {
+	l := AttrList{VCS: true}
+	for i := 0; i < len(format)-1; i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'b':
+			l.Branch = true
+		case 'r', 'h':
+			l.RevisionShort = true
+		case 'a':
+			l.State = true
+		case 'm':
+			l.IsDirty = true
+		case 'u':
+			l.HasUntrackedFiles = true
+		}
+	}
+	return l
+}
+
+// vcprompt returns res formatted according to format for current dir
+// (relative to repo root).
+func vcprompt(format string, res Attr, dir string) string {
+	var buf strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			buf.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'b':
+			buf.WriteString(res.Branch)
+		case 'r', 'h':
+			buf.WriteString(res.RevisionShort)
+		case 'a':
+			buf.WriteString(res.State.String())
+		case 'm':
+			if res.IsDirty {
+				buf.WriteByte('+')
+			}
+		case 'u':
+			if res.HasUntrackedFiles {
+				buf.WriteByte('?')
+			}
+		case 'n':
+			buf.WriteString(res.VCS.String())
+		case 'P':
+			buf.WriteString(dir)
+		case '%':
+			buf.WriteByte('%')
+		default:
+			buf.WriteByte('%')
+			buf.WriteByte(format[i])
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,37 @@
+package main
+
+import . "gopkg.in/check.v1"
+
+type VCPromptSuite struct{}
+
+var _ = Suite(&VCPromptSuite{})
+
+func (s *VCPromptSuite) TestAttrs(c *C) {
+	c.Check(vcpromptAttrs(""), DeepEquals, AttrList{VCS: true})
+	c.Check(vcpromptAttrs(vcpromptDefaultFormat), DeepEquals, AttrList{VCS: true, Branch: true})
+	c.Check(vcpromptAttrs("%n:%b%m%u %r %h %a %P %%b %"), DeepEquals, AttrList{
+		VCS:               true,
+		RevisionShort:     true,
+		Branch:            true,
+		State:             true,
+		IsDirty:           true,
+		HasUntrackedFiles: true,
+	})
+}
+
+func (s *VCPromptSuite) TestFormat(c *C) {
+	res := Attr{
+		VCS:           VCSGit,
+		RevisionShort: "0123abc",
+		Branch:        "master",
+		State:         StateRebaseInteractive,
+	}
+	c.Check(vcprompt("", res, "."), Equals, "")
+	c.Check(vcprompt(vcpromptDefaultFormat, res, "."), Equals, "[git:master] ")
+	c.Check(vcprompt("%n:%b%m%u", res, "."), Equals, "git:master")
+	res.IsDirty = true
+	res.HasUntrackedFiles = true
+	c.Check(vcprompt("%n:%b%m%u", res, "."), Equals, "git:master+?")
+	c.Check(vcprompt("%r/%h %a %P", res, "sub/dir"), Equals, "0123abc/0123abc rebase-i sub/dir")
+	c.Check(vcprompt("100%% %x %", res, "."), Equals, "100% %x %")
+}